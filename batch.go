@@ -0,0 +1,207 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	"gopkg.in/errgo.v1"
+)
+
+// BatchRequest holds one of the requests in a batch.
+type BatchRequest struct {
+	// Method holds the HTTP method of the request.
+	Method string `json:"method"`
+
+	// Path holds the path of the request, including
+	// any URL query.
+	Path string `json:"path"`
+
+	// Body holds the JSON request body, if any.
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// BatchResponse holds the response to one of the
+// requests in a batch.
+type BatchResponse struct {
+	// Status holds the HTTP status code of the response.
+	Status int `json:"status"`
+
+	// Body holds the response body. If the handler did not
+	// respond with JSON, the body is encoded as a JSON string.
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// DefaultMaxBatchRequests holds the maximum number of requests
+// in a batch used when BatchDispatcher.MaxRequests is zero.
+const DefaultMaxBatchRequests = 100
+
+// BatchDispatcher dispatches the requests in a batch
+// to a set of handlers without going through the network.
+type BatchDispatcher struct {
+	// MaxRequests holds the maximum number of requests that
+	// may be dispatched in a single batch. If it is zero,
+	// DefaultMaxBatchRequests is used.
+	MaxRequests int
+
+	srv    *Server
+	router *httprouter.Router
+}
+
+// batchKey is the context key used to mark
+// the context of a request made by Dispatch.
+type batchKey struct{}
+
+// NewBatchDispatcher returns a BatchDispatcher that dispatches
+// requests to the given handlers, as returned by srv.Handlers, for
+// example. Errors found when dispatching a request are written using
+// srv.WriteError.
+func (srv *Server) NewBatchDispatcher(hs []Handler) *BatchDispatcher {
+	router := httprouter.New()
+	router.RedirectTrailingSlash = false
+	router.RedirectFixedPath = false
+	AddHandlers(router, hs)
+	return &BatchDispatcher{
+		srv:    srv,
+		router: router,
+	}
+}
+
+// Dispatch calls the handler for each of the given requests in turn and
+// returns their responses in the same order. Each request is made with
+// the context and headers of p.Request, so that, for example, any
+// authorization applies to all the requests in the batch. The
+// Idempotency-Key header is not passed on, because it applies to
+// the batch request as a whole.
+//
+// Dispatch returns an error with an ErrUnmarshal cause if there are
+// more than d.MaxRequests requests, or if it is called to handle a
+// request that is itself part of a batch, so that a batch request
+// cannot multiply the work done by the server by including further
+// batch requests. Note that the latter check relies on the request
+// context, so it is only made when built with Go 1.7 or later.
+//
+// Dispatch is typically called from a handler for a batch endpoint:
+//
+//	type batchRequest struct {
+//		httprequest.Route `httprequest:"POST /batch"`
+//		Requests []httprequest.BatchRequest `httprequest:",body"`
+//	}
+//
+//	func (h *handler) Batch(p httprequest.Params, req *batchRequest) ([]httprequest.BatchResponse, error) {
+//		return h.dispatcher.Dispatch(p, req.Requests)
+//	}
+func (d *BatchDispatcher) Dispatch(p Params, reqs []BatchRequest) ([]BatchResponse, error) {
+	ctx := p.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctx.Value(batchKey{}) != nil {
+		return nil, errgo.WithCausef(nil, ErrUnmarshal, "nested batch requests are not allowed")
+	}
+	max := d.MaxRequests
+	if max == 0 {
+		max = DefaultMaxBatchRequests
+	}
+	if len(reqs) > max {
+		return nil, errgo.WithCausef(nil, ErrUnmarshal, "too many requests in batch (%d, maximum %d)", len(reqs), max)
+	}
+	ctx = context.WithValue(ctx, batchKey{}, true)
+	resps := make([]BatchResponse, len(reqs))
+	for i, breq := range reqs {
+		w := newBatchResponseWriter()
+		req, err := d.newRequest(ctx, p.Request, breq)
+		if err != nil {
+			d.srv.WriteError(ctx, w, errgo.WithCausef(err, ErrUnmarshal, "invalid batch request %d", i))
+		} else {
+			d.router.ServeHTTP(w, req)
+		}
+		resps[i] = w.response()
+	}
+	return resps, nil
+}
+
+// newRequest returns the HTTP request corresponding to the
+// given batch request, made as part of the batch request
+// preq with the given context.
+func (d *BatchDispatcher) newRequest(ctx context.Context, preq *http.Request, breq BatchRequest) (*http.Request, error) {
+	req, err := http.NewRequest(breq.Method, breq.Path, bytes.NewReader(breq.Body))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for k, v := range preq.Header {
+		req.Header[k] = v
+	}
+	// The idempotency key identifies the batch request as a
+	// whole, so it must not be used for the requests in it.
+	req.Header.Del("Idempotency-Key")
+	req.Header.Del("Content-Length")
+	if len(breq.Body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Del("Content-Type")
+	}
+	req.RemoteAddr = preq.RemoteAddr
+	return requestWithContext(req, ctx), nil
+}
+
+// batchResponseWriter is an http.ResponseWriter that
+// records the response to a request in a batch.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchResponseWriter() *batchResponseWriter {
+	return &batchResponseWriter{
+		header: make(http.Header),
+	}
+}
+
+// Header implements http.ResponseWriter.Header.
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// Write implements http.ResponseWriter.Write.
+func (w *batchResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader.
+func (w *batchResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+// response returns the recorded response.
+func (w *batchResponseWriter) response() BatchResponse {
+	resp := BatchResponse{
+		Status: w.status,
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	body := bytes.TrimSpace(w.body.Bytes())
+	switch {
+	case len(body) == 0:
+	case isJSONMediaType(w.header):
+		resp.Body = body
+	default:
+		// The body isn't JSON, so encode it as a string so that
+		// the whole batch response remains valid JSON.
+		data, _ := json.Marshal(string(body))
+		resp.Body = data
+	}
+	return resp
+}
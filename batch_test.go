@@ -0,0 +1,245 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/juju/testing/httptesting"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type batchSuite struct{}
+
+var _ = gc.Suite(&batchSuite{})
+
+type batchHandlers struct {
+	p          httprequest.Params
+	dispatcher *httprequest.BatchDispatcher
+}
+
+func (h *batchHandlers) Add(arg *struct {
+	httprequest.Route `httprequest:"GET /add/:A"`
+	A                 int `httprequest:",path"`
+	B                 int `httprequest:"b,form"`
+}) (int, error) {
+	return arg.A + arg.B, nil
+}
+
+func (h *batchHandlers) Echo(arg *struct {
+	httprequest.Route `httprequest:"POST /echo"`
+	Body              map[string]int `httprequest:",body"`
+}) (map[string]int, error) {
+	return arg.Body, nil
+}
+
+func (h *batchHandlers) Token(arg *struct {
+	httprequest.Route `httprequest:"GET /token"`
+	Token             string `httprequest:"Token,header"`
+}) (string, error) {
+	return arg.Token, nil
+}
+
+func (h *batchHandlers) Fail(arg *struct {
+	httprequest.Route `httprequest:"GET /fail"`
+}) error {
+	return errBadReq
+}
+
+func (h *batchHandlers) Batch(arg *struct {
+	httprequest.Route `httprequest:"POST /batch"`
+	Requests          []httprequest.BatchRequest `httprequest:",body"`
+}) ([]httprequest.BatchResponse, error) {
+	return h.dispatcher.Dispatch(h.p, arg.Requests)
+}
+
+func newBatchRouter() *httprouter.Router {
+	return newBatchRouterWithServer(testServer)
+}
+
+func newBatchRouterWithServer(srv httprequest.Server) *httprouter.Router {
+	var dispatcher *httprequest.BatchDispatcher
+	hs := srv.Handlers(func(p httprequest.Params) (*batchHandlers, context.Context, error) {
+		return &batchHandlers{
+			p:          p,
+			dispatcher: dispatcher,
+		}, p.Context, nil
+	})
+	dispatcher = srv.NewBatchDispatcher(hs)
+	router := httprouter.New()
+	httprequest.AddHandlers(router, hs)
+	return router
+}
+
+func (*batchSuite) TestBatch(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Method:  "POST",
+		URL:     "/batch",
+		Handler: newBatchRouter(),
+		Header: http.Header{
+			"Token": {"secret"},
+		},
+		JSONBody: []httprequest.BatchRequest{{
+			Method: "GET",
+			Path:   "/add/1?b=2",
+		}, {
+			Method: "POST",
+			Path:   "/echo",
+			Body:   json.RawMessage(`{"x":1}`),
+		}, {
+			Method: "GET",
+			Path:   "/token",
+		}, {
+			Method: "GET",
+			Path:   "/fail",
+		}, {
+			Method: "GET",
+			Path:   "/add/x",
+		}, {
+			Method: "GET",
+			Path:   "/notfound",
+		}},
+		ExpectBody: []httprequest.BatchResponse{{
+			Status: http.StatusOK,
+			Body:   json.RawMessage(`3`),
+		}, {
+			Status: http.StatusOK,
+			Body:   json.RawMessage(`{"x":1}`),
+		}, {
+			Status: http.StatusOK,
+			Body:   json.RawMessage(`"secret"`),
+		}, {
+			Status: http.StatusBadRequest,
+			Body:   json.RawMessage(`{"Message":"bad request","Code":"bad request"}`),
+		}, {
+			Status: http.StatusBadRequest,
+			Body:   json.RawMessage(`{"Message":"cannot unmarshal parameters: cannot unmarshal into field A: cannot parse path parameter \"A\" value \"x\" into int: expected integer","Code":"bad request"}`),
+		}, {
+			Status: http.StatusNotFound,
+			Body:   json.RawMessage(`"404 page not found"`),
+		}},
+	})
+}
+
+func (*batchSuite) TestBatchWithIdempotencyStore(c *gc.C) {
+	srv := testServer
+	srv.IdempotencyStore = new(memStore)
+	router := newBatchRouterWithServer(srv)
+	reqs := []httprequest.BatchRequest{{
+		Method: "POST",
+		Path:   "/echo",
+		Body:   json.RawMessage(`{"a":1}`),
+	}, {
+		Method: "POST",
+		Path:   "/echo",
+		Body:   json.RawMessage(`{"b":2}`),
+	}}
+	expect := []httprequest.BatchResponse{{
+		Status: http.StatusOK,
+		Body:   json.RawMessage(`{"a":1}`),
+	}, {
+		Status: http.StatusOK,
+		Body:   json.RawMessage(`{"b":2}`),
+	}}
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Method:  "POST",
+		URL:     "/batch",
+		Handler: router,
+		Header: http.Header{
+			"Idempotency-Key": {"k1"},
+		},
+		JSONBody:   reqs,
+		ExpectBody: expect,
+	})
+	// A retry of the whole batch is replayed.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Method:  "POST",
+		URL:     "/batch",
+		Handler: router,
+		Header: http.Header{
+			"Idempotency-Key": {"k1"},
+		},
+		JSONBody:   reqs,
+		ExpectBody: expect,
+		ExpectHeader: http.Header{
+			"Idempotent-Replayed": {"true"},
+		},
+	})
+}
+
+func (*batchSuite) TestDispatchWithBadPath(c *gc.C) {
+	d := testServer.NewBatchDispatcher(nil)
+	resps, err := d.Dispatch(httprequest.Params{
+		Request: &http.Request{},
+		Context: context.Background(),
+	}, []httprequest.BatchRequest{{
+		Method: "GET",
+		Path:   "%zz",
+	}})
+	c.Assert(err, gc.IsNil)
+	c.Assert(resps, gc.HasLen, 1)
+	c.Assert(resps[0].Status, gc.Equals, http.StatusBadRequest)
+	var body httprequest.RemoteError
+	err = json.Unmarshal(resps[0].Body, &body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(body.Code, gc.Equals, "bad request")
+	c.Assert(body.Message, gc.Matches, `invalid batch request 0: parse .*: invalid URL escape "%zz"`)
+}
+
+func (*batchSuite) TestDispatchEmpty(c *gc.C) {
+	d := testServer.NewBatchDispatcher(nil)
+	resps, err := d.Dispatch(httprequest.Params{
+		Request: &http.Request{},
+		Context: context.Background(),
+	}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resps, gc.HasLen, 0)
+}
+
+func (*batchSuite) TestNestedBatch(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Method:  "POST",
+		URL:     "/batch",
+		Handler: newBatchRouter(),
+		JSONBody: []httprequest.BatchRequest{{
+			Method: "GET",
+			Path:   "/add/1?b=2",
+		}, {
+			Method: "POST",
+			Path:   "/batch",
+			Body:   json.RawMessage(`[{"method":"GET","path":"/add/1?b=2"}]`),
+		}},
+		ExpectBody: []httprequest.BatchResponse{{
+			Status: http.StatusOK,
+			Body:   json.RawMessage(`3`),
+		}, {
+			Status: http.StatusBadRequest,
+			Body:   json.RawMessage(`{"Message":"nested batch requests are not allowed","Code":"bad request"}`),
+		}},
+	})
+}
+
+func (*batchSuite) TestDispatchTooManyRequests(c *gc.C) {
+	d := testServer.NewBatchDispatcher(nil)
+	d.MaxRequests = 2
+	p := httprequest.Params{
+		Request: &http.Request{},
+		Context: context.Background(),
+	}
+	reqs := make([]httprequest.BatchRequest, 3)
+	_, err := d.Dispatch(p, reqs)
+	c.Assert(err, gc.ErrorMatches, `too many requests in batch \(3, maximum 2\)`)
+	c.Assert(errgo.Cause(err), gc.Equals, httprequest.ErrUnmarshal)
+
+	d.MaxRequests = 0
+	reqs = make([]httprequest.BatchRequest, httprequest.DefaultMaxBatchRequests+1)
+	_, err = d.Dispatch(p, reqs)
+	c.Assert(err, gc.ErrorMatches, `too many requests in batch \(101, maximum 100\)`)
+}
@@ -149,6 +149,32 @@ func BenchmarkHandle2Fields(b *testing.B) {
 	}).Handle)
 }
 
+// testParams2FieldsUnmarshaler is like testParams2Fields
+// except that it implements httprequest.ParamsUnmarshaler.
+type testParams2FieldsUnmarshaler testParams2Fields
+
+func (arg *testParams2FieldsUnmarshaler) UnmarshalParams(p httprequest.Params) error {
+	arg.Id = p.PathVar.ByName("id")
+	if limitStr := p.Request.Form.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return errgo.Notef(err, "bad limit")
+		}
+		arg.Limit = limit
+	}
+	return nil
+}
+
+func BenchmarkHandle2FieldsParamsUnmarshaler(b *testing.B) {
+	results := []testResult{}
+	benchmarkHandle2Fields(b, testServer.Handle(func(p httprequest.Params, arg *testParams2FieldsUnmarshaler) ([]testResult, error) {
+		if arg.Limit <= 0 {
+			panic("unreachable")
+		}
+		return results, nil
+	}).Handle)
+}
+
 func BenchmarkHandle2FieldsUnmarshalOnly(b *testing.B) {
 	results := []testResult{}
 	benchmarkHandle2Fields(b, testServer.HandleJSON(func(p httprequest.Params) (interface{}, error) {
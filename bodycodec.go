@@ -0,0 +1,75 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"reflect"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+var (
+	bodyCodecMutex sync.RWMutex
+	bodyCodecMap   = make(map[reflect.Type]BodyCodec)
+)
+
+// BodyCodec is implemented by values that can encode and decode
+// request bodies of a particular type. See RegisterBodyCodec.
+type BodyCodec interface {
+	// MarshalBody returns the encoded form of the value
+	// pointed to by v.
+	MarshalBody(v interface{}) ([]byte, error)
+
+	// UnmarshalBody decodes data into the value
+	// pointed to by v.
+	UnmarshalBody(data []byte, v interface{}) error
+}
+
+// RegisterBodyCodec registers a codec to use instead of encoding/json
+// when marshaling or unmarshaling a body field of the given type, or
+// a pointer to it. This can be used to support a legacy or versioned
+// wire format that cannot be implemented with the json.Marshaler and
+// json.Unmarshaler interfaces. The codec is also used when the type
+// is registered as a concrete type with RegisterBodyType.
+//
+// The codec is passed a pointer to a value of type t.
+//
+// Note that the content type of the body is not changed by the
+// codec, so an "httpcontenttype" tag will usually be needed on
+// the body field too. Codecs replace encoding/json only, so they
+// are not used for body fields with the "yaml" attribute, which
+// are always encoded as YAML.
+//
+// The registry is package-level rather than held by Server or
+// Client, as for RegisterBodyType: request types are parsed and
+// cached independently of any Server or Client, and Marshal and
+// Unmarshal are used without one, so a package-level registry is the
+// only way for both to see the same codecs. Because of that, codecs
+// are intended to be registered at init time and cannot be removed,
+// and a second registration for the same type, which would change
+// the encoding used by code that relies on the first, is treated as
+// a programming error.
+//
+// RegisterBodyCodec panics if a codec has already been registered
+// for the type.
+func RegisterBodyCodec(t reflect.Type, codec BodyCodec) {
+	if t == nil || codec == nil {
+		panic(errgo.New("cannot register nil body codec or type"))
+	}
+	bodyCodecMutex.Lock()
+	defer bodyCodecMutex.Unlock()
+	if bodyCodecMap[t] != nil {
+		panic(errgo.Newf("body codec already registered for %s", t))
+	}
+	bodyCodecMap[t] = codec
+}
+
+// getBodyCodec returns the codec registered
+// for the given type, or nil if there is none.
+func getBodyCodec(t reflect.Type) BodyCodec {
+	bodyCodecMutex.RLock()
+	defer bodyCodecMutex.RUnlock()
+	return bodyCodecMap[t]
+}
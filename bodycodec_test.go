@@ -0,0 +1,103 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type bodyCodecSuite struct{}
+
+var _ = gc.Suite(&bodyCodecSuite{})
+
+// legacyVersion is encoded in a legacy "v=<n>" wire format
+// by legacyCodec.
+type legacyVersion struct {
+	N int
+}
+
+type legacyCodec struct{}
+
+func (legacyCodec) MarshalBody(v interface{}) ([]byte, error) {
+	return []byte("v=" + strconv.Itoa(v.(*legacyVersion).N)), nil
+}
+
+func (legacyCodec) UnmarshalBody(data []byte, v interface{}) error {
+	s := string(data)
+	if !strings.HasPrefix(s, "v=") {
+		return errgo.Newf("invalid legacy version %q", s)
+	}
+	n, err := strconv.Atoi(s[2:])
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	v.(*legacyVersion).N = n
+	return nil
+}
+
+func init() {
+	httprequest.RegisterBodyCodec(reflect.TypeOf(legacyVersion{}), legacyCodec{})
+}
+
+type legacyRequest struct {
+	httprequest.Route `httprequest:"PUT /version"`
+	Body              legacyVersion `httprequest:",body" httpcontenttype:"application/x-legacy"`
+}
+
+type legacyPointerRequest struct {
+	httprequest.Route `httprequest:"PUT /version"`
+	Body              *legacyVersion `httprequest:",body" httpcontenttype:"application/x-legacy"`
+}
+
+func (*bodyCodecSuite) TestMarshalAndUnmarshal(c *gc.C) {
+	req, err := httprequest.Marshal("http://example.com/version", "PUT", &legacyRequest{
+		Body: legacyVersion{N: 42},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(req.Header.Get("Content-Type"), gc.Equals, "application/x-legacy")
+	data, err := ioutil.ReadAll(req.Body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "v=42")
+
+	for _, x := range []interface{}{new(legacyRequest), new(legacyPointerRequest)} {
+		req.Body = ioutil.NopCloser(strings.NewReader("v=42"))
+		err = httprequest.Unmarshal(httprequest.Params{
+			Request: req,
+		}, x)
+		c.Assert(err, gc.IsNil)
+		switch x := x.(type) {
+		case *legacyRequest:
+			c.Assert(x.Body, jc.DeepEquals, legacyVersion{N: 42})
+		case *legacyPointerRequest:
+			c.Assert(x.Body, jc.DeepEquals, &legacyVersion{N: 42})
+		}
+	}
+}
+
+func (*bodyCodecSuite) TestUnmarshalError(c *gc.C) {
+	req, err := http.NewRequest("PUT", "http://example.com/version", strings.NewReader("version 42"))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "application/x-legacy")
+	var x legacyRequest
+	err = httprequest.Unmarshal(httprequest.Params{
+		Request: req,
+	}, &x)
+	c.Assert(err, gc.ErrorMatches, `cannot unmarshal into field Body: cannot unmarshal request body: invalid legacy version "version 42"`)
+}
+
+func (*bodyCodecSuite) TestRegisterTwice(c *gc.C) {
+	c.Assert(func() {
+		httprequest.RegisterBodyCodec(reflect.TypeOf(legacyVersion{}), legacyCodec{})
+	}, gc.PanicMatches, `body codec already registered for httprequest_test.legacyVersion`)
+}
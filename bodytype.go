@@ -0,0 +1,165 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+var (
+	bodyTypeMutex sync.RWMutex
+	bodyTypeMap   = make(map[reflect.Type]*bodyTypes)
+)
+
+// bodyTypes holds the concrete types registered
+// for an interface type.
+type bodyTypes struct {
+	// field holds the name of the JSON field
+	// that holds the discriminator.
+	field string
+
+	// types maps from discriminator value to
+	// the type to unmarshal into.
+	types map[string]reflect.Type
+}
+
+// RegisterBodyType registers a concrete type to use when unmarshaling
+// a request body into a body field of interface type. The iface
+// argument must be a nil pointer to the interface type, proto holds
+// a value of the concrete type, which must implement the interface.
+//
+// When a request body is unmarshaled into a field of the interface
+// type, the value of the field with the given name in the top level
+// JSON object in the body is used to choose the concrete type. If it
+// is equal to the given value, a new value with the same type as proto
+// is created, the body unmarshaled into it, and the field set to that
+// value.
+//
+// For example:
+//
+//	type Event interface{}
+//
+//	type ClickEvent struct {
+//		Type string `json:"type"`
+//		X, Y int
+//	}
+//
+//	httprequest.RegisterBodyType((*Event)(nil), "type", "click", &ClickEvent{})
+//
+//	type IngestRequest struct {
+//		httprequest.Route `httprequest:"POST /events"`
+//		Event Event `httprequest:",body"`
+//	}
+//
+// If no types have been registered for an interface type, the body
+// is unmarshaled into a field of that type as usual.
+//
+// RegisterBodyType panics if the arguments are not of the required
+// form, if the same value is registered twice for the interface type,
+// or if a different field name was used to register other
+// types for the interface type.
+func RegisterBodyType(iface interface{}, field, value string, proto interface{}) {
+	it := reflect.TypeOf(iface)
+	if it == nil || it.Kind() != reflect.Ptr || it.Elem().Kind() != reflect.Interface {
+		panic(errgo.Newf("cannot register body type for %T; need pointer to interface", iface))
+	}
+	it = it.Elem()
+	pt := reflect.TypeOf(proto)
+	if pt == nil || !pt.Implements(it) {
+		panic(errgo.Newf("cannot register body type %T for %s: does not implement interface", proto, it))
+	}
+	bodyTypeMutex.Lock()
+	defer bodyTypeMutex.Unlock()
+	bt := bodyTypeMap[it]
+	if bt == nil {
+		bt = &bodyTypes{
+			field: field,
+			types: make(map[string]reflect.Type),
+		}
+		bodyTypeMap[it] = bt
+	}
+	if bt.field != field {
+		panic(errgo.Newf("cannot register body type %T for %s: discriminator field %q does not match %q", proto, it, field, bt.field))
+	}
+	if old := bt.types[value]; old != nil {
+		panic(errgo.Newf("cannot register body type %T for %s: %s %q already registered to %s", proto, it, field, value, old))
+	}
+	bt.types[value] = pt
+}
+
+// getBodyTypes returns the body types registered
+// for the given interface type, or nil if there are none.
+func getBodyTypes(t reflect.Type) *bodyTypes {
+	bodyTypeMutex.RLock()
+	defer bodyTypeMutex.RUnlock()
+	return bodyTypeMap[t]
+}
+
+// lookup returns the type registered for the discriminator value in
+// the given JSON data.
+func (bt *bodyTypes) lookup(data []byte) (reflect.Type, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal request body")
+	}
+	var value string
+	if raw, ok := fields[bt.field]; ok {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal %s field in request body", bt.field)
+		}
+	}
+	bodyTypeMutex.RLock()
+	defer bodyTypeMutex.RUnlock()
+	t := bt.types[value]
+	if t == nil {
+		return nil, errgo.Newf("unknown %s %q in request body", bt.field, value)
+	}
+	return t, nil
+}
+
+// unmarshalInterfaceBody returns an unmarshaler that unmarshals
+// the http request body into a field of the given interface type,
+// using the types registered with RegisterBodyType to choose
+// the concrete type. The contentType argument is as for
+// unmarshalBody.
+func unmarshalInterfaceBody(t reflect.Type, contentType string) unmarshaler {
+	plainUnmarshal := unmarshalBody(contentType)
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		bt := getBodyTypes(t)
+		if bt == nil {
+			return plainUnmarshal(v, p, makeResult)
+		}
+		if !isBodyMediaType(p.Request.Header, contentType) {
+			fancyErr := newFancyDecodeError(p.Request.Header, p.Request.Body)
+			return newDecodeRequestError(p.Request, fancyErr.body, fancyErr)
+		}
+		data, err := ioutil.ReadAll(p.Request.Body)
+		if err != nil {
+			return errgo.Notef(err, "cannot read request body")
+		}
+		ct, err := bt.lookup(data)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		var cv reflect.Value
+		if ct.Kind() == reflect.Ptr {
+			cv = reflect.New(ct.Elem())
+			if err := unmarshalBodyData(data, cv.Elem()); err != nil {
+				return errgo.Mask(err)
+			}
+		} else {
+			cv = reflect.New(ct).Elem()
+			if err := unmarshalBodyData(data, cv); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+		makeResult(v).Set(cv)
+		return nil
+	}
+}
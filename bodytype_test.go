@@ -0,0 +1,168 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type bodyTypeSuite struct{}
+
+var _ = gc.Suite(&bodyTypeSuite{})
+
+type testEvent interface {
+	eventName() string
+}
+
+type clickEvent struct {
+	Type string `json:"type"`
+	X, Y int
+}
+
+func (*clickEvent) eventName() string {
+	return "click"
+}
+
+type keyEvent struct {
+	Type string `json:"type"`
+	Key  string
+}
+
+func (keyEvent) eventName() string {
+	return "key"
+}
+
+func init() {
+	httprequest.RegisterBodyType((*testEvent)(nil), "type", "click", &clickEvent{})
+	httprequest.RegisterBodyType((*testEvent)(nil), "type", "key", keyEvent{})
+}
+
+type eventRequest struct {
+	Event testEvent `httprequest:",body"`
+}
+
+var unmarshalBodyTypeTests = []struct {
+	about       string
+	contentType string
+	body        string
+	expect      testEvent
+	expectError string
+}{{
+	about:  "pointer type",
+	body:   `{"type": "click", "X": 1, "Y": 2}`,
+	expect: &clickEvent{Type: "click", X: 1, Y: 2},
+}, {
+	about:  "value type",
+	body:   `{"type": "key", "Key": "a"}`,
+	expect: keyEvent{Type: "key", Key: "a"},
+}, {
+	about:       "unknown type",
+	body:        `{"type": "scroll"}`,
+	expectError: `cannot unmarshal into field Event: unknown type "scroll" in request body`,
+}, {
+	about:       "no discriminator",
+	body:        `{"Key": "a"}`,
+	expectError: `cannot unmarshal into field Event: unknown type "" in request body`,
+}, {
+	about:       "discriminator not a string",
+	body:        `{"type": 1}`,
+	expectError: `cannot unmarshal into field Event: cannot unmarshal type field in request body: json: cannot unmarshal number into Go value of type string`,
+}, {
+	about:       "body not an object",
+	body:        `[]`,
+	expectError: `cannot unmarshal into field Event: cannot unmarshal request body: json: cannot unmarshal array into Go value of type map\[string\].*`,
+}, {
+	about:       "wrong content type",
+	contentType: "text/plain",
+	body:        `something`,
+	expectError: `cannot unmarshal into field Event: unexpected content type text/plain; want application/json; content: something`,
+}}
+
+func (*bodyTypeSuite) TestUnmarshal(c *gc.C) {
+	for i, test := range unmarshalBodyTypeTests {
+		c.Logf("test %d: %s", i, test.about)
+		contentType := test.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		var req eventRequest
+		err := httprequest.Unmarshal(httprequest.Params{
+			Request: &http.Request{
+				Header: http.Header{"Content-Type": {contentType}},
+				Body:   body(test.body),
+			},
+		}, &req)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(req.Event, jc.DeepEquals, test.expect)
+	}
+}
+
+func (*bodyTypeSuite) TestUnmarshalWithoutRegisteredTypes(c *gc.C) {
+	var req struct {
+		Body interface{} `httprequest:",body"`
+	}
+	err := httprequest.Unmarshal(httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   body(`{"type": "click"}`),
+		},
+	}, &req)
+	c.Assert(err, gc.IsNil)
+	c.Assert(req.Body, jc.DeepEquals, map[string]interface{}{"type": "click"})
+}
+
+var registerBodyTypePanicTests = []struct {
+	about       string
+	iface       interface{}
+	field       string
+	value       string
+	proto       interface{}
+	expectPanic string
+}{{
+	about:       "not a pointer to interface",
+	iface:       testEvent(nil),
+	field:       "type",
+	value:       "x",
+	proto:       &clickEvent{},
+	expectPanic: `cannot register body type for <nil>; need pointer to interface`,
+}, {
+	about:       "does not implement interface",
+	iface:       (*testEvent)(nil),
+	field:       "type",
+	value:       "x",
+	proto:       clickEvent{},
+	expectPanic: `cannot register body type httprequest_test.clickEvent for httprequest_test.testEvent: does not implement interface`,
+}, {
+	about:       "value already registered",
+	iface:       (*testEvent)(nil),
+	field:       "type",
+	value:       "click",
+	proto:       keyEvent{},
+	expectPanic: `cannot register body type httprequest_test.keyEvent for httprequest_test.testEvent: type "click" already registered to \*httprequest_test.clickEvent`,
+}, {
+	about:       "different discriminator field",
+	iface:       (*testEvent)(nil),
+	field:       "kind",
+	value:       "x",
+	proto:       keyEvent{},
+	expectPanic: `cannot register body type httprequest_test.keyEvent for httprequest_test.testEvent: discriminator field "kind" does not match "type"`,
+}}
+
+func (*bodyTypeSuite) TestRegisterBodyTypePanics(c *gc.C) {
+	for i, test := range registerBodyTypePanicTests {
+		c.Logf("test %d: %s", i, test.about)
+		c.Assert(func() {
+			httprequest.RegisterBodyType(test.iface, test.field, test.value, test.proto)
+		}, gc.PanicMatches, test.expectPanic)
+	}
+}
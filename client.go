@@ -34,7 +34,12 @@ type DoerWithContext interface {
 // Client represents a client that can invoke httprequest endpoints.
 type Client struct {
 	// BaseURL holds the base URL to use when making
-	// HTTP requests.
+	// HTTP requests. It may include a path prefix, such as
+	// "https://example.com/api/v1", which will be preserved, with
+	// the request path appended to it, and a query, whose
+	// parameters will be sent before any others. Parameters with
+	// the same name as a form field are replaced by Call but not
+	// by Do. See JoinURL for details.
 	BaseURL string
 
 	// Doer holds a value that will be used to actually
@@ -49,6 +54,124 @@ type Client struct {
 	// way to create an UnmarshalError function for a given type. If
 	// this is nil, DefaultErrorUnmarshaler will be used.
 	UnmarshalError func(resp *http.Response) error
+
+	// FollowRedirects holds the maximum number of redirect
+	// responses that Do will follow for GET and HEAD requests by
+	// reissuing the request to the redirect location. A redirect
+	// response that is not followed is treated as an error.
+	//
+	// When a redirect is to a different host, the Authorization
+	// and Cookie headers, and other headers that may hold
+	// credentials, are not sent to it. Any other headers that
+	// hold credentials should be added by SignRequest, which is
+	// called again for each redirected request.
+	//
+	// Note that redirects will only be seen by Do if Doer
+	// does not follow them itself; http.DefaultClient does.
+	FollowRedirects int
+
+	// If a request returns an HTTP response that signifies success,
+	// DecodeResponse is used to unmarshal the response into the
+	// value pointed to by x. It is not called if the response value
+	// passed to Call or Do is nil. If this is nil,
+	// UnmarshalJSONResponse will be used.
+	DecodeResponse func(resp *http.Response, x interface{}) error
+
+	// AllowNonJSONContentType specifies that the body of a
+	// successful response should be unmarshaled as JSON
+	// regardless of its Content-Type header. By default,
+	// a response without a JSON content type causes a
+	// *DecodeResponseError with Kind ContentTypeMismatch.
+	// It has no effect when DecodeResponse is set.
+	AllowNonJSONContentType bool
+
+	// MaxResponseBodySize holds the maximum number of bytes that
+	// will be read from the body of a successful response when
+	// decoding it. If the body is larger, Call and Do return an
+	// error; when the response is decoded with
+	// UnmarshalJSONResponse, this is a *DecodeResponseError with
+	// Kind BodyReadError. If it is zero, there is no limit. Error
+	// response bodies are limited separately.
+	MaxResponseBodySize int64
+
+	// SignRequest, if non-nil, is called with each request made by
+	// Call, CallURL, Do and DoResponse just before it is passed to
+	// the Doer, after the request has been fully assembled,
+	// including the BaseURL. It may modify the request, typically
+	// to add a header holding a signature. The body argument holds
+	// the request body, which may be read to compute a hash of it;
+	// it is empty if the request has no body, and it will be reset
+	// to the start before the request is sent. If SignRequest
+	// returns an error, the request is not sent.
+	//
+	// SignRequest is also called for each request made to follow a
+	// redirect (see FollowRedirects).
+	SignRequest func(req *http.Request, body io.ReadSeeker) error
+
+	// StrictBodyMethods specifies that Call and CallURL should
+	// return an error rather than send a request with a body
+	// unless its method is POST, PUT, PATCH or DELETE. By default, a
+	// body is sent with any method (see Marshal).
+	StrictBodyMethods bool
+
+	// TransformResponse, if non-nil, is called with each response
+	// received by Call, CallURL, Do and DoResponse before it is
+	// processed, whether or not it has an error status. The
+	// response it returns is used in place of the original, so it
+	// can be used, for example, to remove an envelope such as
+	// {"data": ...} that a server wraps around every response body
+	// before the body is unmarshaled. If it replaces the response
+	// body, it is responsible for closing the original one.
+	//
+	// If TransformResponse returns an error, the original response
+	// body is closed and the error is returned with its cause
+	// preserved.
+	TransformResponse func(resp *http.Response) (*http.Response, error)
+}
+
+// NewClient returns a Client that makes requests relative to baseURL
+// using hc, which is typically an *http.Client configured with a
+// custom transport, cookie jar or timeout. If hc is nil,
+// http.DefaultClient will be used.
+//
+// All of hc's settings are respected because every request is made
+// with hc.Do: cookies in its Jar are sent with each request and
+// updated from each response, and its Timeout limits the time taken
+// by each request, including reading the response body, in addition
+// to any deadline on the context passed to Call or Do. Note that hc
+// follows redirects itself unless its CheckRedirect function says
+// otherwise, so Client.FollowRedirects will usually have no effect.
+func NewClient(baseURL string, hc *http.Client) *Client {
+	c := &Client{
+		BaseURL: baseURL,
+	}
+	if hc != nil {
+		c.Doer = hc
+	}
+	return c
+}
+
+// Clone returns a copy of c that can be changed, for example to use a
+// different BaseURL for a single call, without affecting c or any
+// other clone of it. The Doer and any function fields are shared with
+// c, so they should be safe for concurrent use if the copies are used
+// concurrently.
+func (c *Client) Clone() *Client {
+	// None of the fields currently hold mutable values
+	// such as maps or slices, so a shallow copy is
+	// sufficient. Any such fields added in the future
+	// must be copied explicitly here.
+	c1 := *c
+	return &c1
+}
+
+// bodyMethods holds the methods that may be used to send
+// a request with a body when Client.StrictBodyMethods is set.
+var bodyMethods = map[string]bool{
+	"POST":   true,
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
 }
 
 // DefaultErrorUnmarshaler is the default error unmarshaler
@@ -65,15 +188,36 @@ var DefaultErrorUnmarshaler = ErrorUnmarshaler(new(RemoteError))
 // will be called to add additional headers to the HTTP request.
 //
 // If resp is nil, the response will be ignored if the
-// request was successful.
+// request was successful. If the response has a 204 (No Content)
+// status, the body will not be decoded.
+//
+// If resp is a pointer to a struct, after the body has been decoded,
+// any fields tagged as response fields will be filled out from the
+// response itself. A field with a "status" tag, for example:
+//
+//	Status int `httprequest:",status" json:"-"`
+//
+// will be set to the status code of the response, and a field with a
+// "responseheader" tag, for example:
+//
+//	Total int `httprequest:"X-Total-Count,responseheader" json:"-"`
+//
+// will be filled out from the named response header in the same way
+// that Unmarshal fills out header fields.
 //
 // If resp is of type **http.Response, instead of unmarshaling
 // into it, its element will be set to the returned HTTP
 // response directly and the caller is responsible for
-// closing its Body field.
+// closing its Body field. This only happens when the response
+// has a status code signifying success; otherwise the error is
+// returned and the element is left unchanged.
 //
-// Any error that c.UnmarshalError or c.Doer returns will not
-// have its cause masked.
+// In all other cases, the response body will have been closed when
+// Call returns. Any unread data in the body (up to a limit) is read
+// before closing, so that the underlying connection may be reused.
+//
+// Any error that c.UnmarshalError, c.DecodeResponse or c.Doer returns
+// will not have its cause masked.
 //
 // If the request returns a response with a status code signifying
 // success, but the response could not be unmarshaled, a
@@ -103,6 +247,9 @@ func (c *Client) CallURL(ctx context.Context, url string, params, resp interface
 	if err != nil {
 		return errgo.Mask(err)
 	}
+	if c.StrictBodyMethods && req.ContentLength > 0 && !bodyMethods[req.Method] {
+		return errgo.Newf("cannot send body with %s request", req.Method)
+	}
 	return c.Do(ctx, req, resp)
 }
 
@@ -112,15 +259,17 @@ func (c *Client) CallURL(ctx context.Context, url string, params, resp interface
 // as in Client.Call.
 //
 // If resp is nil, the response will be ignored if the response was
-// successful.
+// successful. Otherwise it will be unmarshaled as in Client.Call,
+// including any response fields.
 //
 // If resp is of type **http.Response, instead of unmarshaling
 // into it, its element will be set to the returned HTTP
 // response directly and the caller is responsible for
-// closing its Body field.
+// closing its Body field. As for Call, the response body
+// is otherwise always drained and closed.
 //
-// Any error that c.UnmarshalError or c.Doer returns will not
-// have its cause masked.
+// Any error that c.UnmarshalError, c.DecodeResponse or c.Doer returns
+// will not have its cause masked.
 //
 // If req.URL does not have a host part it will be treated as relative to
 // c.BaseURL. req.URL will be updated to the actual URL used.
@@ -129,28 +278,164 @@ func (c *Client) CallURL(ctx context.Context, url string, params, resp interface
 // will be returned holding the response from the request.
 // the entire response body.
 func (c *Client) Do(ctx context.Context, req *http.Request, resp interface{}) error {
+	httpResp, err := c.doRequest(ctx, req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	_, err = c.unmarshalResponse(httpResp, resp)
+	return err
+}
+
+// DoResponse is like Do except that it also returns the HTTP response,
+// so that its status and headers can be inspected, for example to
+// find pagination links, while the body is still unmarshaled into
+// resp. The response is returned whenever one was received, even if
+// an error is also returned because it has an error status or its
+// body could not be unmarshaled.
+//
+// As with Do, the response body is drained and closed before
+// DoResponse returns, so the returned response's Body should not be
+// read. Passing a resp value of type **http.Response is not useful;
+// use Do for that instead.
+func (c *Client) DoResponse(ctx context.Context, req *http.Request, resp interface{}) (*http.Response, error) {
+	httpResp, err := c.doRequest(ctx, req)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	httpResp, err = c.unmarshalResponse(httpResp, resp)
+	// Note: the error is returned unmasked so that it
+	// can still be unwrapped by the errors package.
+	return httpResp, err
+}
+
+// doRequest sends the given request, following redirects
+// as specified by c.FollowRedirects, and returns the
+// response.
+func (c *Client) doRequest(ctx context.Context, req *http.Request) (*http.Response, error) {
 	if req.URL.Host == "" {
 		var err error
 		req.URL, err = appendURL(c.BaseURL, req.URL.String())
 		if err != nil {
-			return errgo.Mask(err)
+			return nil, errgo.Mask(err)
+		}
+	}
+	httpResp, err := c.do(ctx, req)
+	for redirects := 0; err == nil && redirects < c.FollowRedirects; redirects++ {
+		req1 := redirectRequest(req, httpResp)
+		if req1 == nil {
+			break
+		}
+		drainAndClose(httpResp.Body)
+		req = req1
+		httpResp, err = c.do(ctx, req)
+	}
+	if err != nil {
+		return nil, errgo.Mask(urlError(err, req), errgo.Any)
+	}
+	return httpResp, nil
+}
+
+// do uses c.Doer to make the given HTTP request.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if c.SignRequest != nil {
+		if err := c.signRequest(req); err != nil {
+			return nil, errgo.Mask(err)
 		}
 	}
 	doer := c.Doer
 	if doer == nil {
 		doer = http.DefaultClient
 	}
-	var httpResp *http.Response
-	var err error
 	if ctxDoer, ok := doer.(DoerWithContext); ok {
-		httpResp, err = ctxDoer.DoWithContext(ctx, req)
-	} else {
-		httpResp, err = doer.Do(requestWithContext(req, ctx))
+		return ctxDoer.DoWithContext(ctx, req)
+	}
+	return doer.Do(requestWithContext(req, ctx))
+}
+
+// signRequest calls c.SignRequest with the given request, first
+// replacing its body with a seekable copy if necessary.
+func (c *Client) signRequest(req *http.Request) error {
+	var body io.ReadSeeker
+	switch b := req.Body.(type) {
+	case nil:
+		body = bytes.NewReader(nil)
+	case io.ReadSeeker:
+		body = b
+	default:
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return errgo.Notef(err, "cannot read request body")
+		}
+		r := bytes.NewReader(data)
+		req.Body = BytesReaderCloser{r}
+		body = r
+	}
+	if err := c.SignRequest(req, body); err != nil {
+		return errgo.Notef(err, "cannot sign request")
+	}
+	if _, err := body.Seek(0, 0); err != nil {
+		return errgo.Notef(err, "cannot reset request body")
+	}
+	return nil
+}
+
+// redirectRequest returns the request to make to follow the redirect
+// in the given response to req. It returns nil if resp is not a
+// redirect that can be followed.
+func redirectRequest(req *http.Request, resp *http.Response) *http.Request {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, 308:
+	default:
+		return nil
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return nil
 	}
+	locStr := resp.Header.Get("Location")
+	if locStr == "" {
+		return nil
+	}
+	loc, err := req.URL.Parse(locStr)
 	if err != nil {
-		return errgo.Mask(urlError(err, req), errgo.Any)
+		return nil
+	}
+	if req.Body != nil {
+		// Re-read the body from the start
+		// if we can, otherwise give up.
+		seeker, ok := req.Body.(io.Seeker)
+		if !ok {
+			return nil
+		}
+		if _, err := seeker.Seek(0, 0); err != nil {
+			return nil
+		}
+	}
+	req1 := *req
+	req1.URL = loc
+	req1.Host = ""
+	req1.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		req1.Header[k] = append([]string(nil), v...)
 	}
-	return c.unmarshalResponse(httpResp, resp)
+	if !strings.EqualFold(loc.Host, req.URL.Host) {
+		// Don't send credentials to a different host,
+		// as net/http does when following redirects.
+		for _, k := range sensitiveRedirectHeaders {
+			delete(req1.Header, k)
+		}
+	}
+	return &req1
+}
+
+// sensitiveRedirectHeaders holds the request headers that are
+// removed when following a redirect to a different host.
+var sensitiveRedirectHeaders = []string{
+	"Authorization",
+	"Www-Authenticate",
+	"Cookie",
+	"Cookie2",
 }
 
 // Get is a convenience method that uses c.Do to issue a GET request to
@@ -164,20 +449,107 @@ func (c *Client) Get(ctx context.Context, url string, resp interface{}) error {
 	return c.Do(ctx, req, resp)
 }
 
+// Post is a convenience method that uses c.Do to issue a POST request
+// to the given URL with the JSON-marshaled body. If the given URL does
+// not have a host part then it will be treated as relative to
+// c.BaseURL.
+func (c *Client) Post(ctx context.Context, url string, body, resp interface{}) error {
+	return c.doWithBody(ctx, "POST", url, body, resp)
+}
+
+// Put is like Post except that it issues a PUT request.
+func (c *Client) Put(ctx context.Context, url string, body, resp interface{}) error {
+	return c.doWithBody(ctx, "PUT", url, body, resp)
+}
+
+// Patch is like Post except that it issues a PATCH request.
+func (c *Client) Patch(ctx context.Context, url string, body, resp interface{}) error {
+	return c.doWithBody(ctx, "PATCH", url, body, resp)
+}
+
+// Delete is a convenience method that uses c.Do to issue a DELETE
+// request to the given URL. If the given URL does not have a host part
+// then it will be treated as relative to c.BaseURL.
+func (c *Client) Delete(ctx context.Context, url string, resp interface{}) error {
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return errgo.Notef(err, "cannot make request")
+	}
+	return c.Do(ctx, req, resp)
+}
+
+// DeleteWithBody is like Post except that it issues a DELETE request.
+// It can be used with APIs, such as those that delete several items
+// at once, that expect a DELETE request to have a body.
+func (c *Client) DeleteWithBody(ctx context.Context, url string, body, resp interface{}) error {
+	return c.doWithBody(ctx, "DELETE", url, body, resp)
+}
+
+// doWithBody uses c.Do to issue a request with the given method
+// to the given URL with body marshaled as JSON.
+func (c *Client) doWithBody(ctx context.Context, method, url string, body, resp interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal request body")
+	}
+	req, err := http.NewRequest(method, url, BytesReaderCloser{bytes.NewReader(data)})
+	if err != nil {
+		return errgo.Notef(err, "cannot make request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.Do(ctx, req, resp)
+}
+
+// ProcessResponse processes an HTTP response that has been obtained
+// by some means other than Client.Do, in the same way that Client.Do
+// does. If the response status signifies success, the response is
+// unmarshaled into resp as in Client.Do, otherwise unmarshalError
+// is used to unmarshal the error, as for Client.UnmarshalError.
+// If unmarshalError is nil, DefaultErrorUnmarshaler will be used.
+//
+// The Body of httpResp will be closed unless resp is of type
+// **http.Response. The Request field of httpResp is used to
+// annotate any returned error.
+func ProcessResponse(httpResp *http.Response, unmarshalError func(*http.Response) error, resp interface{}) error {
+	c := Client{
+		UnmarshalError: unmarshalError,
+	}
+	_, err := c.unmarshalResponse(httpResp, resp)
+	return err
+}
+
 // unmarshalResponse unmarshals an HTTP response into the given value.
-func (c *Client) unmarshalResponse(httpResp *http.Response, resp interface{}) error {
+// It returns the response that was actually used, which is the one
+// returned by c.TransformResponse if that is set and succeeds.
+func (c *Client) unmarshalResponse(httpResp *http.Response, resp interface{}) (*http.Response, error) {
+	if c.TransformResponse != nil {
+		httpResp1, err := c.TransformResponse(httpResp)
+		if err != nil {
+			drainAndClose(httpResp.Body)
+			return httpResp, urlError(errgo.NoteMask(err, "cannot transform response", errgo.Any), httpResp.Request)
+		}
+		httpResp = httpResp1
+	}
 	if 200 <= httpResp.StatusCode && httpResp.StatusCode < 300 {
 		if respPt, ok := resp.(**http.Response); ok {
 			*respPt = httpResp
-			return nil
+			return httpResp, nil
 		}
-		defer httpResp.Body.Close()
-		if err := UnmarshalJSONResponse(httpResp, resp); err != nil {
-			return errgo.Mask(urlError(err, httpResp.Request), isDecodeResponseError)
+		defer drainAndClose(httpResp.Body)
+		if resp == nil {
+			return httpResp, nil
 		}
-		return nil
+		if httpResp.StatusCode != http.StatusNoContent {
+			if err := c.decodeResponse(httpResp, resp); err != nil {
+				return httpResp, errgo.Mask(err, errgo.Any)
+			}
+		}
+		if err := unmarshalResponseFields(httpResp, resp); err != nil {
+			return httpResp, errgo.Mask(urlError(err, httpResp.Request))
+		}
+		return httpResp, nil
 	}
-	defer httpResp.Body.Close()
+	defer drainAndClose(httpResp.Body)
 	errUnmarshaler := c.UnmarshalError
 	if errUnmarshaler == nil {
 		errUnmarshaler = DefaultErrorUnmarshaler
@@ -186,7 +558,81 @@ func (c *Client) unmarshalResponse(httpResp *http.Response, resp interface{}) er
 	if err == nil {
 		err = errgo.Newf("unexpected HTTP response status: %s", httpResp.Status)
 	}
-	return errgo.Mask(urlError(err, httpResp.Request), errgo.Any)
+	err = errgo.Mask(urlError(err, httpResp.Request), errgo.Any)
+	return httpResp, causeError{err.(*errgo.Err)}
+}
+
+// causeError wraps an *errgo.Err so that it also implements the
+// Unwrap method used by the standard errors package, allowing
+// errors.Is and errors.As to find its cause, such as a *RemoteError.
+type causeError struct {
+	*errgo.Err
+}
+
+// Unwrap returns the cause of the error.
+func (e causeError) Unwrap() error {
+	return e.Cause()
+}
+
+// drainAndClose reads any remaining data from the given body, up to
+// maxErrorBodySize bytes, so that the underlying connection can be
+// reused, and then closes it.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, int64(maxErrorBodySize)))
+	body.Close()
+}
+
+// decodeResponse decodes the body of a successful HTTP response
+// into the given value.
+func (c *Client) decodeResponse(httpResp *http.Response, resp interface{}) error {
+	if c.MaxResponseBodySize > 0 {
+		httpResp.Body = &limitedBody{
+			ReadCloser: httpResp.Body,
+			maxSize:    c.MaxResponseBodySize,
+		}
+	}
+	if c.DecodeResponse == nil {
+		if err := unmarshalJSONResponse(httpResp, resp, !c.AllowNonJSONContentType); err != nil {
+			return errgo.Mask(urlError(err, httpResp.Request), isDecodeResponseError)
+		}
+		return nil
+	}
+	if err := c.DecodeResponse(httpResp, resp); err != nil {
+		return errgo.Mask(urlError(err, httpResp.Request), errgo.Any)
+	}
+	return nil
+}
+
+// limitedBody is a response body that returns an
+// error when more than maxSize bytes are read from it.
+type limitedBody struct {
+	io.ReadCloser
+	n        int64
+	maxSize  int64
+	exceeded bool
+}
+
+// Read implements io.Reader.Read.
+func (b *limitedBody) Read(buf []byte) (int, error) {
+	if b.exceeded {
+		return 0, b.tooLarge()
+	}
+	if int64(len(buf)) > b.maxSize-b.n+1 {
+		// Read at most one more byte than the maximum
+		// so that we can tell when it has been exceeded.
+		buf = buf[:b.maxSize-b.n+1]
+	}
+	n, err := b.ReadCloser.Read(buf)
+	b.n += int64(n)
+	if b.n > b.maxSize {
+		b.exceeded = true
+		return n - int(b.n-b.maxSize), b.tooLarge()
+	}
+	return n, err
+}
+
+func (b *limitedBody) tooLarge() error {
+	return errgo.Newf("response body too large (maximum %d bytes)", b.maxSize)
 }
 
 // ErrorUnmarshaler returns a function which will unmarshal error
@@ -196,6 +642,9 @@ func (c *Client) unmarshalResponse(httpResp *http.Response, resp interface{}) er
 //
 // If the error cannot by unmarshaled, the function will return an
 // *HTTPResponseError holding the response from the request.
+//
+// If template is a *RemoteError, the StatusCode field of the
+// returned error is set to the status code of the response.
 func ErrorUnmarshaler(template error) func(*http.Response) error {
 	t := reflect.TypeOf(template)
 	if t.Kind() != reflect.Ptr {
@@ -206,12 +655,15 @@ func ErrorUnmarshaler(template error) func(*http.Response) error {
 		if 300 <= resp.StatusCode && resp.StatusCode < 400 {
 			// It's a redirection error.
 			loc, _ := resp.Location()
-			return newDecodeResponseError(resp, nil, fmt.Errorf("unexpected redirect (status %s) from %q to %q", resp.Status, resp.Request.URL, loc))
+			return newDecodeResponseError(resp, nil, UnexpectedRedirect, fmt.Errorf("unexpected redirect (status %s) from %q to %q", resp.Status, resp.Request.URL, loc))
 		}
 		errv := reflect.New(t)
 		if err := UnmarshalJSONResponse(resp, errv.Interface()); err != nil {
 			return errgo.NoteMask(err, fmt.Sprintf("cannot unmarshal error response (status %s)", resp.Status), isDecodeResponseError)
 		}
+		if rerr, ok := errv.Interface().(*RemoteError); ok {
+			rerr.StatusCode = resp.StatusCode
+		}
 		return errv.Interface().(error)
 	}
 }
@@ -220,15 +672,27 @@ func ErrorUnmarshaler(template error) func(*http.Response) error {
 // into x, which should be a pointer to the result to be
 // unmarshaled into.
 //
+// The response must have a JSON content type: either
+// application/json or a media type with a "+json" suffix,
+// such as application/problem+json.
+//
 // If the response cannot be unmarshaled, an error of type
-// *DecodeResponseError will be returned.
+// *DecodeResponseError will be returned. Its Kind field
+// reports the reason for the failure.
 func UnmarshalJSONResponse(resp *http.Response, x interface{}) error {
+	return unmarshalJSONResponse(resp, x, true)
+}
+
+// unmarshalJSONResponse is like UnmarshalJSONResponse except
+// that the response content type is only checked if
+// checkContentType is true.
+func unmarshalJSONResponse(resp *http.Response, x interface{}, checkContentType bool) error {
 	if x == nil {
 		return nil
 	}
-	if !isJSONMediaType(resp.Header) {
+	if checkContentType && !isJSONResponseMediaType(resp.Header) {
 		fancyErr := newFancyDecodeError(resp.Header, resp.Body)
-		return newDecodeResponseError(resp, fancyErr.body, fancyErr)
+		return newDecodeResponseError(resp, fancyErr.body, ContentTypeMismatch, fancyErr)
 	}
 	// Read enough data that we can produce a plausible-looking
 	// possibly-truncated response body in the error.
@@ -237,12 +701,12 @@ func UnmarshalJSONResponse(resp *http.Response, x interface{}) error {
 
 	bodyData := buf.Bytes()
 	if err != nil {
-		return newDecodeResponseError(resp, bodyData, errgo.Notef(err, "error reading response body"))
+		return newDecodeResponseError(resp, bodyData, BodyReadError, errgo.Notef(err, "error reading response body"))
 	}
 	if n < int64(maxErrorBodySize) {
 		// We've read all the data; unmarshal it.
 		if err := json.Unmarshal(bodyData, x); err != nil {
-			return newDecodeResponseError(resp, bodyData, err)
+			return newDecodeResponseError(resp, bodyData, DecodeFailure, err)
 		}
 		return nil
 	}
@@ -258,7 +722,10 @@ func UnmarshalJSONResponse(resp *http.Response, x interface{}) error {
 	defer io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 8*1024))
 
 	if err := dec.Decode(x); err != nil {
-		return newDecodeResponseError(resp, bodyData, err)
+		if b, ok := resp.Body.(*limitedBody); ok && b.exceeded {
+			return newDecodeResponseError(resp, bodyData, BodyReadError, errgo.Notef(err, "error reading response body"))
+		}
+		return newDecodeResponseError(resp, bodyData, DecodeFailure, err)
 	}
 	return nil
 }
@@ -275,6 +742,13 @@ type RemoteError struct {
 
 	// Info holds any other information associated with the error.
 	Info *json.RawMessage `json:",omitempty"`
+
+	// StatusCode holds the HTTP status code of the response
+	// that held the error. It is set by the function returned
+	// by ErrorUnmarshaler, and so by DefaultErrorUnmarshaler,
+	// but is not itself part of the JSON error body. See
+	// WriteRemoteError and ProxyErrorMapper.
+	StatusCode int `json:"-"`
 }
 
 // Error implements the error interface.
@@ -285,17 +759,50 @@ func (e *RemoteError) Error() string {
 	return e.Message
 }
 
-// appendURL returns the result of combining the
-// given base URL and relative URL.
+// Is reports whether e matches target, which it does when target
+// is a *RemoteError with a non-empty Code that is the same as
+// e.Code. This allows errors.Is to be used to check the code of
+// an error returned by Client, for example:
+//
+//	if errors.Is(err, &httprequest.RemoteError{Code: "not found"}) {
+//		...
+//	}
+//
+// An error returned by Client for an error response also
+// allows errors.As to find the *RemoteError.
+func (e *RemoteError) Is(target error) bool {
+	t, ok := target.(*RemoteError)
+	return ok && t != nil && t.Code != "" && t.Code == e.Code
+}
+
+// JoinURL returns the result of combining the given base URL and
+// relative URL. This is the same combination that Client.Do uses to
+// add a request URL to Client.BaseURL.
 //
-// The path of the relative URL will be appended
-// to the base URL, separated by a slash (/) if
-// needed.
+// The path of the relative URL will be appended to the base URL,
+// separated by a slash (/) if needed. Percent-encoded characters in
+// either path, such as escaped slashes, are preserved. Note that,
+// unlike url.URL.ResolveReference, the last element of the base URL
+// path is never replaced, so joining "http://foo/a" and "b" results
+// in "http://foo/a/b". Client.Call joins paths in the same way.
 //
-// Any query parameters will be concatenated together.
+// Any query parameters will be concatenated together, with those of
+// the base URL first, so joining "http://foo?a=1" and "b?a=2" results
+// in "http://foo/b?a=1&a=2". This differs from Client.Call, where a
+// form field replaces any base URL parameter with the same name (see
+// Marshal).
 //
-// appendURL will return an error if relURLStr contains
-// a host name.
+// JoinURL will return an error if rel contains a host name.
+func JoinURL(base, rel string) (string, error) {
+	u, err := appendURL(base, rel)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	return u.String(), nil
+}
+
+// appendURL is like JoinURL except that it returns
+// the resulting URL in parsed form.
 func appendURL(baseURLStr, relURLStr string) (*url.URL, error) {
 	b, err := url.Parse(baseURLStr)
 	if err != nil {
@@ -309,7 +816,10 @@ func appendURL(baseURLStr, relURLStr string) (*url.URL, error) {
 		return nil, errgo.Newf("relative URL specifies a host")
 	}
 	if r.Path != "" {
-		b.Path = strings.TrimSuffix(b.Path, "/") + "/" + strings.TrimPrefix(r.Path, "/")
+		// Join the escaped paths too, so that any escaped
+		// slashes in either path are preserved.
+		b.RawPath = joinPath(b.EscapedPath(), r.EscapedPath())
+		b.Path = joinPath(b.Path, r.Path)
 	}
 	if r.RawQuery != "" {
 		if b.RawQuery != "" {
@@ -321,10 +831,16 @@ func appendURL(baseURLStr, relURLStr string) (*url.URL, error) {
 	return b, nil
 }
 
+// joinPath joins the two URL paths with a single slash.
+func joinPath(p1, p2 string) string {
+	return strings.TrimSuffix(p1, "/") + "/" + strings.TrimPrefix(p2, "/")
+}
+
 func urlError(err error, req *http.Request) error {
 	_, ok := errgo.Cause(err).(*url.Error)
-	if ok {
-		// The error is already sufficiently annotated.
+	if ok || req == nil {
+		// The error is already sufficiently annotated
+		// or there's no request to annotate it with.
 		return err
 	}
 	// Convert the method to mostly lower case to match net/http's behaviour
@@ -0,0 +1,93 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// +build go1.13
+
+package httprequest_test
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+	"github.com/juju/httprequest/httprequesttest"
+)
+
+func (*clientSuite) TestRemoteErrorIsAndAs(c *gc.C) {
+	var doer httprequesttest.Doer
+	doer.AddResponse(httprequesttest.JSONResponse(http.StatusNotFound, &httprequest.RemoteError{
+		Message: "no such thing",
+		Code:    "not found",
+	}))
+	doer.AddResponse(httprequesttest.JSONResponse(http.StatusNotFound, &httprequest.RemoteError{
+		Message: "no such thing",
+		Code:    "not found",
+	}))
+	client := httprequest.Client{
+		BaseURL: "http://example.com",
+		Doer:    &doer,
+	}
+	req, err := http.NewRequest("GET", "/x", nil)
+	c.Assert(err, gc.IsNil)
+	err = client.Do(context.Background(), req, nil)
+	c.Assert(err, gc.ErrorMatches, `Get http://example.com/x: no such thing`)
+	checkRemoteError(c, err)
+
+	req, err = http.NewRequest("GET", "/x", nil)
+	c.Assert(err, gc.IsNil)
+	resp, err := client.DoResponse(context.Background(), req, nil)
+	c.Assert(err, gc.ErrorMatches, `Get http://example.com/x: no such thing`)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	checkRemoteError(c, err)
+}
+
+func checkRemoteError(c *gc.C, err error) {
+	c.Assert(errgo.Cause(err), gc.FitsTypeOf, (*httprequest.RemoteError)(nil))
+
+	var rerr *httprequest.RemoteError
+	c.Assert(errors.As(err, &rerr), gc.Equals, true)
+	c.Assert(rerr.Code, gc.Equals, "not found")
+
+	c.Assert(errors.Is(err, &httprequest.RemoteError{Code: "not found"}), gc.Equals, true)
+	c.Assert(errors.Is(err, &httprequest.RemoteError{Code: "bad request"}), gc.Equals, false)
+	c.Assert(errors.Is(err, &httprequest.RemoteError{}), gc.Equals, false)
+}
+
+var remoteErrorIsTests = []struct {
+	about  string
+	err    *httprequest.RemoteError
+	target error
+	expect bool
+}{{
+	about:  "same code",
+	err:    &httprequest.RemoteError{Message: "a", Code: "c"},
+	target: &httprequest.RemoteError{Message: "b", Code: "c"},
+	expect: true,
+}, {
+	about:  "different code",
+	err:    &httprequest.RemoteError{Code: "c"},
+	target: &httprequest.RemoteError{Code: "d"},
+}, {
+	about:  "empty code",
+	err:    &httprequest.RemoteError{Message: "a"},
+	target: &httprequest.RemoteError{Message: "a"},
+}, {
+	about:  "nil target",
+	err:    &httprequest.RemoteError{Code: "c"},
+	target: (*httprequest.RemoteError)(nil),
+}, {
+	about:  "other error type",
+	err:    &httprequest.RemoteError{Message: "c", Code: "c"},
+	target: errgo.New("c"),
+}}
+
+func (*clientSuite) TestRemoteErrorIs(c *gc.C) {
+	for i, test := range remoteErrorIsTests {
+		c.Logf("test %d: %s", i, test.about)
+		c.Assert(test.err.Is(test.target), gc.Equals, test.expect)
+	}
+}
@@ -1,12 +1,19 @@
 package httprequest_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
@@ -17,6 +24,7 @@ import (
 	"gopkg.in/errgo.v1"
 
 	"github.com/juju/httprequest"
+	"github.com/juju/httprequest/httprequesttest"
 )
 
 type clientSuite struct {
@@ -81,6 +89,10 @@ var callTests = []struct {
 	about:       "unexpected redirect",
 	req:         &chM2RedirectM2Req{},
 	expectError: `Post http://.*/m2/foo//: unexpected redirect \(status 307 Temporary Redirect\) from "http://.*/m2/foo//" to "http://.*/m2/foo"`,
+	assertError: func(c *gc.C, err error) {
+		c.Assert(errgo.Cause(err), gc.FitsTypeOf, (*httprequest.DecodeResponseError)(nil))
+		c.Assert(errgo.Cause(err).(*httprequest.DecodeResponseError).Kind, gc.Equals, httprequest.UnexpectedRedirect)
+	},
 }, {
 	about:       "bad content in successful response",
 	req:         &chM4Req{},
@@ -94,6 +106,7 @@ var callTests = []struct {
 		data, err := ioutil.ReadAll(err1.Response.Body)
 		c.Assert(err, gc.IsNil)
 		c.Assert(string(data), gc.Equals, "bad response")
+		c.Assert(err1.Kind, gc.Equals, httprequest.ContentTypeMismatch)
 	},
 }, {
 	about:       "bad content in error response",
@@ -406,6 +419,402 @@ func (s *clientSuite) TestDoClosesResponseBodyOnError(c *gc.C) {
 	c.Assert(doer.closedBodies, gc.Equals, 1)
 }
 
+func (s *clientSuite) TestDoDrainsResponseBodyWithNilResponse(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	var doer closeCountingDoer
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+		Doer:    &doer,
+	}
+	err := client.Get(context.Background(), "/m1/foo", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doer.openedBodies, gc.Equals, 1)
+	c.Assert(doer.closedBodies, gc.Equals, 1)
+	c.Assert(doer.drainedBodies, gc.Equals, 1)
+}
+
+func (s *clientSuite) TestDoDrainsResponseBodyOnError(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	var doer closeCountingDoer
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+		Doer:    &doer,
+		UnmarshalError: func(resp *http.Response) error {
+			// Don't read the body at all.
+			return errgo.New("custom error")
+		},
+	}
+	err := client.Get(context.Background(), "/m3", nil)
+	c.Assert(err, gc.ErrorMatches, `Get http:.*/m3: custom error`)
+	c.Assert(doer.openedBodies, gc.Equals, 1)
+	c.Assert(doer.closedBodies, gc.Equals, 1)
+	c.Assert(doer.drainedBodies, gc.Equals, 1)
+}
+
+func (s *clientSuite) TestDoDrainsResponseBodyOnDecodeError(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	var doer closeCountingDoer
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+		Doer:    &doer,
+	}
+	var resp chan int
+	err := client.Get(context.Background(), "/m1/foo", &resp)
+	c.Assert(err, gc.ErrorMatches, `Get http:.*/m1/foo: json: cannot unmarshal object into Go value of type chan int`)
+	c.Assert(doer.openedBodies, gc.Equals, 1)
+	c.Assert(doer.closedBodies, gc.Equals, 1)
+	c.Assert(doer.drainedBodies, gc.Equals, 1)
+}
+
+func (s *clientSuite) TestDoResponse(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	var doer closeCountingDoer
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+		Doer:    &doer,
+	}
+	req, err := http.NewRequest("GET", "/m1/foo", nil)
+	c.Assert(err, gc.IsNil)
+	var resp chM1Resp
+	httpResp, err := client.DoResponse(context.Background(), req, &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp, jc.DeepEquals, chM1Resp{"foo"})
+	c.Assert(httpResp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(httpResp.Header.Get("Content-Type"), gc.Equals, "application/json")
+	c.Assert(doer.openedBodies, gc.Equals, 1)
+	c.Assert(doer.closedBodies, gc.Equals, 1)
+}
+
+func (s *clientSuite) TestDoResponseWithError(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	var doer closeCountingDoer
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+		Doer:    &doer,
+	}
+	req, err := http.NewRequest("GET", "/m3", nil)
+	c.Assert(err, gc.IsNil)
+	httpResp, err := client.DoResponse(context.Background(), req, nil)
+	c.Assert(err, gc.ErrorMatches, `Get http:.*/m3: m3 error`)
+	c.Assert(errgo.Cause(err), gc.FitsTypeOf, (*httprequest.RemoteError)(nil))
+	c.Assert(httpResp, gc.NotNil)
+	c.Assert(httpResp.StatusCode, gc.Equals, http.StatusInternalServerError)
+	c.Assert(doer.openedBodies, gc.Equals, 1)
+	c.Assert(doer.closedBodies, gc.Equals, 1)
+}
+
+func (s *clientSuite) TestDoResponseWithRequestError(c *gc.C) {
+	client := &httprequest.Client{
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, errgo.New("no response")
+		}),
+	}
+	req, err := http.NewRequest("GET", "http://example.com/x", nil)
+	c.Assert(err, gc.IsNil)
+	httpResp, err := client.DoResponse(context.Background(), req, nil)
+	c.Assert(err, gc.ErrorMatches, `Get http://example.com/x: no response`)
+	c.Assert(httpResp, gc.IsNil)
+}
+
+// hashSigner is a Client.SignRequest function that sets the
+// Signature header to the method, URL and body of the request.
+func hashSigner(req *http.Request, body io.ReadSeeker) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf("%s %s %q", req.Method, req.URL, data))
+	return nil
+}
+
+var signRequestTests = []struct {
+	about           string
+	call            func(client *httprequest.Client) error
+	expectSignature string
+	expectBody      string
+}{{
+	about: "Call with body",
+	call: func(client *httprequest.Client) error {
+		req := &chM2Req{P: "foo"}
+		req.Body.I = 999
+		return client.Call(context.Background(), req, nil)
+	},
+	expectSignature: `POST http://example.com/m2/foo "{\"I\":999}"`,
+	expectBody:      `{"I":999}`,
+}, {
+	about: "Do without body",
+	call: func(client *httprequest.Client) error {
+		req, err := http.NewRequest("GET", "/x", nil)
+		if err != nil {
+			return err
+		}
+		return client.Do(context.Background(), req, nil)
+	},
+	expectSignature: `GET http://example.com/x ""`,
+}, {
+	about: "Do with non-seekable body",
+	call: func(client *httprequest.Client) error {
+		req, err := http.NewRequest("PUT", "http://other.example.com/y", io.MultiReader(strings.NewReader("some body")))
+		if err != nil {
+			return err
+		}
+		_, err = client.DoResponse(context.Background(), req, nil)
+		return err
+	},
+	expectSignature: `PUT http://other.example.com/y "some body"`,
+	expectBody:      "some body",
+}}
+
+func (s *clientSuite) TestSignRequest(c *gc.C) {
+	for i, test := range signRequestTests {
+		c.Logf("test %d: %s", i, test.about)
+		var doer httprequesttest.Doer
+		doer.AddResponse(httprequesttest.NewResponse(http.StatusOK, nil, nil))
+		client := &httprequest.Client{
+			BaseURL:     "http://example.com",
+			Doer:        &doer,
+			SignRequest: hashSigner,
+		}
+		err := test.call(client)
+		c.Assert(err, gc.IsNil)
+		reqs := doer.Requests()
+		c.Assert(reqs, gc.HasLen, 1)
+		c.Assert(reqs[0].Header.Get("Signature"), gc.Equals, test.expectSignature)
+		c.Assert(string(reqs[0].Body), gc.Equals, test.expectBody)
+	}
+}
+
+func (s *clientSuite) TestSignRequestError(c *gc.C) {
+	var doer httprequesttest.Doer
+	client := &httprequest.Client{
+		BaseURL: "http://example.com",
+		Doer:    &doer,
+		SignRequest: func(req *http.Request, body io.ReadSeeker) error {
+			return errgo.New("no key")
+		},
+	}
+	err := client.Get(context.Background(), "/x", nil)
+	c.Assert(err, gc.ErrorMatches, `Get http://example.com/x: cannot sign request: no key`)
+	c.Assert(doer.Requests(), gc.HasLen, 0)
+}
+
+// unwrapEnvelope is a Client.TransformResponse function that
+// replaces a response body of the form {"data": ...} or
+// {"error": ...} with the enveloped value.
+func unwrapEnvelope(resp *http.Response) (*http.Response, error) {
+	defer resp.Body.Close()
+	var envelope struct {
+		Data  json.RawMessage `json:"data"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, errgo.Notef(err, "bad envelope")
+	}
+	data := envelope.Data
+	if resp.StatusCode >= 300 {
+		data = envelope.Error
+	}
+	resp1 := *resp
+	resp1.Body = ioutil.NopCloser(bytes.NewReader(data))
+	resp1.ContentLength = int64(len(data))
+	return &resp1, nil
+}
+
+func (s *clientSuite) TestTransformResponse(c *gc.C) {
+	var doer httprequesttest.Doer
+	doer.AddResponse(httprequesttest.NewResponse(http.StatusOK, http.Header{
+		"Content-Type": {"application/json"},
+	}, []byte(`{"data": {"P": "hello"}}`)))
+	doer.AddResponse(httprequesttest.NewResponse(http.StatusNotFound, http.Header{
+		"Content-Type": {"application/json"},
+	}, []byte(`{"error": {"Message": "not there", "Code": "not found"}}`)))
+	doer.AddResponse(httprequesttest.NewResponse(http.StatusOK, http.Header{
+		"Content-Type": {"application/json"},
+	}, []byte(`"P": "hello"`)))
+	client := &httprequest.Client{
+		BaseURL:           "http://example.com",
+		Doer:              &doer,
+		TransformResponse: unwrapEnvelope,
+	}
+	var resp chM1Resp
+	err := client.Get(context.Background(), "/x", &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp, jc.DeepEquals, chM1Resp{"hello"})
+
+	err = client.Get(context.Background(), "/x", &resp)
+	c.Assert(err, gc.ErrorMatches, `Get http://example.com/x: not there`)
+	c.Assert(errgo.Cause(err), jc.DeepEquals, &httprequest.RemoteError{
+		Message:    "not there",
+		Code:       "not found",
+		StatusCode: http.StatusNotFound,
+	})
+
+	err = client.Get(context.Background(), "/x", &resp)
+	c.Assert(err, gc.ErrorMatches, `Get http://example.com/x: cannot transform response: bad envelope: json: cannot unmarshal string into Go value of type struct .*`)
+}
+
+func (s *clientSuite) TestDoResponseReturnsTransformedResponse(c *gc.C) {
+	var doer httprequesttest.Doer
+	doer.AddResponse(httprequesttest.NewResponse(http.StatusOK, http.Header{
+		"Content-Type": {"application/json"},
+	}, []byte(`{"P": "hello"}`)))
+	client := &httprequest.Client{
+		BaseURL: "http://example.com",
+		Doer:    &doer,
+		TransformResponse: func(resp *http.Response) (*http.Response, error) {
+			resp1 := *resp
+			resp1.StatusCode = http.StatusCreated
+			resp1.Header = http.Header{
+				"Content-Type":  {"application/json"},
+				"X-Transformed": {"yes"},
+			}
+			return &resp1, nil
+		},
+	}
+	req, err := http.NewRequest("GET", "/x", nil)
+	c.Assert(err, gc.IsNil)
+	var resp chM1Resp
+	httpResp, err := client.DoResponse(context.Background(), req, &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp, jc.DeepEquals, chM1Resp{"hello"})
+	c.Assert(httpResp.StatusCode, gc.Equals, http.StatusCreated)
+	c.Assert(httpResp.Header.Get("X-Transformed"), gc.Equals, "yes")
+}
+
+var followRedirectsTests = []struct {
+	about           string
+	method          string
+	path            string
+	followRedirects int
+	expectError     string
+	expectResp      string
+}{{
+	about:       "redirects not followed by default",
+	path:        "/redirect/1",
+	expectError: `Get http://.*/redirect/1: unexpected redirect \(status 302 Found\) from "http://.*/redirect/1" to "http://.*/redirect/0"`,
+}, {
+	about:           "redirects followed",
+	path:            "/redirect/3",
+	followRedirects: 3,
+	expectResp:      "done",
+}, {
+	about:           "too many redirects",
+	path:            "/redirect/3",
+	followRedirects: 2,
+	expectError:     `Get http://.*/redirect/1: unexpected redirect \(status 302 Found\) from "http://.*/redirect/1" to "http://.*/redirect/0"`,
+}, {
+	about:           "redirects not followed for unsafe methods",
+	method:          "POST",
+	path:            "/redirect/1",
+	followRedirects: 3,
+	expectError:     `Post http://.*/redirect/1: unexpected redirect \(status 302 Found\) from "http://.*/redirect/1" to "http://.*/redirect/0"`,
+}}
+
+func (s *clientSuite) TestFollowRedirects(c *gc.C) {
+	router := httprouter.New()
+	router.Handle("GET", "/redirect/:n", func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		if p.ByName("n") == "0" {
+			httprequest.WriteJSON(w, http.StatusOK, "done")
+			return
+		}
+		n, err := strconv.Atoi(p.ByName("n"))
+		c.Check(err, gc.IsNil)
+		http.Redirect(w, req, "/redirect/"+strconv.Itoa(n-1), http.StatusFound)
+	})
+	router.Handle("POST", "/redirect/:n", func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		http.Redirect(w, req, "/redirect/0", http.StatusFound)
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	for i, test := range followRedirectsTests {
+		c.Logf("test %d: %s", i, test.about)
+		client := &httprequest.Client{
+			BaseURL:         srv.URL,
+			Doer:            transportDoer{},
+			FollowRedirects: test.followRedirects,
+		}
+		method := test.method
+		if method == "" {
+			method = "GET"
+		}
+		req, err := http.NewRequest(method, test.path, nil)
+		c.Assert(err, gc.IsNil)
+		var resp string
+		err = client.Do(context.Background(), req, &resp)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp, gc.Equals, test.expectResp)
+	}
+}
+
+func (s *clientSuite) TestFollowRedirectsToOtherHost(c *gc.C) {
+	var gotHeader http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotHeader = req.Header
+		httprequest.WriteJSON(w, http.StatusOK, "done")
+	}))
+	defer target.Close()
+	router := httprouter.New()
+	router.Handle("GET", "/other", func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		http.Redirect(w, req, target.URL+"/x", http.StatusFound)
+	})
+	router.Handle("GET", "/same", func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		http.Redirect(w, req, "/done", http.StatusFound)
+	})
+	router.Handle("GET", "/done", func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		gotHeader = req.Header
+		httprequest.WriteJSON(w, http.StatusOK, "done")
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL:         srv.URL,
+		Doer:            transportDoer{},
+		FollowRedirects: 1,
+	}
+	newRequest := func(path string) *http.Request {
+		req, err := http.NewRequest("GET", path, nil)
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Cookie", "session=secret")
+		req.Header.Set("X-Other", "value")
+		return req
+	}
+
+	req := newRequest("/other")
+	var resp string
+	err := client.Do(context.Background(), req, &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp, gc.Equals, "done")
+	c.Assert(gotHeader.Get("Authorization"), gc.Equals, "")
+	c.Assert(gotHeader.Get("Cookie"), gc.Equals, "")
+	c.Assert(gotHeader.Get("X-Other"), gc.Equals, "value")
+	// The original request is unchanged.
+	c.Assert(req.Header.Get("Authorization"), gc.Equals, "Bearer secret")
+
+	gotHeader = nil
+	err = client.Do(context.Background(), newRequest("/same"), &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(gotHeader.Get("Authorization"), gc.Equals, "Bearer secret")
+	c.Assert(gotHeader.Get("Cookie"), gc.Equals, "session=secret")
+}
+
+// transportDoer is a Doer that uses http.DefaultTransport
+// directly, so redirects are not followed.
+type transportDoer struct{}
+
+func (transportDoer) Do(req *http.Request) (*http.Response, error) {
+	return http.DefaultTransport.RoundTrip(req)
+}
+
 func (s *clientSuite) TestGet(c *gc.C) {
 	srv := s.newServer()
 	defer srv.Close()
@@ -428,6 +837,434 @@ func (s *clientSuite) TestGetNoBaseURL(c *gc.C) {
 	c.Assert(resp, jc.DeepEquals, chM1Resp{"foo"})
 }
 
+var bodyMethodTests = []struct {
+	about      string
+	call       func(client *httprequest.Client, resp interface{}) error
+	expectResp interface{}
+}{{
+	about: "Post",
+	call: func(client *httprequest.Client, resp interface{}) error {
+		return client.Post(context.Background(), "/m2/foo", struct{ I int }{99}, resp)
+	},
+	expectResp: &chM2Resp{"foo", 99},
+}, {
+	about: "Put",
+	call: func(client *httprequest.Client, resp interface{}) error {
+		return client.Put(context.Background(), "/m6/foo", struct{ I int }{99}, resp)
+	},
+	expectResp: &chM2Resp{"PUT foo", 99},
+}, {
+	about: "Patch",
+	call: func(client *httprequest.Client, resp interface{}) error {
+		return client.Patch(context.Background(), "/m6/foo", struct{ I int }{99}, resp)
+	},
+	expectResp: &chM2Resp{"PATCH foo", 99},
+}, {
+	about: "Delete",
+	call: func(client *httprequest.Client, resp interface{}) error {
+		return client.Delete(context.Background(), "/m6/foo", resp)
+	},
+	expectResp: &chM1Resp{"DELETE foo"},
+}, {
+	about: "DeleteWithBody",
+	call: func(client *httprequest.Client, resp interface{}) error {
+		return client.DeleteWithBody(context.Background(), "/m9/foo", struct{ I int }{99}, resp)
+	},
+	expectResp: &chM2Resp{"DELETE foo", 99},
+}, {
+	about: "Call with DELETE body",
+	call: func(client *httprequest.Client, resp interface{}) error {
+		req := &chM9Req{
+			P: "foo",
+		}
+		req.Body.I = 99
+		return client.Call(context.Background(), req, resp)
+	},
+	expectResp: &chM2Resp{"DELETE foo", 99},
+}}
+
+func (s *clientSuite) TestBodyMethods(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	for i, test := range bodyMethodTests {
+		c.Logf("test %d: %s", i, test.about)
+		resp := reflect.New(reflect.TypeOf(test.expectResp).Elem()).Interface()
+		err := test.call(client, resp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp, jc.DeepEquals, test.expectResp)
+	}
+}
+
+func (s *clientSuite) TestPostWithUnmarshalableBody(c *gc.C) {
+	client := &httprequest.Client{}
+	err := client.Post(context.Background(), "/m2/foo", make(chan int), nil)
+	c.Assert(err, gc.ErrorMatches, `cannot marshal request body: json: unsupported type: chan int`)
+}
+
+func (s *clientSuite) TestDecodeResponse(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+		DecodeResponse: func(resp *http.Response, x interface{}) error {
+			data, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			*x.(*string) = resp.Header.Get("Content-Type") + ": " + string(data)
+			return nil
+		},
+	}
+	var resp string
+	err := client.Call(context.Background(), &chM4Req{}, &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp, gc.Equals, "text/plain; charset=utf-8: bad response")
+}
+
+func (s *clientSuite) TestDecodeResponseError(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	decodeErr := errgo.New("decode failure")
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+		DecodeResponse: func(resp *http.Response, x interface{}) error {
+			return decodeErr
+		},
+	}
+	var resp string
+	err := client.Call(context.Background(), &chM4Req{}, &resp)
+	c.Assert(err, gc.ErrorMatches, `Get http://.*/m4: decode failure`)
+	c.Assert(errgo.Cause(err), gc.Equals, decodeErr)
+}
+
+func (s *clientSuite) TestDecodeResponseNotCalledWithNilResponse(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+		DecodeResponse: func(resp *http.Response, x interface{}) error {
+			c.Errorf("DecodeResponse called unexpectedly")
+			return nil
+		},
+	}
+	err := client.Call(context.Background(), &chM4Req{}, nil)
+	c.Assert(err, gc.IsNil)
+}
+
+var responseStatusTests = []struct {
+	about      string
+	status     int
+	expectResp chStatusResp
+}{{
+	about:  "OK",
+	status: http.StatusOK,
+	expectResp: chStatusResp{
+		Status:  http.StatusOK,
+		Message: "hello",
+	},
+}, {
+	about:  "created",
+	status: http.StatusCreated,
+	expectResp: chStatusResp{
+		Status:  http.StatusCreated,
+		Message: "hello",
+	},
+}, {
+	about:  "no content",
+	status: http.StatusNoContent,
+	expectResp: chStatusResp{
+		Status: http.StatusNoContent,
+	},
+}}
+
+func (s *clientSuite) TestResponseStatusField(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	for i, test := range responseStatusTests {
+		c.Logf("test %d: %s", i, test.about)
+		var resp chStatusResp
+		err := client.Call(context.Background(), &chM7Req{
+			Status: test.status,
+		}, &resp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp, jc.DeepEquals, test.expectResp)
+	}
+}
+
+func (s *clientSuite) TestResponseStatusFieldWithBadType(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp struct {
+		Status string `httprequest:",status"`
+	}
+	err := client.Call(context.Background(), &chM7Req{
+		Status: http.StatusOK,
+	}, &resp)
+	c.Assert(err, gc.ErrorMatches, `Get http://.*/m7/200: bad response type \*struct { Status string "httprequest:\\",status\\"" }: bad type for field Status: status field must be of integer type, not string`)
+}
+
+func (s *clientSuite) TestResponseHeaderFields(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp chHeaderResp
+	err := client.Call(context.Background(), &chM8Req{
+		Total: "25",
+	}, &resp)
+	c.Assert(err, gc.IsNil)
+	next := "/m8?page=2"
+	c.Assert(resp, jc.DeepEquals, chHeaderResp{
+		Total:   25,
+		Links:   []string{"<a>", "<b>"},
+		Link:    "<a>, <b>",
+		Next:    &next,
+		Excl:    "hello!",
+		ETag:    `W/"a b"`,
+		Wait:    90 * time.Second,
+		Size:    18446744073709551615,
+		Message: "hello",
+	})
+}
+
+func (s *clientSuite) TestResponseHeaderFieldWithBadValue(c *gc.C) {
+	srv := s.newServer()
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp chHeaderResp
+	err := client.Call(context.Background(), &chM8Req{
+		Total: "lots",
+	}, &resp)
+	c.Assert(err, gc.ErrorMatches, `Get http://.*/m8/lots: cannot unmarshal response into field Total: cannot parse "lots" into int: expected integer`)
+}
+
+var processResponseTests = []struct {
+	about          string
+	status         int
+	contentType    string
+	body           string
+	location       string
+	unmarshalError func(*http.Response) error
+	expectResp     interface{}
+	expectError    string
+	expectCause    interface{}
+}{{
+	about:       "success",
+	status:      http.StatusOK,
+	contentType: "application/json",
+	body:        `{"P":"hello"}`,
+	expectResp:  &chM1Resp{"hello"},
+}, {
+	about:       "success with bad content type",
+	status:      http.StatusOK,
+	contentType: "text/plain",
+	body:        `hello`,
+	expectError: `Get http://example.com/x: unexpected content type text/plain; want application/json; content: hello`,
+}, {
+	about:       "error with default error unmarshaler",
+	status:      http.StatusBadRequest,
+	contentType: "application/json",
+	body:        `{"Message":"bad request","Code":"bad"}`,
+	expectError: `Get http://example.com/x: bad request`,
+	expectCause: &httprequest.RemoteError{
+		Message:    "bad request",
+		Code:       "bad",
+		StatusCode: http.StatusBadRequest,
+	},
+}, {
+	about:       "error with custom error unmarshaler",
+	status:      http.StatusTeapot,
+	contentType: "application/json",
+	body:        `{}`,
+	unmarshalError: func(resp *http.Response) error {
+		return errgo.Newf("custom error with status %d", resp.StatusCode)
+	},
+	expectError: `Get http://example.com/x: custom error with status 418`,
+}, {
+	about:       "redirect",
+	status:      http.StatusMovedPermanently,
+	contentType: "application/json",
+	location:    "http://example.com/y",
+	expectError: `Get http://example.com/x: unexpected redirect \(status 301 Moved Permanently\) from "http://example.com/x" to "http://example.com/y"`,
+}}
+
+func (s *clientSuite) TestProcessResponse(c *gc.C) {
+	for i, test := range processResponseTests {
+		c.Logf("test %d: %s", i, test.about)
+		req, err := http.NewRequest("GET", "http://example.com/x", nil)
+		c.Assert(err, gc.IsNil)
+		httpResp := &http.Response{
+			Status:     fmt.Sprintf("%d %s", test.status, http.StatusText(test.status)),
+			StatusCode: test.status,
+			Header: http.Header{
+				"Content-Type": {test.contentType},
+			},
+			Body:    ioutil.NopCloser(strings.NewReader(test.body)),
+			Request: req,
+		}
+		if test.location != "" {
+			httpResp.Header.Set("Location", test.location)
+		}
+		var resp chM1Resp
+		err = httprequest.ProcessResponse(httpResp, test.unmarshalError, &resp)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			if test.expectCause != nil {
+				c.Assert(errgo.Cause(err), jc.DeepEquals, test.expectCause)
+			}
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(&resp, jc.DeepEquals, test.expectResp)
+	}
+}
+
+var allowNonJSONContentTypeTests = []struct {
+	about       string
+	allow       bool
+	contentType string
+	expectError string
+}{{
+	about:       "strict with JSON content type",
+	contentType: "application/json; charset=utf-8",
+}, {
+	about:       "strict with JSON suffix content type",
+	contentType: "application/problem+json",
+}, {
+	about:       "strict with vendor JSON suffix content type",
+	contentType: "application/vnd.example.v2+json; charset=utf-8",
+}, {
+	about:       "strict with non-JSON suffix content type",
+	contentType: "application/problem+xml",
+	expectError: `Get http://example.com/x: unexpected content type application/problem\+xml; want application/json; content: .*`,
+}, {
+	about:       "strict with other content type",
+	contentType: "text/json",
+	expectError: `Get http://example.com/x: unexpected content type text/json; want application/json; content: .*`,
+}, {
+	about:       "strict with no content type",
+	expectError: `Get http://example.com/x: unexpected content type ""; want application/json; content: .*`,
+}, {
+	about:       "allowed with other content type",
+	allow:       true,
+	contentType: "text/json",
+}, {
+	about: "allowed with no content type",
+	allow: true,
+}}
+
+func (s *clientSuite) TestAllowNonJSONContentType(c *gc.C) {
+	for i, test := range allowNonJSONContentTypeTests {
+		c.Logf("test %d: %s", i, test.about)
+		client := httprequest.Client{
+			Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+				resp := &http.Response{
+					Status:     "200 OK",
+					StatusCode: http.StatusOK,
+					Header:     make(http.Header),
+					Body:       ioutil.NopCloser(strings.NewReader(`{"P":"hello"}`)),
+					Request:    req,
+				}
+				if test.contentType != "" {
+					resp.Header.Set("Content-Type", test.contentType)
+				}
+				return resp, nil
+			}),
+			AllowNonJSONContentType: test.allow,
+		}
+		var resp chM1Resp
+		err := client.Get(context.Background(), "http://example.com/x", &resp)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err).(*httprequest.DecodeResponseError).Kind, gc.Equals, httprequest.ContentTypeMismatch)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp, jc.DeepEquals, chM1Resp{"hello"})
+	}
+}
+
+var maxResponseBodySizeTests = []struct {
+	about            string
+	maxErrorBodySize int
+	maxSize          int64
+	body             string
+	expectError      string
+}{{
+	about:   "body within limit",
+	maxSize: 20,
+	body:    `"123456789"`,
+}, {
+	about:   "body at limit",
+	maxSize: 11,
+	body:    `"123456789"`,
+}, {
+	about:       "body over limit",
+	maxSize:     10,
+	body:        `"123456789"`,
+	expectError: `Get http://example.com/x: error reading response body: response body too large \(maximum 10 bytes\)`,
+}, {
+	about:            "large body within limit",
+	maxErrorBodySize: 5,
+	maxSize:          20,
+	body:             `"123456789"`,
+}, {
+	about:            "large body over limit",
+	maxErrorBodySize: 5,
+	maxSize:          10,
+	body:             `"123456789"`,
+	expectError:      `Get http://example.com/x: error reading response body: response body too large \(maximum 10 bytes\)`,
+}, {
+	about: "no limit",
+	body:  `"` + strings.Repeat("x", 5000) + `"`,
+}}
+
+func (s *clientSuite) TestMaxResponseBodySize(c *gc.C) {
+	for i, test := range maxResponseBodySizeTests {
+		c.Logf("test %d: %s", i, test.about)
+		restore := testing.Restorer(func() {})
+		if test.maxErrorBodySize != 0 {
+			restore = testing.PatchValue(httprequest.MaxErrorBodySize, test.maxErrorBodySize)
+		}
+		client := httprequest.Client{
+			Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					Status:     "200 OK",
+					StatusCode: http.StatusOK,
+					Header: http.Header{
+						"Content-Type": {"application/json"},
+					},
+					Body:    ioutil.NopCloser(strings.NewReader(test.body)),
+					Request: req,
+				}, nil
+			}),
+			MaxResponseBodySize: test.maxSize,
+		}
+		var resp string
+		err := client.Get(context.Background(), "http://example.com/x", &resp)
+		restore()
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err).(*httprequest.DecodeResponseError).Kind, gc.Equals, httprequest.BodyReadError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(`"`+resp+`"`, gc.Equals, test.body)
+	}
+}
+
 func (s *clientSuite) TestUnmarshalJSONResponseWithBodyReadError(c *gc.C) {
 	resp := &http.Response{
 		Header: http.Header{
@@ -444,6 +1281,7 @@ func (s *clientSuite) TestUnmarshalJSONResponseWithBodyReadError(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `error reading response body: some bad read`)
 	c.Assert(val, gc.IsNil)
 	assertDecodeResponseError(c, err, http.StatusOK, `{"one": "two"}`)
+	c.Assert(errgo.Cause(err).(*httprequest.DecodeResponseError).Kind, gc.Equals, httprequest.BodyReadError)
 }
 
 func (s *clientSuite) TestUnmarshalJSONResponseWithBadContentType(c *gc.C) {
@@ -459,6 +1297,7 @@ func (s *clientSuite) TestUnmarshalJSONResponseWithBadContentType(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `unexpected content type foo/bar; want application/json; content: "something or other"`)
 	c.Assert(val, gc.IsNil)
 	assertDecodeResponseError(c, err, http.StatusTeapot, `something or other`)
+	c.Assert(errgo.Cause(err).(*httprequest.DecodeResponseError).Kind, gc.Equals, httprequest.ContentTypeMismatch)
 }
 
 func (s *clientSuite) TestUnmarshalJSONResponseWithErrorAndLargeBody(c *gc.C) {
@@ -507,6 +1346,7 @@ func (s *clientSuite) TestUnmarshalJSONWithDecodeError(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `json: cannot unmarshal object into Go value of type chan string`)
 	c.Assert(val, gc.IsNil)
 	assertDecodeResponseError(c, err, http.StatusOK, `{"one": "two"}`)
+	c.Assert(errgo.Cause(err).(*httprequest.DecodeResponseError).Kind, gc.Equals, httprequest.DecodeFailure)
 }
 
 func (s *clientSuite) TestUnmarshalJSONWithDecodeErrorAndLargeBody(c *gc.C) {
@@ -524,6 +1364,7 @@ func (s *clientSuite) TestUnmarshalJSONWithDecodeErrorAndLargeBody(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `json: cannot unmarshal string into Go value of type chan string`)
 	c.Assert(val, gc.IsNil)
 	assertDecodeResponseError(c, err, http.StatusOK, `"23456789 1`)
+	c.Assert(errgo.Cause(err).(*httprequest.DecodeResponseError).Kind, gc.Equals, httprequest.DecodeFailure)
 }
 
 func assertDecodeResponseError(c *gc.C, err error, status int, body string) {
@@ -548,7 +1389,108 @@ func (*clientSuite) newServer() *httptest.Server {
 	return httptest.NewServer(router)
 }
 
-var appendURLTests = []struct {
+var baseURLPrefixTests = []struct {
+	about     string
+	baseURL   string
+	expectURL string
+	// expectDoURL holds the URL expected from Do, if it
+	// differs from expectURL.
+	expectDoURL string
+}{{
+	about:     "no prefix",
+	baseURL:   "http://example.com",
+	expectURL: "http://example.com/m/a%2Fb/x?f=1",
+}, {
+	about:     "path prefix",
+	baseURL:   "http://example.com/api/v1",
+	expectURL: "http://example.com/api/v1/m/a%2Fb/x?f=1",
+}, {
+	about:     "path prefix with trailing slash",
+	baseURL:   "http://example.com/api/v1/",
+	expectURL: "http://example.com/api/v1/m/a%2Fb/x?f=1",
+}, {
+	about:     "path prefix needing escaping",
+	baseURL:   "http://example.com/my%20api",
+	expectURL: "http://example.com/my%20api/m/a%2Fb/x?f=1",
+}, {
+	about:     "path prefix with escaped slash",
+	baseURL:   "http://example.com/a%2Fb",
+	expectURL: "http://example.com/a%2Fb/m/a%2Fb/x?f=1",
+}, {
+	about:     "path prefix and query",
+	baseURL:   "http://example.com/api?key=k&f=0",
+	expectURL: "http://example.com/api/m/a%2Fb/x?key=k&f=1",
+	// Do adds the request's query parameters to those of
+	// the base URL rather than replacing them.
+	expectDoURL: "http://example.com/api/m/a%2Fb/x?key=k&f=0&f=1",
+}}
+
+func (s *clientSuite) TestCallWithBaseURLPathPrefix(c *gc.C) {
+	for i, test := range baseURLPrefixTests {
+		c.Logf("test %d: %s", i, test.about)
+		var gotURL string
+		client := httprequest.Client{
+			BaseURL: test.baseURL,
+			Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+				gotURL = req.URL.String()
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": {"application/json"}},
+					Body:       ioutil.NopCloser(strings.NewReader(`{}`)),
+					Request:    req,
+				}, nil
+			}),
+		}
+		err := client.Call(context.Background(), &struct {
+			httprequest.Route `httprequest:"GET /m/:P/x"`
+			P                 string `httprequest:",path"`
+			F                 int    `httprequest:"f,form"`
+		}{
+			P: "a/b",
+			F: 1,
+		}, nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(gotURL, gc.Equals, test.expectURL)
+
+		// Do should use the same path when given a relative request.
+		req, err := http.NewRequest("GET", "/m/a%2Fb/x?f=1", nil)
+		c.Assert(err, gc.IsNil)
+		err = client.Do(context.Background(), req, nil)
+		c.Assert(err, gc.IsNil)
+		if test.expectDoURL != "" {
+			c.Assert(gotURL, gc.Equals, test.expectDoURL)
+		} else {
+			c.Assert(gotURL, gc.Equals, test.expectURL)
+		}
+	}
+}
+
+func (s *clientSuite) TestCallWithBaseURLQueryOverriddenByForm(c *gc.C) {
+	var gotURL string
+	client := httprequest.Client{
+		BaseURL: "http://example.com/api?key=k&limit=10&sort=name",
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			gotURL = req.URL.String()
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       ioutil.NopCloser(strings.NewReader(`{}`)),
+				Request:    req,
+			}, nil
+		}),
+	}
+	err := client.Call(context.Background(), &struct {
+		httprequest.Route `httprequest:"GET /x"`
+		Limit             int    `httprequest:"limit,form"`
+		Sort              string `httprequest:"sort,form,omitempty"`
+	}{
+		Limit: 5,
+	}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(gotURL, gc.Equals, "http://example.com/api/x?key=k&sort=name&limit=5")
+}
+
+var joinURLTests = []struct {
 	u           string
 	p           string
 	expect      string
@@ -597,18 +1539,204 @@ var appendURLTests = []struct {
 	u:      "http://xxx.com?z=w",
 	p:      "/a/b/c",
 	expect: "http://xxx.com/a/b/c?z=w",
+}, {
+	u:      "http://xxx.com/a?z=w",
+	p:      "b?z=v",
+	expect: "http://xxx.com/a/b?z=w&z=v",
+}, {
+	u:           "http://xxx.com",
+	p:           "//foo.com/a",
+	expectError: "relative URL specifies a host",
+}, {
+	u:      "http://xxx.com/a%2Fb",
+	p:      "c%2Fd/e",
+	expect: "http://xxx.com/a%2Fb/c%2Fd/e",
+}, {
+	u:           ":::",
+	p:           "a",
+	expectError: `cannot parse ":::": .*`,
 }}
 
-func (*clientSuite) TestAppendURL(c *gc.C) {
-	for i, test := range appendURLTests {
+type getWithBodyRequest struct {
+	httprequest.Route `httprequest:"GET /search"`
+	Query             *struct {
+		Term string
+	} `httprequest:",body"`
+}
+
+type postWithBodyRequest struct {
+	httprequest.Route `httprequest:"POST /items"`
+	Item              string `httprequest:",body"`
+}
+
+var strictBodyMethodsTests = []struct {
+	about       string
+	strict      bool
+	params      interface{}
+	expectBody  string
+	expectError string
+}{{
+	about: "GET with body",
+	params: &getWithBodyRequest{
+		Query: &struct{ Term string }{"x"},
+	},
+	expectBody: `{"Term":"x"}`,
+}, {
+	about:  "GET with body when strict",
+	strict: true,
+	params: &getWithBodyRequest{
+		Query: &struct{ Term string }{"x"},
+	},
+	expectError: `cannot send body with GET request`,
+}, {
+	about:  "GET without body when strict",
+	strict: true,
+	params: &getWithBodyRequest{},
+}, {
+	about:  "POST with body when strict",
+	strict: true,
+	params: &postWithBodyRequest{
+		Item: "x",
+	},
+	expectBody: `"x"`,
+}, {
+	about:  "DELETE with body when strict",
+	strict: true,
+	params: &chM9Req{
+		P: "foo",
+	},
+	expectBody: `{"I":0}`,
+}}
+
+func (*clientSuite) TestStrictBodyMethods(c *gc.C) {
+	for i, test := range strictBodyMethodsTests {
+		c.Logf("test %d: %s", i, test.about)
+		var doer httprequesttest.Doer
+		doer.AddResponse(httprequesttest.JSONResponse(http.StatusOK, nil))
+		client := httprequest.Client{
+			BaseURL:           "http://example.com",
+			Doer:              &doer,
+			StrictBodyMethods: test.strict,
+		}
+		err := client.Call(context.Background(), test.params, nil)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			c.Assert(doer.Requests(), gc.HasLen, 0)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		reqs := doer.Requests()
+		c.Assert(reqs, gc.HasLen, 1)
+		c.Assert(string(reqs[0].Body), gc.Equals, test.expectBody)
+	}
+}
+
+func (*clientSuite) TestNewClient(c *gc.C) {
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{
+				Name:  "session",
+				Value: "xyz",
+				Path:  "/",
+			})
+			httprequest.WriteJSON(w, http.StatusOK, "logged in")
+		case "/whoami":
+			cookie, err := req.Cookie("session")
+			if err != nil {
+				httprequest.WriteJSON(w, http.StatusOK, "nobody")
+				return
+			}
+			httprequest.WriteJSON(w, http.StatusOK, cookie.Value)
+		case "/slow":
+			<-unblock
+		}
+	}))
+	defer srv.Close()
+	// Unblock the slow handler before the server is closed,
+	// as Close waits for outstanding requests.
+	defer close(unblock)
+
+	jar, err := cookiejar.New(nil)
+	c.Assert(err, gc.IsNil)
+	client := httprequest.NewClient(srv.URL, &http.Client{
+		Jar:     jar,
+		Timeout: 100 * time.Millisecond,
+	})
+	var resp string
+	err = client.Get(context.Background(), "/whoami", &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp, gc.Equals, "nobody")
+
+	err = client.Get(context.Background(), "/login", &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp, gc.Equals, "logged in")
+
+	err = client.Get(context.Background(), "/whoami", &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp, gc.Equals, "xyz")
+
+	err = client.Get(context.Background(), "/slow", &resp)
+	c.Assert(err, gc.ErrorMatches, `Get "?http.*/slow"?: .*Client.Timeout exceeded.*`)
+}
+
+func (*clientSuite) TestNewClientWithNilHTTPClient(c *gc.C) {
+	client := httprequest.NewClient("http://example.com", nil)
+	c.Assert(client.BaseURL, gc.Equals, "http://example.com")
+	c.Assert(client.Doer, gc.IsNil)
+}
+
+func (*clientSuite) TestClone(c *gc.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httprequest.WriteJSON(w, http.StatusOK, req.URL.Path)
+	}))
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL:             srv.URL,
+		MaxResponseBodySize: 1024,
+	}
+	c1 := client.Clone()
+	c.Assert(c1, gc.Not(gc.Equals), client)
+	c.Assert(c1, jc.DeepEquals, client)
+	c1.BaseURL = srv.URL + "/other"
+	c.Assert(client.BaseURL, gc.Equals, srv.URL)
+
+	// Check that concurrently customized clones of a shared
+	// client don't interfere with one another.
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	paths := make([]string, n)
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c1 := client.Clone()
+			c1.BaseURL = srv.URL + "/" + strconv.Itoa(i)
+			errs[i] = c1.Get(context.Background(), "/x", &paths[i])
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		c.Assert(errs[i], gc.IsNil)
+		c.Assert(paths[i], gc.Equals, "/"+strconv.Itoa(i)+"/x")
+	}
+	c.Assert(client.BaseURL, gc.Equals, srv.URL)
+}
+
+func (*clientSuite) TestJoinURL(c *gc.C) {
+	for i, test := range joinURLTests {
 		c.Logf("test %d: %s %s", i, test.u, test.p)
-		u, err := httprequest.AppendURL(test.u, test.p)
+		u, err := httprequest.JoinURL(test.u, test.p)
 		if test.expectError != "" {
-			c.Assert(u, gc.IsNil)
+			c.Assert(u, gc.Equals, "")
 			c.Assert(err, gc.ErrorMatches, test.expectError)
 		} else {
 			c.Assert(err, gc.IsNil)
-			c.Assert(u.String(), gc.Equals, test.expect)
+			c.Assert(u, gc.Equals, test.expect)
 		}
 	}
 }
@@ -682,6 +1810,106 @@ func (clientHandlers) M5(p httprequest.Params, _ *chM5Req) {
 	p.Response.Write([]byte("bad error value"))
 }
 
+type chM6PutReq struct {
+	httprequest.Route `httprequest:"PUT /m6/:P"`
+	P                 string `httprequest:",path"`
+	Body              struct {
+		I int
+	} `httprequest:",body"`
+}
+
+func (clientHandlers) M6Put(p *chM6PutReq) (*chM2Resp, error) {
+	return &chM2Resp{"PUT " + p.P, p.Body.I}, nil
+}
+
+type chM6PatchReq struct {
+	httprequest.Route `httprequest:"PATCH /m6/:P"`
+	P                 string `httprequest:",path"`
+	Body              struct {
+		I int
+	} `httprequest:",body"`
+}
+
+func (clientHandlers) M6Patch(p *chM6PatchReq) (*chM2Resp, error) {
+	return &chM2Resp{"PATCH " + p.P, p.Body.I}, nil
+}
+
+type chM6DeleteReq struct {
+	httprequest.Route `httprequest:"DELETE /m6/:P"`
+	P                 string `httprequest:",path"`
+}
+
+func (clientHandlers) M6Delete(p *chM6DeleteReq) (*chM1Resp, error) {
+	return &chM1Resp{"DELETE " + p.P}, nil
+}
+
+type chM9Req struct {
+	httprequest.Route `httprequest:"DELETE /m9/:P"`
+	P                 string `httprequest:",path"`
+	Body              struct {
+		I int
+	} `httprequest:",body"`
+}
+
+func (clientHandlers) M9(p *chM9Req) (*chM2Resp, error) {
+	return &chM2Resp{"DELETE " + p.P, p.Body.I}, nil
+}
+
+type chM7Req struct {
+	httprequest.Route `httprequest:"GET /m7/:Status"`
+	Status            int `httprequest:",path"`
+}
+
+type chStatusResp struct {
+	Status  int `httprequest:",status" json:"-"`
+	Message string
+}
+
+func (clientHandlers) M7(p httprequest.Params, r *chM7Req) {
+	if r.Status == http.StatusNoContent {
+		p.Response.WriteHeader(r.Status)
+		return
+	}
+	httprequest.WriteJSON(p.Response, r.Status, chStatusResp{
+		Message: "hello",
+	})
+}
+
+type chM8Req struct {
+	httprequest.Route `httprequest:"GET /m8/:Total"`
+	Total             string `httprequest:",path"`
+}
+
+type chHeaderResp struct {
+	Total   int                    `httprequest:"X-Total-Count,responseheader" json:"-"`
+	Links   []string               `httprequest:"Link,responseheader" json:"-"`
+	Link    string                 `httprequest:"Link,responseheader" json:"-"`
+	Next    *string                `httprequest:"x-next,responseheader" json:"-"`
+	Missing *string                `httprequest:"X-Missing,responseheader" json:"-"`
+	Excl    exclamationUnmarshaler `httprequest:"X-Excl,responseheader" json:"-"`
+	ETag    chETag                 `httprequest:"ETag,responseheader" json:"-"`
+	Wait    time.Duration          `httprequest:"X-Wait,responseheader" json:"-"`
+	Size    uint64                 `httprequest:"X-Size,responseheader" json:"-"`
+	Message string
+}
+
+type chETag string
+
+func (clientHandlers) M8(p httprequest.Params, r *chM8Req) (*chHeaderResp, error) {
+	h := p.Response.Header()
+	h.Set("X-Total-Count", r.Total)
+	h.Add("Link", "<a>")
+	h.Add("Link", "<b>")
+	h.Set("X-Next", "/m8?page=2")
+	h.Set("X-Excl", "hello")
+	h.Set("ETag", `W/"a b"`)
+	h.Set("X-Wait", "1m30s")
+	h.Set("X-Size", "18446744073709551615")
+	return &chHeaderResp{
+		Message: "hello",
+	}, nil
+}
+
 type chContentLengthReq struct {
 	httprequest.Route `httprequest:"PUT /content-length"`
 }
@@ -717,6 +1945,10 @@ type closeCountingDoer struct {
 	// closedBodies records the number of response bodies
 	// that have been closed.
 	closedBodies int
+
+	// drainedBodies records the number of response bodies
+	// that had been read to the end when they were closed.
+	drainedBodies int
 }
 
 func (doer *closeCountingDoer) Do(req *http.Request) (*http.Response, error) {
@@ -735,10 +1967,22 @@ func (doer *closeCountingDoer) Do(req *http.Request) (*http.Response, error) {
 type closeCountingReader struct {
 	doer *closeCountingDoer
 	io.ReadCloser
+	eof bool
+}
+
+func (r *closeCountingReader) Read(buf []byte) (int, error) {
+	n, err := r.ReadCloser.Read(buf)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
 }
 
 func (r *closeCountingReader) Close() error {
 	r.doer.closedBodies++
+	if r.eof {
+		r.doer.drainedBodies++
+	}
 	return r.ReadCloser.Close()
 }
 
@@ -0,0 +1,56 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrNotModified is returned by Params.CheckModified when the client
+// already holds the current version of a resource. When a handler
+// returns an error with this cause, Server.WriteError writes a 304
+// (Not Modified) response with no body and the ErrorMapper is not
+// called.
+var ErrNotModified = errgo.New("not modified")
+
+// CheckModified implements conditional requests based on the time
+// that a resource was last modified. It sets the Last-Modified header
+// in p.Response to modTime and, if the request is a GET or HEAD
+// request with an If-Modified-Since header showing that the client's
+// copy of the resource is still current, it returns ErrNotModified.
+// A handler will typically return that error directly:
+//
+//	if err := p.CheckModified(modTime); err != nil {
+//		return nil, err
+//	}
+//
+// HTTP dates have a resolution of one second, so modTime is truncated
+// to a whole second before it is compared. If modTime is zero, no
+// header is set and nil is returned. As specified by RFC 7232, the
+// If-Modified-Since header is ignored if the request also has an
+// If-None-Match header or holds an invalid date.
+func (p Params) CheckModified(modTime time.Time) error {
+	if modTime.IsZero() {
+		return nil
+	}
+	modTime = modTime.UTC().Truncate(time.Second)
+	p.Response.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+	if p.Request.Method != "GET" && p.Request.Method != "HEAD" {
+		return nil
+	}
+	if p.Request.Header.Get("If-None-Match") != "" {
+		return nil
+	}
+	since, err := http.ParseTime(p.Request.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return nil
+	}
+	if modTime.After(since) {
+		return nil
+	}
+	return ErrNotModified
+}
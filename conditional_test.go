@@ -0,0 +1,143 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type conditionalSuite struct{}
+
+var _ = gc.Suite(&conditionalSuite{})
+
+var modTime = time.Date(2017, 3, 4, 10, 20, 30, 500, time.UTC)
+
+var checkModifiedTests = []struct {
+	about              string
+	method             string
+	header             http.Header
+	modTime            time.Time
+	expectLastModified string
+	expectNotModified  bool
+}{{
+	about:              "no If-Modified-Since header",
+	modTime:            modTime,
+	expectLastModified: "Sat, 04 Mar 2017 10:20:30 GMT",
+}, {
+	about: "not modified since",
+	header: http.Header{
+		"If-Modified-Since": {"Sat, 04 Mar 2017 10:20:30 GMT"},
+	},
+	modTime:            modTime,
+	expectLastModified: "Sat, 04 Mar 2017 10:20:30 GMT",
+	expectNotModified:  true,
+}, {
+	about:  "HEAD request not modified since",
+	method: "HEAD",
+	header: http.Header{
+		"If-Modified-Since": {"Sun, 05 Mar 2017 00:00:00 GMT"},
+	},
+	modTime:            modTime,
+	expectLastModified: "Sat, 04 Mar 2017 10:20:30 GMT",
+	expectNotModified:  true,
+}, {
+	about: "modified since",
+	header: http.Header{
+		"If-Modified-Since": {"Sat, 04 Mar 2017 10:20:29 GMT"},
+	},
+	modTime:            modTime,
+	expectLastModified: "Sat, 04 Mar 2017 10:20:30 GMT",
+}, {
+	about: "non-UTC modification time",
+	header: http.Header{
+		"If-Modified-Since": {"Sat, 04 Mar 2017 10:20:30 GMT"},
+	},
+	modTime:            modTime.In(time.FixedZone("X", 3600)),
+	expectLastModified: "Sat, 04 Mar 2017 10:20:30 GMT",
+	expectNotModified:  true,
+}, {
+	about: "invalid If-Modified-Since header",
+	header: http.Header{
+		"If-Modified-Since": {"yesterday"},
+	},
+	modTime:            modTime,
+	expectLastModified: "Sat, 04 Mar 2017 10:20:30 GMT",
+}, {
+	about: "If-None-Match takes precedence",
+	header: http.Header{
+		"If-Modified-Since": {"Sat, 04 Mar 2017 10:20:30 GMT"},
+		"If-None-Match":     {`"x"`},
+	},
+	modTime:            modTime,
+	expectLastModified: "Sat, 04 Mar 2017 10:20:30 GMT",
+}, {
+	about:  "POST request",
+	method: "POST",
+	header: http.Header{
+		"If-Modified-Since": {"Sat, 04 Mar 2017 10:20:30 GMT"},
+	},
+	modTime:            modTime,
+	expectLastModified: "Sat, 04 Mar 2017 10:20:30 GMT",
+}, {
+	about: "zero modification time",
+	header: http.Header{
+		"If-Modified-Since": {"Sat, 04 Mar 2017 10:20:30 GMT"},
+	},
+}}
+
+func (*conditionalSuite) TestCheckModified(c *gc.C) {
+	for i, test := range checkModifiedTests {
+		c.Logf("test %d: %s", i, test.about)
+		method := test.method
+		if method == "" {
+			method = "GET"
+		}
+		rec := httptest.NewRecorder()
+		p := httprequest.Params{
+			Response: rec,
+			Request: &http.Request{
+				Method: method,
+				Header: test.header,
+			},
+		}
+		err := p.CheckModified(test.modTime)
+		if test.expectNotModified {
+			c.Assert(err, gc.Equals, httprequest.ErrNotModified)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+		c.Assert(rec.Header().Get("Last-Modified"), gc.Equals, test.expectLastModified)
+	}
+}
+
+func (*conditionalSuite) TestNotModifiedResponse(c *gc.C) {
+	h := testServer.Handle(func(p httprequest.Params, _ *struct{}) (string, error) {
+		if err := p.CheckModified(modTime); err != nil {
+			return "", err
+		}
+		return "content", nil
+	})
+	req, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, gc.IsNil)
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, `"content"`)
+	lastModified := rec.Header().Get("Last-Modified")
+	c.Assert(lastModified, gc.Equals, "Sat, 04 Mar 2017 10:20:30 GMT")
+
+	req.Header.Set("If-Modified-Since", lastModified)
+	rec = httptest.NewRecorder()
+	h.Handle(rec, req, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusNotModified)
+	c.Assert(rec.Body.String(), gc.Equals, "")
+	c.Assert(rec.Header().Get("Last-Modified"), gc.Equals, lastModified)
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "")
+}
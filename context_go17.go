@@ -11,7 +11,7 @@ import (
 )
 
 func contextFromRequest(req *http.Request) (context.Context, context.CancelFunc) {
-	return req.Context(), func() {}
+	return context.WithValue(req.Context(), requestKey{}, req), func() {}
 }
 
 func requestWithContext(req *http.Request, ctx context.Context) *http.Request {
@@ -13,7 +13,7 @@ import (
 
 func contextFromRequest(req *http.Request) (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
-	return ctx, cancel
+	return context.WithValue(ctx, requestKey{}, req), cancel
 }
 
 func requestWithContext(req *http.Request, _ context.Context) *http.Request {
@@ -0,0 +1,186 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CORSPolicy holds a Cross-Origin Resource Sharing policy,
+// as used by Server.CORS.
+type CORSPolicy struct {
+	// AllowedOrigins holds the origins, such as
+	// "https://example.com", from which cross-origin requests
+	// are allowed. The origin "*" allows requests from any origin,
+	// unless AllowCredentials is set, in which case it matches no
+	// origin, because allowing any web site to make requests with
+	// a user's credentials and read the responses is unsafe.
+	AllowedOrigins []string
+
+	// AllowedMethods holds the methods that may be used in
+	// cross-origin requests. If it is empty, all the methods
+	// handled for the requested path are allowed.
+	AllowedMethods []string
+
+	// AllowedHeaders holds the request headers, other than
+	// the CORS-safelisted ones, that may be sent in
+	// cross-origin requests.
+	AllowedHeaders []string
+
+	// ExposedHeaders holds the response headers, other than
+	// the CORS-safelisted ones, that browsers should make
+	// available to scripts.
+	ExposedHeaders []string
+
+	// AllowCredentials specifies that cross-origin requests
+	// may be made with credentials such as cookies. When it is
+	// set, the allowed origins must be listed explicitly in
+	// AllowedOrigins.
+	AllowCredentials bool
+
+	// MaxAge holds how long the response to a preflight request
+	// may be cached for. If it is zero, no Access-Control-Max-Age
+	// header is sent.
+	MaxAge time.Duration
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin
+// header for a request from the given origin, or the empty string
+// if the origin is not allowed. It also reports whether the result
+// depends on the origin, in which case the response must have
+// a "Vary: Origin" header.
+func (p *CORSPolicy) allowOrigin(origin string) (allow string, vary bool) {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			if p.AllowCredentials {
+				// Browsers refuse credentialed requests
+				// with a literal "*", so don't work around
+				// that by reflecting the origin.
+				continue
+			}
+			return "*", false
+		}
+		if strings.EqualFold(o, origin) {
+			return origin, true
+		}
+	}
+	return "", true
+}
+
+// setOriginHeaders sets the headers in h that are common to all
+// responses to a cross-origin request from the given origin. It
+// reports whether the origin is allowed.
+func (p *CORSPolicy) setOriginHeaders(h http.Header, origin string) bool {
+	allow, vary := p.allowOrigin(origin)
+	if vary {
+		h.Add("Vary", "Origin")
+	}
+	if allow == "" {
+		return false
+	}
+	h.Set("Access-Control-Allow-Origin", allow)
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// setPreflightHeaders sets the headers in h for a response to
+// a preflight request, given the methods handled for the
+// request path.
+func (p *CORSPolicy) setPreflightHeaders(h http.Header, req *http.Request, methods []string) {
+	origin := req.Header.Get("Origin")
+	method := req.Header.Get("Access-Control-Request-Method")
+	if origin == "" || method == "" {
+		// Not a preflight request.
+		return
+	}
+	if len(p.AllowedMethods) > 0 {
+		methods = p.AllowedMethods
+	}
+	if !p.setOriginHeaders(h, origin) || !containsString(methods, method) {
+		return
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(p.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+}
+
+// corsHandler returns a handler that calls h after adding the
+// headers required by the given policy to the response.
+func corsHandler(policy *CORSPolicy, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		if origin := req.Header.Get("Origin"); origin != "" {
+			if policy.setOriginHeaders(w.Header(), origin) && len(policy.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+			}
+		}
+		h(w, req, p)
+	}
+}
+
+// addOPTIONSHandlers returns hs with an OPTIONS handler added for
+// each path in hs that does not already have one. If policy is
+// non-nil, the handlers respond to CORS preflight requests
+// according to it.
+func addOPTIONSHandlers(hs []Handler, policy *CORSPolicy) []Handler {
+	methods := make(map[string][]string)
+	hasOPTIONS := make(map[string]bool)
+	var paths []string
+	for _, h := range hs {
+		if _, ok := methods[h.Path]; !ok {
+			paths = append(paths, h.Path)
+		}
+		if h.Method == "OPTIONS" {
+			hasOPTIONS[h.Path] = true
+		}
+		methods[h.Path] = append(methods[h.Path], h.Method)
+	}
+	for _, path := range paths {
+		if hasOPTIONS[path] {
+			continue
+		}
+		allow := append(methods[path], "OPTIONS")
+		sort.Strings(allow)
+		hs = append(hs, Handler{
+			Method: "OPTIONS",
+			Path:   path,
+			Handle: optionsHandler(allow, policy),
+		})
+	}
+	return hs
+}
+
+// optionsHandler returns an OPTIONS handler for a path
+// handled with the given methods.
+func optionsHandler(methods []string, policy *CORSPolicy) httprouter.Handle {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		w.Header().Set("Allow", allow)
+		if policy != nil {
+			policy.setPreflightHeaders(w.Header(), req, methods)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// containsString reports whether ss contains s.
+func containsString(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,339 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type corsSuite struct{}
+
+var _ = gc.Suite(&corsSuite{})
+
+type corsHandlers struct{}
+
+func (corsHandlers) Get(*struct {
+	httprequest.Route `httprequest:"GET /item/:id"`
+}) (string, error) {
+	return "some content", nil
+}
+
+func (corsHandlers) Put(*struct {
+	httprequest.Route `httprequest:"PUT /item/:id"`
+}) error {
+	return nil
+}
+
+func newCORSHandlers(p httprequest.Params) (corsHandlers, context.Context, error) {
+	return corsHandlers{}, p.Context, nil
+}
+
+type otherCORSHandlers struct{}
+
+func (otherCORSHandlers) Delete(*struct {
+	httprequest.Route `httprequest:"DELETE /item/:id"`
+}) error {
+	return nil
+}
+
+func newOtherCORSHandlers(p httprequest.Params) (otherCORSHandlers, context.Context, error) {
+	return otherCORSHandlers{}, p.Context, nil
+}
+
+func handlerMethods(hs []httprequest.Handler) map[string][]string {
+	methods := make(map[string][]string)
+	for _, h := range hs {
+		methods[h.Path] = append(methods[h.Path], h.Method)
+	}
+	for _, m := range methods {
+		sort.Strings(m)
+	}
+	return methods
+}
+
+func (*corsSuite) TestHandleOPTIONSDisabled(c *gc.C) {
+	c.Assert(handlerMethods(testServer.Handlers(newCORSHandlers)), gc.DeepEquals, map[string][]string{
+		"/item/:id": {"GET", "PUT"},
+	})
+}
+
+func (*corsSuite) TestHandleOPTIONS(c *gc.C) {
+	srv := testServer
+	srv.HandleOPTIONS = true
+	hs := srv.Handlers(newCORSHandlers)
+	c.Assert(handlerMethods(hs), gc.DeepEquals, map[string][]string{
+		"/item/:id": {"GET", "OPTIONS", "PUT"},
+	})
+	router := httprouter.New()
+	httprequest.AddHandlers(router, hs)
+
+	req, err := http.NewRequest("OPTIONS", "/item/1", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, gc.Equals, http.StatusNoContent)
+	c.Assert(rec.HeaderMap, gc.DeepEquals, http.Header{
+		"Allow": {"GET, OPTIONS, PUT"},
+	})
+}
+
+func (*corsSuite) TestAllHandlersWithOPTIONS(c *gc.C) {
+	srv := testServer
+	srv.HandleHEAD = true
+	srv.HandleOPTIONS = true
+	hs := srv.AllHandlers(newCORSHandlers, newOtherCORSHandlers)
+	c.Assert(handlerMethods(hs), gc.DeepEquals, map[string][]string{
+		"/item/:id": {"DELETE", "GET", "HEAD", "OPTIONS", "PUT"},
+	})
+	router := httprouter.New()
+	httprequest.AddHandlers(router, hs)
+	req, err := http.NewRequest("OPTIONS", "/item/1", nil)
+	c.Assert(err, gc.IsNil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Header().Get("Allow"), gc.Equals, "DELETE, GET, HEAD, OPTIONS, PUT")
+}
+
+type explicitOPTIONSHandlers struct{}
+
+func (explicitOPTIONSHandlers) Get(*struct {
+	httprequest.Route `httprequest:"GET /item/:id"`
+}) (string, error) {
+	return "some content", nil
+}
+
+func (explicitOPTIONSHandlers) Options(p httprequest.Params, _ *struct {
+	httprequest.Route `httprequest:"OPTIONS /item/:id"`
+}) {
+	p.Response.Header().Set("Allow", "custom")
+	p.Response.WriteHeader(http.StatusOK)
+}
+
+func (*corsSuite) TestHandleOPTIONSWithExplicitOPTIONSHandler(c *gc.C) {
+	srv := testServer
+	srv.HandleOPTIONS = true
+	srv.CORS = &httprequest.CORSPolicy{
+		AllowedOrigins: []string{"*"},
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (explicitOPTIONSHandlers, context.Context, error) {
+		return explicitOPTIONSHandlers{}, p.Context, nil
+	})
+	c.Assert(handlerMethods(hs), gc.DeepEquals, map[string][]string{
+		"/item/:id": {"GET", "OPTIONS"},
+	})
+	router := httprouter.New()
+	httprequest.AddHandlers(router, hs)
+	req, err := http.NewRequest("OPTIONS", "/item/1", nil)
+	c.Assert(err, gc.IsNil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Allow"), gc.Equals, "custom")
+}
+
+var corsTests = []struct {
+	about        string
+	policy       httprequest.CORSPolicy
+	method       string
+	path         string
+	header       http.Header
+	expectStatus int
+	expectHeader http.Header
+}{{
+	about: "preflight request",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedHeaders: []string{"Content-Type", "X-Token"},
+		ExposedHeaders: []string{"X-Total"},
+		MaxAge:         10 * time.Minute,
+	},
+	method: "OPTIONS",
+	path:   "/item/1",
+	header: http.Header{
+		"Origin":                        {"https://example.com"},
+		"Access-Control-Request-Method": {"PUT"},
+	},
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow":                        {"GET, OPTIONS, PUT"},
+		"Vary":                         {"Origin"},
+		"Access-Control-Allow-Origin":  {"https://example.com"},
+		"Access-Control-Allow-Methods": {"GET, OPTIONS, PUT"},
+		"Access-Control-Allow-Headers": {"Content-Type, X-Token"},
+		"Access-Control-Max-Age":       {"600"},
+	},
+}, {
+	about: "preflight request from disallowed origin",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins: []string{"https://example.com"},
+	},
+	method: "OPTIONS",
+	path:   "/item/1",
+	header: http.Header{
+		"Origin":                        {"https://other.example.com"},
+		"Access-Control-Request-Method": {"PUT"},
+	},
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow": {"GET, OPTIONS, PUT"},
+		"Vary":  {"Origin"},
+	},
+}, {
+	about: "preflight request with disallowed method",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedMethods: []string{"GET"},
+	},
+	method: "OPTIONS",
+	path:   "/item/1",
+	header: http.Header{
+		"Origin":                        {"https://example.com"},
+		"Access-Control-Request-Method": {"PUT"},
+	},
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow":                       {"GET, OPTIONS, PUT"},
+		"Vary":                        {"Origin"},
+		"Access-Control-Allow-Origin": {"https://example.com"},
+	},
+}, {
+	about: "preflight request with any origin allowed",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "PUT"},
+	},
+	method: "OPTIONS",
+	path:   "/item/1",
+	header: http.Header{
+		"Origin":                        {"https://example.com"},
+		"Access-Control-Request-Method": {"PUT"},
+	},
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow":                        {"GET, OPTIONS, PUT"},
+		"Access-Control-Allow-Origin":  {"*"},
+		"Access-Control-Allow-Methods": {"GET, PUT"},
+	},
+}, {
+	about: "OPTIONS request that is not a preflight request",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins: []string{"*"},
+	},
+	method:       "OPTIONS",
+	path:         "/item/1",
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow": {"GET, OPTIONS, PUT"},
+	},
+}, {
+	about: "cross-origin request",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins: []string{"https://example.com"},
+		ExposedHeaders: []string{"X-Total"},
+	},
+	method: "GET",
+	path:   "/item/1",
+	header: http.Header{
+		"Origin": {"https://example.com"},
+	},
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type":                  {"application/json"},
+		"Vary":                          {"Origin"},
+		"Access-Control-Allow-Origin":   {"https://example.com"},
+		"Access-Control-Expose-Headers": {"X-Total"},
+	},
+}, {
+	about: "cross-origin request with credentials",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowCredentials: true,
+	},
+	method: "GET",
+	path:   "/item/1",
+	header: http.Header{
+		"Origin": {"https://example.com"},
+	},
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type":                     {"application/json"},
+		"Vary":                             {"Origin"},
+		"Access-Control-Allow-Origin":      {"https://example.com"},
+		"Access-Control-Allow-Credentials": {"true"},
+	},
+}, {
+	about: "any origin is not allowed with credentials",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	},
+	method: "GET",
+	path:   "/item/1",
+	header: http.Header{
+		"Origin": {"https://example.com"},
+	},
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type": {"application/json"},
+		"Vary":         {"Origin"},
+	},
+}, {
+	about: "cross-origin request from disallowed origin",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins: []string{"https://example.com"},
+		ExposedHeaders: []string{"X-Total"},
+	},
+	method: "GET",
+	path:   "/item/1",
+	header: http.Header{
+		"Origin": {"https://other.example.com"},
+	},
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type": {"application/json"},
+		"Vary":         {"Origin"},
+	},
+}, {
+	about: "same-origin request",
+	policy: httprequest.CORSPolicy{
+		AllowedOrigins: []string{"https://example.com"},
+	},
+	method:       "GET",
+	path:         "/item/1",
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type": {"application/json"},
+	},
+}}
+
+func (*corsSuite) TestCORS(c *gc.C) {
+	for i, test := range corsTests {
+		c.Logf("test %d: %s", i, test.about)
+		srv := testServer
+		policy := test.policy
+		srv.CORS = &policy
+		router := httprouter.New()
+		httprequest.AddHandlers(router, srv.Handlers(newCORSHandlers))
+		req, err := http.NewRequest(test.method, test.path, nil)
+		c.Assert(err, gc.IsNil)
+		for k, v := range test.header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		c.Assert(rec.HeaderMap, gc.DeepEquals, test.expectHeader)
+	}
+}
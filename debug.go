@@ -0,0 +1,68 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"encoding/json"
+
+	"golang.org/x/net/context"
+	"gopkg.in/errgo.v1"
+)
+
+// debugKey is the context key used to record
+// that a server is in debug mode.
+type debugKey struct{}
+
+// IsDebug reports whether the given context is that of an error being
+// written by a Server with its Debug field set. It is intended to be
+// called by a Server.ErrorMapper, which might include extra
+// information in the error response when it returns true. A nil
+// context is not in debug mode.
+func IsDebug(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	debug, _ := ctx.Value(debugKey{}).(bool)
+	return debug
+}
+
+// RemoteErrorInfo holds the value stored in the Info field of a
+// RemoteError produced by the error mapper returned by
+// RemoteErrorMapper when the server is in debug mode.
+type RemoteErrorInfo struct {
+	// Details holds the details of the error chain, including
+	// source locations, as returned by errgo.Details.
+	Details string
+}
+
+// RemoteErrorMapper returns a function suitable for use as
+// Server.ErrorMapper that writes errors as *RemoteError values.
+// The given function is used to determine the HTTP status
+// and error code for an error; the error message is used
+// as the error message.
+//
+// If the Server's Debug field is set, the Info field of the
+// RemoteError holds a RemoteErrorInfo value describing the error
+// chain, so that the source locations recorded by errgo are not
+// lost. This may reveal details of the server implementation, so
+// should only be used in development.
+func RemoteErrorMapper(f func(ctx context.Context, err error) (httpStatus int, code string)) func(ctx context.Context, err error) (httpStatus int, errorBody interface{}) {
+	return func(ctx context.Context, err error) (int, interface{}) {
+		status, code := f(ctx, err)
+		rerr := &RemoteError{
+			Message: err.Error(),
+			Code:    code,
+		}
+		if IsDebug(ctx) {
+			data, jerr := json.Marshal(RemoteErrorInfo{
+				Details: errgo.Details(err),
+			})
+			if jerr == nil {
+				info := json.RawMessage(data)
+				rerr.Info = &info
+			}
+		}
+		return status, rerr
+	}
+}
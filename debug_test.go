@@ -0,0 +1,94 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type debugSuite struct{}
+
+var _ = gc.Suite(&debugSuite{})
+
+var remoteErrorMapper = httprequest.RemoteErrorMapper(func(ctx context.Context, err error) (int, string) {
+	if errgo.Cause(err) == errUnauth {
+		return http.StatusUnauthorized, "unauthorized"
+	}
+	return http.StatusInternalServerError, ""
+})
+
+func (*debugSuite) TestRemoteErrorMapper(c *gc.C) {
+	srv := httprequest.Server{
+		ErrorMapper: remoteErrorMapper,
+	}
+	rec := httptest.NewRecorder()
+	srv.WriteError(context.Background(), rec, errgo.NoteMask(errUnauth, "cannot do it", errgo.Any))
+	c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized)
+	c.Assert(rec.Body.String(), gc.Equals, `{"Message":"cannot do it: unauth","Code":"unauthorized"}`)
+}
+
+func (*debugSuite) TestRemoteErrorMapperInDebugMode(c *gc.C) {
+	srv := httprequest.Server{
+		ErrorMapper: remoteErrorMapper,
+		Debug:       true,
+	}
+	rec := httptest.NewRecorder()
+	srv.WriteError(context.Background(), rec, errgo.NoteMask(errUnauth, "cannot do it", errgo.Any))
+	c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized)
+	var rerr httprequest.RemoteError
+	err := json.Unmarshal(rec.Body.Bytes(), &rerr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(rerr.Message, gc.Equals, "cannot do it: unauth")
+	c.Assert(rerr.Code, gc.Equals, "unauthorized")
+	c.Assert(rerr.Info, gc.NotNil)
+	var info httprequest.RemoteErrorInfo
+	err = json.Unmarshal(*rerr.Info, &info)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Details, gc.Matches, `\[\{.*debug_test.go:[0-9]+: cannot do it\} \{unauth\}\]`)
+}
+
+func (*debugSuite) TestIsDebug(c *gc.C) {
+	c.Assert(httprequest.IsDebug(context.Background()), gc.Equals, false)
+	for _, debug := range []bool{false, true} {
+		var gotDebug bool
+		srv := httprequest.Server{
+			ErrorMapper: func(ctx context.Context, err error) (int, interface{}) {
+				gotDebug = httprequest.IsDebug(ctx)
+				return http.StatusInternalServerError, nil
+			},
+			Debug: debug,
+		}
+		srv.WriteError(context.Background(), httptest.NewRecorder(), errgo.New("x"))
+		c.Assert(gotDebug, gc.Equals, debug)
+
+		// A nil context is allowed too.
+		gotDebug = !debug
+		srv.WriteError(nil, httptest.NewRecorder(), errgo.New("x"))
+		c.Assert(gotDebug, gc.Equals, debug)
+	}
+}
+
+func (*debugSuite) TestWriteErrorWithNilContextAndLocalizedErrorMapper(c *gc.C) {
+	c.Assert(httprequest.RequestFromContext(nil), gc.IsNil)
+	srv := httprequest.Server{
+		ErrorMapper: httprequest.LocalizedErrorMapper(testCatalog, func(context.Context, error) (int, interface{}) {
+			return http.StatusUnauthorized, &httprequest.RemoteError{
+				Message: "access denied",
+				Code:    "unauthorized",
+			}
+		}),
+	}
+	rec := httptest.NewRecorder()
+	srv.WriteError(nil, rec, errgo.New("access denied"))
+	c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized)
+	c.Assert(rec.Body.String(), gc.Equals, `{"Message":"access denied","Code":"unauthorized"}`)
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// DefaultMaxDecompressedBodySize holds the default maximum size of a
+// decompressed request body. See Server.MaxDecompressedBodySize.
+const DefaultMaxDecompressedBodySize = 10 * 1024 * 1024
+
+// decompressBody replaces the body of req with a reader that
+// decompresses it according to its Content-Encoding header, returning
+// an error with an ErrUnmarshal cause (see also ErrBodyDecode) if the
+// encoding is not supported or the body is not valid. Reading more
+// than maxSize bytes from the new body will fail.
+func decompressBody(req *http.Request, maxSize int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || req.Body == nil {
+		return nil
+	}
+	var r io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(req.Body)
+	case "deflate":
+		r, err = zlib.NewReader(req.Body)
+	default:
+		return unmarshalErrorf(nil, ErrBodyDecode, "unsupported request Content-Encoding %q", encoding)
+	}
+	if err != nil {
+		return unmarshalErrorf(err, ErrBodyDecode, "cannot decompress request body")
+	}
+	req.Body = &decompressedBody{
+		r:       r,
+		body:    req.Body,
+		maxSize: maxSize,
+	}
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	return nil
+}
+
+// decompressedBody is a request body that reads
+// decompressed data, up to a maximum size.
+type decompressedBody struct {
+	r       io.ReadCloser
+	body    io.ReadCloser
+	n       int64
+	maxSize int64
+}
+
+// Read implements io.Reader.Read.
+func (b *decompressedBody) Read(buf []byte) (int, error) {
+	if b.n > b.maxSize {
+		return 0, b.tooLarge()
+	}
+	if int64(len(buf)) > b.maxSize-b.n+1 {
+		// Read at most one more byte than the maximum
+		// so that we can tell when it has been exceeded.
+		buf = buf[:b.maxSize-b.n+1]
+	}
+	n, err := b.r.Read(buf)
+	b.n += int64(n)
+	if b.n > b.maxSize {
+		return n - int(b.n-b.maxSize), b.tooLarge()
+	}
+	if err != nil && err != io.EOF {
+		err = errgo.Notef(err, "cannot decompress request body")
+	}
+	return n, err
+}
+
+func (b *decompressedBody) tooLarge() error {
+	return errgo.Newf("decompressed request body too large (maximum %d bytes)", b.maxSize)
+}
+
+// Close implements io.Closer.Close by closing both
+// the decompressor and the original body.
+func (b *decompressedBody) Close() error {
+	b.r.Close()
+	return b.body.Close()
+}
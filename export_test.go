@@ -1,4 +1,21 @@
 package httprequest
 
-var AppendURL = appendURL
+import "reflect"
+
 var MaxErrorBodySize = &maxErrorBodySize
+
+// ResetTypeCache clears the cache of parsed request types.
+func ResetTypeCache() {
+	typeMutex.Lock()
+	defer typeMutex.Unlock()
+	typeMap = make(map[reflect.Type]*requestType)
+}
+
+// TypeCacheLen returns the number of request types in the cache.
+func TypeCacheLen() int {
+	typeMutex.RLock()
+	defer typeMutex.RUnlock()
+	return len(typeMap)
+}
+
+var PathPatternMatches = pathPatternMatches
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"strings"
 	"unicode"
 
 	"golang.org/x/net/html"
@@ -30,18 +31,43 @@ type DecodeResponseError struct {
 	// DecodeError holds the error that was encountered
 	// when decoding.
 	DecodeError error
+
+	// Kind holds the kind of failure that was encountered.
+	Kind DecodeErrorKind
 }
 
 func (e *DecodeResponseError) Error() string {
 	return e.DecodeError.Error()
 }
 
-// newDecodeResponseError returns a new DecodeResponseError that
-// uses the given error for its message. The Response field
-// holds a copy of req. If bodyData is non-nil, it
-// will be used as the data in the Response.Body field;
-// otherwise body data will be read from req.Body.
-func newDecodeResponseError(resp *http.Response, bodyData []byte, err error) *DecodeResponseError {
+// DecodeErrorKind classifies the reason that a response
+// could not be decoded.
+type DecodeErrorKind int
+
+const (
+	// DecodeFailure is used when the response body was read
+	// but could not be decoded into the required value.
+	DecodeFailure DecodeErrorKind = iota
+
+	// ContentTypeMismatch is used when the response
+	// has an unexpected content type.
+	ContentTypeMismatch
+
+	// BodyReadError is used when the response
+	// body could not be read.
+	BodyReadError
+
+	// UnexpectedRedirect is used when the response
+	// was an unexpected HTTP redirect.
+	UnexpectedRedirect
+)
+
+// newDecodeResponseError returns a new DecodeResponseError of the
+// given kind that uses the given error for its message. The Response
+// field holds a copy of req. If bodyData is non-nil, it will be used as
+// the data in the Response.Body field; otherwise body data will be read
+// from req.Body.
+func newDecodeResponseError(resp *http.Response, bodyData []byte, kind DecodeErrorKind, err error) *DecodeResponseError {
 	if bodyData == nil {
 		bodyData = readBodyForError(resp.Body)
 	}
@@ -51,6 +77,7 @@ func newDecodeResponseError(resp *http.Response, bodyData []byte, err error) *De
 	return &DecodeResponseError{
 		Response:    &resp1,
 		DecodeError: errgo.Mask(err, errgo.Any),
+		Kind:        kind,
 	}
 }
 
@@ -132,6 +159,18 @@ func isJSONMediaType(header http.Header) bool {
 	return mediaType == "application/json"
 }
 
+// isJSONResponseMediaType is like isJSONMediaType except that it
+// also accepts any media type with a "+json" structured syntax suffix
+// (RFC 6839), such as application/problem+json, as many APIs use such
+// media types for responses that hold plain JSON.
+func isJSONResponseMediaType(header http.Header) bool {
+	if isJSONMediaType(header) {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return strings.HasSuffix(mediaType, "+json")
+}
+
 // Error implements error.Error by trying to produce a decent
 // error message derived from the body content.
 func (e *fancyDecodeError) Error() string {
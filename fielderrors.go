@@ -0,0 +1,128 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"reflect"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// FieldError holds an error unmarshaling a single field.
+// See FieldErrors.
+type FieldError struct {
+	// Field holds the name of the struct field.
+	Field string
+
+	// Err holds the reason that the field could not
+	// be unmarshaled.
+	Err error
+
+	// cause holds ErrFieldParse or ErrBodyDecode.
+	cause error
+}
+
+// Error implements the error interface.
+func (e *FieldError) Error() string {
+	return "cannot unmarshal into field " + e.Field + ": " + e.Err.Error()
+}
+
+// Cause implements errgo.Causer by returning ErrFieldParse or
+// ErrBodyDecode, so that UnmarshalErrorCause works on the error.
+func (e *FieldError) Cause() error {
+	return e.cause
+}
+
+// FieldErrors holds the errors for all the fields that could not be
+// unmarshaled, in field order. It is the underlying error of an
+// error returned by UnmarshalAll, or by a handler when
+// Server.CollectFieldErrors is set, so that an ErrorMapper can report
+// all the invalid fields in a request at once rather than just the
+// first. Use UnmarshalFieldErrors to find it.
+type FieldErrors []*FieldError
+
+// Error implements the error interface by
+// joining the messages of all the errors.
+func (e FieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, ferr := range e {
+		msgs[i] = ferr.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Cause implements errgo.Causer. If all the errors have the same cause
+// (ErrFieldParse or ErrBodyDecode), it returns that cause so that
+// UnmarshalErrorCause can find it; otherwise it returns nil.
+func (e FieldErrors) Cause() error {
+	var cause error
+	for i, ferr := range e {
+		if i > 0 && ferr.cause != cause {
+			return nil
+		}
+		cause = ferr.cause
+	}
+	return cause
+}
+
+// UnmarshalFieldErrors returns the FieldErrors value held in the
+// chain of underlying errors of err, or nil if there is none.
+func UnmarshalFieldErrors(err error) FieldErrors {
+	for e := err; e != nil; {
+		if ferrs, ok := e.(FieldErrors); ok {
+			return ferrs
+		}
+		w, ok := e.(errgo.Wrapper)
+		if !ok {
+			break
+		}
+		e = w.Underlying()
+	}
+	return nil
+}
+
+// UnmarshalAll is like Unmarshal except that, rather than returning
+// when the first field cannot be unmarshaled, it tries to unmarshal
+// all the fields. If any fail, the returned error has an ErrUnmarshal
+// cause and a FieldErrors underlying error describing each of them.
+func UnmarshalAll(p Params, x interface{}) error {
+	xv := reflect.ValueOf(x)
+	pt, err := getRequestType(xv.Type())
+	if err != nil {
+		return errgo.WithCausef(err, ErrBadUnmarshalType, "bad type %s", xv.Type())
+	}
+	if err := unmarshalAll(p, xv, pt); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrUnmarshal))
+	}
+	return nil
+}
+
+// unmarshalAll is the internal version of UnmarshalAll.
+func unmarshalAll(p Params, xv reflect.Value, pt *requestType) error {
+	if pt.unmarshalParams {
+		return unmarshalParams(p, xv)
+	}
+	xv = xv.Elem()
+	var ferrs FieldErrors
+	for _, f := range pt.fields {
+		fv := xv.FieldByIndex(f.index)
+		if err := f.unmarshal(fv, p, f.makeResult); err != nil {
+			ferrs = append(ferrs, &FieldError{
+				Field: f.name,
+				Err:   err,
+				cause: fieldErrorCause(f),
+			})
+		}
+	}
+	if len(ferrs) == 0 {
+		return nil
+	}
+	err := &errgo.Err{
+		Underlying_: ferrs,
+		Cause_:      ErrUnmarshal,
+	}
+	err.SetLocation(0)
+	return err
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type fieldErrorsSuite struct{}
+
+var _ = gc.Suite(&fieldErrorsSuite{})
+
+type fieldErrorsRequest struct {
+	Limit  int     `httprequest:"limit,form"`
+	Offset int     `httprequest:"offset,form"`
+	Name   string  `httprequest:"name,form"`
+	Ratio  float64 `httprequest:"X-Ratio,header"`
+}
+
+func (*fieldErrorsSuite) TestUnmarshalAll(c *gc.C) {
+	p := httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{
+				"X-Ratio": {"high"},
+			},
+			Form: url.Values{
+				"limit":  {"many"},
+				"offset": {"10"},
+				"name":   {"bob"},
+			},
+		},
+	}
+	var x fieldErrorsRequest
+	err := httprequest.UnmarshalAll(p, &x)
+	c.Assert(err, gc.ErrorMatches, `cannot unmarshal into field Limit: cannot parse form field "limit" value "many" into int: expected integer; cannot unmarshal into field Ratio: cannot parse header "X-Ratio" value "high" into float64: .*`)
+	c.Assert(errgo.Cause(err), gc.Equals, httprequest.ErrUnmarshal)
+	c.Assert(httprequest.UnmarshalErrorCause(err), gc.Equals, httprequest.ErrFieldParse)
+	c.Assert(x.Offset, gc.Equals, 10)
+	c.Assert(x.Name, gc.Equals, "bob")
+
+	ferrs := httprequest.UnmarshalFieldErrors(err)
+	c.Assert(ferrs, gc.HasLen, 2)
+	c.Assert(ferrs[0].Field, gc.Equals, "Limit")
+	c.Assert(ferrs[0].Err, gc.ErrorMatches, `cannot parse form field "limit" value "many" into int: expected integer`)
+	c.Assert(ferrs[1].Field, gc.Equals, "Ratio")
+
+	// Unmarshal still fails on the first field.
+	err = httprequest.Unmarshal(p, &x)
+	c.Assert(err, gc.ErrorMatches, `cannot unmarshal into field Limit: cannot parse form field "limit" value "many" into int: expected integer`)
+	c.Assert(httprequest.UnmarshalFieldErrors(err), gc.IsNil)
+}
+
+func (*fieldErrorsSuite) TestUnmarshalAllMixedCauses(c *gc.C) {
+	p := httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			Form: url.Values{
+				"limit": {"many"},
+			},
+			Body: body(`{"bad`),
+		},
+	}
+	var x struct {
+		Limit int `httprequest:"limit,form"`
+		Body  struct {
+			A int
+		} `httprequest:",body"`
+	}
+	err := httprequest.UnmarshalAll(p, &x)
+	c.Assert(err, gc.ErrorMatches, `cannot unmarshal into field Limit: .*; cannot unmarshal into field Body: .*`)
+	c.Assert(httprequest.UnmarshalErrorCause(err), gc.Equals, httprequest.ErrUnmarshal)
+	ferrs := httprequest.UnmarshalFieldErrors(err)
+	c.Assert(ferrs, gc.HasLen, 2)
+	c.Assert(httprequest.UnmarshalErrorCause(ferrs[1]), gc.Equals, httprequest.ErrBodyDecode)
+}
+
+func (*fieldErrorsSuite) TestUnmarshalAllSuccess(c *gc.C) {
+	var x fieldErrorsRequest
+	err := httprequest.UnmarshalAll(httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"limit": {"5"},
+			},
+		},
+	}, &x)
+	c.Assert(err, gc.IsNil)
+	c.Assert(x.Limit, gc.Equals, 5)
+}
+
+func (*fieldErrorsSuite) TestCollectFieldErrors(c *gc.C) {
+	var gotFields []string
+	srv := httprequest.Server{
+		CollectFieldErrors: true,
+		ErrorMapper: func(ctx context.Context, err error) (int, interface{}) {
+			for _, ferr := range httprequest.UnmarshalFieldErrors(err) {
+				gotFields = append(gotFields, ferr.Field)
+			}
+			return testErrorMapper(ctx, err)
+		},
+	}
+	router := httprouter.New()
+	h := srv.Handle(func(p httprequest.Params, arg *struct {
+		httprequest.Route `httprequest:"GET /x"`
+		fieldErrorsRequest
+	}) {
+		c.Errorf("handler unexpectedly called")
+	})
+	router.Handle(h.Method, h.Path, h.Handle)
+	req, err := http.NewRequest("GET", "/x?limit=many&offset=none", nil)
+	c.Assert(err, gc.IsNil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, gc.Equals, http.StatusBadRequest)
+	c.Assert(strings.Count(rec.Body.String(), "cannot unmarshal into field"), gc.Equals, 2)
+	c.Assert(gotFields, gc.DeepEquals, []string{"Limit", "Offset"})
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldNamer may be implemented by a type used with Marshal or
+// Unmarshal, such as the argument type of a handler or the parameters
+// passed to Client.Call, to choose the names used for its fields
+// when the name is omitted from a field's tag. The
+// HTTPRequestFieldName method is called once for each field with the
+// Go name of the field, and returns the name to use in its place. It
+// is called on a pointer to a zero value of the type, so it should
+// not depend on the value.
+//
+// For example, the following type uses the form field names "user_id"
+// and "per_page":
+//
+//	type ListRequest struct {
+//		httprequest.Route `httprequest:"GET /items"`
+//		UserID  string `httprequest:",form"`
+//		PerPage int    `httprequest:",form"`
+//	}
+//
+//	func (*ListRequest) HTTPRequestFieldName(name string) string {
+//		return httprequest.SnakeCase(name)
+//	}
+//
+// Because the same names are used by both Marshal and Unmarshal, a
+// server and a client that share the type agree on them. The names
+// apply to path, form and header fields, including those within
+// embedded structs, but not to JSON body fields, whose names are
+// chosen by encoding/json.
+type FieldNamer interface {
+	HTTPRequestFieldName(fieldName string) string
+}
+
+var fieldNamerType = reflect.TypeOf((*FieldNamer)(nil)).Elem()
+
+// defaultFieldName returns a function that returns the name to use
+// for a field of the struct type pointed to by t whose tag does not
+// specify one.
+func defaultFieldName(t reflect.Type) func(string) string {
+	if !t.Implements(fieldNamerType) {
+		return func(name string) string {
+			return name
+		}
+	}
+	return reflect.New(t.Elem()).Interface().(FieldNamer).HTTPRequestFieldName
+}
+
+// SnakeCase returns the given Go identifier converted to
+// "snake_case", with words separated by underscores and in lower
+// case. An initialism is treated as a single word, so, for example,
+// "UserID" becomes "user_id" and "HTTPServer" becomes "http_server".
+// It is intended for use in an implementation of FieldNamer.
+func SnakeCase(name string) string {
+	buf := make([]byte, 0, len(name)+4)
+	var prev rune
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			next, _ := utf8.DecodeRuneInString(name[i+utf8.RuneLen(r):])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && unicode.IsLower(next) {
+				buf = append(buf, '_')
+			}
+		}
+		var enc [utf8.UTFMax]byte
+		n := utf8.EncodeRune(enc[:], unicode.ToLower(r))
+		buf = append(buf, enc[:n]...)
+		prev = r
+	}
+	return string(buf)
+}
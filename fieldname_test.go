@@ -0,0 +1,93 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type fieldNameSuite struct{}
+
+var _ = gc.Suite(&fieldNameSuite{})
+
+var snakeCaseTests = []struct {
+	name   string
+	expect string
+}{
+	{"", ""},
+	{"Limit", "limit"},
+	{"PerPage", "per_page"},
+	{"UserID", "user_id"},
+	{"ID", "id"},
+	{"HTTPServer", "http_server"},
+	{"Page2Size", "page2_size"},
+	{"already_snake", "already_snake"},
+	{"ÉtéTime", "été_time"},
+}
+
+func (*fieldNameSuite) TestSnakeCase(c *gc.C) {
+	for i, test := range snakeCaseTests {
+		c.Logf("test %d: %q", i, test.name)
+		c.Assert(httprequest.SnakeCase(test.name), gc.Equals, test.expect)
+	}
+}
+
+type snakeCaseRequest struct {
+	ItemID   string `httprequest:",path"`
+	PerPage  int    `httprequest:",form"`
+	SortBy   string `httprequest:"order,form"`
+	AuthUser string `httprequest:",header"`
+	snakeCaseEmbedded
+}
+
+type snakeCaseEmbedded struct {
+	ShowAll bool `httprequest:",form"`
+}
+
+func (*snakeCaseRequest) HTTPRequestFieldName(name string) string {
+	return httprequest.SnakeCase(name)
+}
+
+func (*fieldNameSuite) TestMarshalWithFieldNamer(c *gc.C) {
+	req, err := httprequest.Marshal("http://localhost/item/:item_id", "GET", &snakeCaseRequest{
+		ItemID:   "x",
+		PerPage:  10,
+		SortBy:   "name",
+		AuthUser: "bob",
+		snakeCaseEmbedded: snakeCaseEmbedded{
+			ShowAll: true,
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(req.URL.String(), gc.Equals, "http://localhost/item/x?order=name&per_page=10&show_all=true")
+	c.Assert(req.Header["Auth_user"], gc.DeepEquals, []string{"bob"})
+}
+
+func (*fieldNameSuite) TestUnmarshalWithFieldNamer(c *gc.C) {
+	req, err := http.NewRequest("GET", "http://localhost/item/x?order=name&per_page=10&show_all=true&PerPage=99", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("auth_user", "bob")
+	err = req.ParseForm()
+	c.Assert(err, gc.IsNil)
+	var got snakeCaseRequest
+	err = httprequest.Unmarshal(httprequest.Params{
+		Request: req,
+		PathVar: httprouter.Params{{Key: "item_id", Value: "x"}},
+	}, &got)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.DeepEquals, snakeCaseRequest{
+		ItemID:   "x",
+		PerPage:  10,
+		SortBy:   "name",
+		AuthUser: "bob",
+		snakeCaseEmbedded: snakeCaseEmbedded{
+			ShowAll: true,
+		},
+	})
+}
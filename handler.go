@@ -7,8 +7,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"reflect"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"golang.org/x/net/context"
@@ -26,7 +28,208 @@ type Server struct {
 	//
 	// If the returned errorBody implements HeaderSetter, then
 	// that method will be called to add custom headers to the request.
+	//
+	// The context passed to ErrorMapper is the context of the request
+	// that caused the error. The request itself can be obtained from
+	// it with RequestFromContext, so that, for example, the error
+	// message can be localized (see LocalizedErrorMapper).
+	//
+	// For handlers created with Server.Handlers, the context is the
+	// one returned by the Handlers function, including any values it
+	// added, for errors returned by that function or by a handler
+	// method; only errors from unmarshaling the request, which
+	// happens before the function is called, are passed the original
+	// request context. The status can therefore depend on the
+	// request, for example to return a 404 rather than a 403 status
+	// when the authenticated user is not allowed to know that a
+	// resource exists. As a context cannot be changed once created,
+	// a handler method that needs to influence the ErrorMapper can
+	// do so through a mutable value that the Handlers function has
+	// added to the context.
+	//
+	// An error mapper that does not need the context can be
+	// converted to the required form with ErrorMapperWithContext.
 	ErrorMapper func(ctxt context.Context, err error) (httpStatus int, errorBody interface{})
+
+	// MaxFormValues holds the maximum total number of form values,
+	// including URL query parameters, that will be accepted in a
+	// request handled by a function passed to Handle. If a request
+	// has more, the handler will return an error with an
+	// ErrUnmarshal cause. If it is zero, there is no limit.
+	//
+	// The URL query is checked before it is parsed. A form in
+	// the request body is limited in size (to 10MB by
+	// http.Request.ParseForm, or to MaxFormBodySize), and its
+	// values are counted after parsing.
+	MaxFormValues int
+
+	// MaxFormBodySize holds the maximum size in bytes of a
+	// URL-encoded form in the body of a request handled by a
+	// function passed to Handle. If the form is larger, the
+	// handler will return an error with an ErrUnmarshal cause
+	// (see also ErrFormParse) without reading the rest of it.
+	// If it is zero, the 10MB limit imposed by
+	// http.Request.ParseForm applies.
+	MaxFormBodySize int64
+
+	// DecompressBody specifies that the body of a request handled by
+	// a function passed to Handle should be decompressed before it is
+	// unmarshaled if its Content-Encoding header is "gzip" or
+	// "deflate". A request with any other Content-Encoding will cause
+	// the handler to return an error with an ErrUnmarshal cause.
+	DecompressBody bool
+
+	// MaxDecompressedBodySize holds the maximum size in bytes of a
+	// decompressed request body, to guard against decompression bombs.
+	// If the body is larger, reading it fails and the handler returns
+	// an error. If it is zero, DefaultMaxDecompressedBodySize is used.
+	// It has no effect unless DecompressBody is true.
+	MaxDecompressedBodySize int64
+
+	// NoContentForEmptyResponse specifies that when a function
+	// passed to Handle or Handlers that does not return a result
+	// value returns without error and without having written
+	// anything to its ResponseWriter, a 204 (No Content) status
+	// is sent instead of the default 200 (OK).
+	NoContentForEmptyResponse bool
+
+	// RejectUnexpectedBody specifies that a handler created by
+	// Handle or Handlers whose argument type has no body field
+	// should return an error with an ErrUnmarshal cause (see also
+	// ErrBodyDecode) if the request has a non-empty body, which
+	// would otherwise be ignored. A form body is allowed if
+	// the argument type has form fields.
+	RejectUnexpectedBody bool
+
+	// CollectFieldErrors specifies that handlers created by Handle
+	// should try to unmarshal all the fields of their argument
+	// rather than failing on the first field that cannot be
+	// unmarshaled, so that the error passed to ErrorMapper can
+	// describe all of them. See UnmarshalAll and
+	// UnmarshalFieldErrors.
+	CollectFieldErrors bool
+
+	// Debug specifies that the server is running in a development
+	// environment. When it is set, the context passed to ErrorMapper
+	// is marked so that IsDebug returns true, allowing the mapper
+	// to include extra debugging information in the error response.
+	// See RemoteErrorMapper.
+	Debug bool
+
+	// HandleHEAD specifies that Handlers, HandlersWithPrefix and
+	// AllHandlers should also return a HEAD handler for each GET handler whose
+	// path does not already have one. The HEAD handler runs the GET
+	// handler but discards the response body, setting the
+	// Content-Length header to the length of the discarded body
+	// unless the handler set it or flushed the response.
+	HandleHEAD bool
+
+	// HandleOPTIONS specifies that Handlers, HandlersWithPrefix and
+	// AllHandlers should also return an OPTIONS handler for each
+	// path. The OPTIONS handler responds with a 204 (No Content) status and an Allow header
+	// holding the methods handled for the path. OPTIONS handlers
+	// are also returned when CORS is non-nil. No OPTIONS handler
+	// is added for a path that already has one.
+	HandleOPTIONS bool
+
+	// CORS, if non-nil, holds the Cross-Origin Resource Sharing
+	// policy applied to the handlers returned by Handlers,
+	// HandlersWithPrefix and AllHandlers. The OPTIONS handlers
+	// respond to CORS preflight requests as specified by the
+	// policy, and the other handlers add the
+	// Access-Control-Allow-Origin header and related headers
+	// to their responses to requests from allowed origins.
+	CORS *CORSPolicy
+
+	// IdempotencyStore, if non-nil, is used by the POST and PATCH
+	// handlers returned by Handlers, HandlersWithPrefix and
+	// AllHandlers to make requests with an Idempotency-Key header
+	// safe to retry. The response to such a request is stored
+	// under its key, scoped to the request method and path, and
+	// when a request with the same key is received again, the
+	// stored response is written, with an Idempotent-Replayed
+	// header, instead of calling the handler. Responses with a 5xx
+	// status are not stored, so that such requests can be retried.
+	//
+	// Headers that depend on the request rather than the
+	// response, such as CORS and request ID headers, are not
+	// stored but are set afresh for each request.
+	//
+	// While a request is being handled, its key is reserved in the
+	// store, and a request with the same key receives a 409
+	// (Conflict) response, so that concurrent retries cannot both
+	// call the handler. A hash of the request body is stored with
+	// the key, and a request that uses the key with a different
+	// body receives a 422 (Unprocessable Entity) response rather
+	// than the stored response.
+	//
+	// Unless IdempotencyScope is set, requests from different
+	// callers that use the same key for the same method and path
+	// share a stored response, so one caller may be sent the
+	// response to another's request. Servers that handle requests
+	// from more than one caller should set IdempotencyScope.
+	IdempotencyStore IdempotencyStore
+
+	// IdempotencyScope, if non-nil, is called to obtain a string
+	// that identifies the caller making a request, such as the
+	// authenticated user name. It is included in the key used to
+	// store the response in IdempotencyStore, so that only
+	// requests from the same caller can be replayed from it.
+	IdempotencyScope func(req *http.Request) string
+
+	// IdempotencyErrorLogger, if non-nil, is called with the error
+	// when a response cannot be saved in IdempotencyStore, or the
+	// reservation of a key cannot be removed from it. As the
+	// response has already been written by then, the error cannot
+	// be returned to the client.
+	IdempotencyErrorLogger func(ctx context.Context, err error)
+
+	// RequestIDHeader, if non-empty, holds the name of a request
+	// header, such as DefaultRequestIDHeader, that identifies
+	// the request so that it can be traced from client to server.
+	// The handlers returned by Handle, Handlers, HandlersWithPrefix,
+	// AllHandlers, HandleJSON and HandleErrors copy the header to
+	// the response. If a request
+	// does not have the header, it is set to a newly generated
+	// UUID before the handler is called, so that the handler
+	// can obtain it with a header field or from Params.Request.
+	// A client can read the header from the response returned
+	// by Client.DoResponse.
+	RequestIDHeader string
+
+	// RecoverPanics specifies that a panic in a handler created by
+	// Handle, Handlers, HandleJSON or HandleErrors should be
+	// recovered rather than propagated. The panic is passed to
+	// PanicLogger and, if nothing has yet been written to the
+	// response, an error response produced by PanicMapper is
+	// written. If the response has already been started, the
+	// handler panics with http.ErrAbortHandler instead, so that
+	// the client sees the response fail rather than receiving a
+	// truncated one. A panic with http.ErrAbortHandler itself is
+	// never recovered.
+	RecoverPanics bool
+
+	// PanicMapper holds a function that converts the value passed
+	// to panic into an HTTP status and a body to be written as JSON.
+	// If it is nil, DefaultPanicMapper is used. It is only used if
+	// RecoverPanics is true.
+	PanicMapper func(ctx context.Context, v interface{}) (httpStatus int, errorBody interface{})
+
+	// PanicLogger, if non-nil, is called with the value passed to
+	// panic and the stack trace of the panicking goroutine when
+	// a panic is recovered. It is only used if RecoverPanics is
+	// true.
+	PanicLogger func(ctx context.Context, v interface{}, stack []byte)
+}
+
+// ErrorMapperWithContext returns a function suitable for use as
+// Server.ErrorMapper that ignores its context argument and calls f.
+// It can be used to adapt an error mapper that does not need
+// the request context.
+func ErrorMapperWithContext(f func(err error) (httpStatus int, errorBody interface{})) func(ctx context.Context, err error) (httpStatus int, errorBody interface{}) {
+	return func(_ context.Context, err error) (int, interface{}) {
+		return f(err)
+	}
 }
 
 // Handler defines a HTTP handler that will handle the
@@ -37,6 +240,20 @@ type Handler struct {
 	Handle httprouter.Handle
 }
 
+// With returns a copy of h with its Handle function wrapped by the
+// given middleware functions. The first middleware function is the
+// outermost, so it sees the request first. For example:
+//
+//	h.With(logRequests, checkAuth)
+//
+// returns a handler that calls logRequests(checkAuth(h.Handle)).
+func (h Handler) With(middleware ...func(httprouter.Handle) httprouter.Handle) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h.Handle = middleware[i](h.Handle)
+	}
+	return h
+}
+
 // handlerFunc represents a function that can handle an HTTP request.
 type handlerFunc struct {
 	// unmarshal unmarshals the request parameters into
@@ -47,9 +264,9 @@ type handlerFunc struct {
 	// given argument value (as returned by unmarshal).
 	call func(fv, argv reflect.Value, p Params)
 
-	// method holds the HTTP method the function will be
+	// methods holds the HTTP methods the function will be
 	// registered for.
-	method string
+	methods []string
 
 	// pathPattern holds the path pattern the function will
 	// be registered for.
@@ -74,7 +291,7 @@ func AddHandlers(r *httprouter.Router, hs []Handler) {
 }
 
 // Handle converts a function into a Handler. The argument f
-// must be a function of one of the following six forms, where ArgT
+// must be a function of one of the following nine forms, where ArgT
 // must be a struct type acceptable to Unmarshal and ResultT is a type
 // that can be marshaled as JSON:
 //
@@ -82,10 +299,18 @@ func AddHandlers(r *httprouter.Router, hs []Handler) {
 //	func(p Params, arg *ArgT) error
 //	func(p Params, arg *ArgT) (ResultT, error)
 //
+//	func(ctx context.Context, arg *ArgT)
+//	func(ctx context.Context, arg *ArgT) error
+//	func(ctx context.Context, arg *ArgT) (ResultT, error)
+//
 //	func(arg *ArgT)
 //	func(arg *ArgT) error
 //	func(arg *ArgT) (ResultT, error)
 //
+// In the forms that take a context.Context, the context
+// is the same as the Context field of the Params that
+// would otherwise be passed.
+//
 // When processing a call to the returned handler, the provided
 // parameters are unmarshaled into a new ArgT value using Unmarshal,
 // then f is called with this value. If the unmarshaling fails, f will
@@ -99,6 +324,13 @@ func AddHandlers(r *httprouter.Router, hs []Handler) {
 // to use for the request. If this is given, the returned handler will
 // hold that method and path, otherwise they will be empty.
 //
+// The method may also be a comma-separated list of methods, for
+// example "GET,HEAD", in which case Handlers returns a handler for
+// each of them (HEAD is only allowed in such a list, and only
+// alongside GET). Handle cannot return more than one handler, so it
+// panics if given such a function. A client making a request with the
+// type uses the first method in the list.
+//
 // If an error is returned from f, it is passed through the error mapper
 // before writing as a JSON response.
 //
@@ -116,10 +348,17 @@ func (srv *Server) Handle(f interface{}) Handler {
 	if err != nil {
 		panic(errgo.Notef(err, "bad handler function"))
 	}
+	if len(hf.methods) > 1 {
+		panic(errgo.Newf("bad handler function: route specifies more than one method; use Handlers"))
+	}
+	var method string
+	if len(hf.methods) > 0 {
+		method = hf.methods[0]
+	}
 	return Handler{
-		Method: hf.method,
+		Method: method,
 		Path:   hf.pathPattern,
-		Handle: func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		Handle: srv.requestIDHandler(srv.recoverPanics(func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
 			ctx, cancel := contextFromRequest(req)
 			defer cancel()
 			p1 := Params{
@@ -135,7 +374,7 @@ func (srv *Server) Handle(f interface{}) Handler {
 				return
 			}
 			hf.call(fv, argv, p1)
-		},
+		})),
 	}
 }
 
@@ -147,13 +386,15 @@ func (srv *Server) Handle(f interface{}) Handler {
 // 	func(p httprequest.Params, handlerArg I) (T, context.Context, error)
 //
 // for some type T and some interface type I. Each exported method defined on T defines a handler,
-// and should be in one of the forms accepted by ErrorMapper.Handle
+// and should be in one of the forms accepted by Server.Handle
 // with the additional constraint that the argument to each
 // of the handlers must be compatible with the type I when the
 // second form is used above.
 //
 // The returned context will be used as the value of Params.Context
-// when Params is passed to any method. It will also be used
+// when Params is passed to any method, so values added to it, such as
+// the identity of an authenticated user, can be retrieved by the
+// methods with Params.Value. It will also be used
 // when writing an error if the function returns an error.
 // Note that it is OK to use both the standard library context.Context
 // or golang.org/x/net/context.Context in the context return value.
@@ -166,33 +407,72 @@ func (srv *Server) Handle(f interface{}) Handler {
 // If specified, the handlerArg parameter to f will hold the ArgT argument that
 // will be passed to the handler method.
 //
+// If T is not a pointer or interface type, methods defined
+// on both T and *T are used, and each method is called on
+// a pointer to a copy of the returned value.
+//
 // If T implements io.Closer, its Close method will be called
 // after the request is completed.
 func (srv Server) Handlers(f interface{}) []Handler {
+	return srv.addImplicitHandlers(srv.handlers("", f))
+}
+
+// HandlersWithPrefix is like Handlers except that the given prefix
+// is prepended to the path of each of the returned handlers, so
+// that a group of handlers may be mounted under a path such as
+// "/v1" without changing their Route tags. The prefix must
+// start with a slash (/); any trailing slash is ignored.
+//
+// The prefix may contain path parameters, such as "/v1/:org", which
+// may be unmarshaled into the handlers' argument types in the same
+// way as any other path parameter. The prefix is also included in
+// Params.PathPattern.
+//
+// Note that a Client calling the handlers should have the prefix
+// included in its BaseURL (or in the URL passed to CallURL), because
+// the paths in the Route tags do not include it.
+//
+// HandlersWithPrefix will panic in the same circumstances as Handlers,
+// or if the prefix is invalid.
+func (srv Server) HandlersWithPrefix(prefix string, f interface{}) []Handler {
+	if !strings.HasPrefix(prefix, "/") {
+		panic(errgo.Newf("path prefix %q does not start with a slash", prefix))
+	}
+	return srv.addImplicitHandlers(srv.handlers(strings.TrimSuffix(prefix, "/"), f))
+}
+
+func (srv Server) handlers(prefix string, f interface{}) []Handler {
 	rootv := reflect.ValueOf(f)
 	wt, argInterfacet, err := checkHandlersWrapperFunc(rootv)
 	if err != nil {
 		panic(errgo.Notef(err, "bad handler function"))
 	}
-	hasClose := wt.Implements(ioCloserType)
-	hs := make([]Handler, 0, wt.NumMethod())
-	for i := 0; i < wt.NumMethod(); i++ {
+	// Use the method set of the pointer type when
+	// we can, so that methods with both value and
+	// pointer receivers are found.
+	mt := wt
+	if wt.Kind() != reflect.Ptr && wt.Kind() != reflect.Interface {
+		mt = reflect.PtrTo(wt)
+	}
+	hasClose := mt.Implements(ioCloserType)
+	hs := make([]Handler, 0, mt.NumMethod())
+	for i := 0; i < mt.NumMethod(); i++ {
 		i := i
-		m := wt.Method(i)
+		m := mt.Method(i)
 		if m.PkgPath != "" {
 			continue
 		}
 		if m.Name == "Close" {
 			if !hasClose {
-				panic(errgo.Newf("bad type for Close method (got %v want func(%v) error", m.Type, wt))
+				panic(errgo.Newf("bad type for Close method (got %v want func(%v) error", m.Type, mt))
 			}
 			continue
 		}
-		h, err := srv.methodHandler(m, rootv, argInterfacet, hasClose)
+		mhs, err := srv.methodHandlers(m, rootv, argInterfacet, hasClose, prefix)
 		if err != nil {
 			panic(err)
 		}
-		hs = append(hs, h)
+		hs = append(hs, mhs...)
 	}
 	if len(hs) == 0 {
 		panic(errgo.Newf("no exported methods defined on %s", wt))
@@ -200,7 +480,111 @@ func (srv Server) Handlers(f interface{}) []Handler {
 	return hs
 }
 
-func (srv *Server) methodHandler(m reflect.Method, rootv reflect.Value, argInterfacet reflect.Type, hasClose bool) (Handler, error) {
+// addImplicitHandlers returns hs with the HEAD and OPTIONS handlers
+// implied by srv.HandleHEAD, srv.HandleOPTIONS and srv.CORS added,
+// and with each of the other handlers applying srv.CORS if it is set.
+// If srv.RequestIDHeader is set, all the handlers, including the
+// implied ones, apply it. It is applied here rather than by
+// srv.handlers so that it wraps the CORS and idempotency handlers,
+// which then see the header already set in the response.
+func (srv *Server) addImplicitHandlers(hs []Handler) []Handler {
+	if srv.HandleHEAD {
+		hs = addHEADHandlers(hs)
+	}
+	if srv.IdempotencyStore != nil {
+		for i, h := range hs {
+			if h.Method == "POST" || h.Method == "PATCH" {
+				hs[i].Handle = srv.idempotencyHandler(srv.IdempotencyStore, h.Handle)
+			}
+		}
+	}
+	if srv.CORS != nil {
+		for i := range hs {
+			hs[i].Handle = corsHandler(srv.CORS, hs[i].Handle)
+		}
+	}
+	if srv.HandleOPTIONS || srv.CORS != nil {
+		hs = addOPTIONSHandlers(hs, srv.CORS)
+	}
+	for i := range hs {
+		hs[i].Handle = srv.requestIDHandler(hs[i].Handle)
+	}
+	return hs
+}
+
+// AllHandlers is like Handlers except that it accepts any number of
+// functions, each of which must be acceptable to Handlers, and returns
+// the handlers for all of them combined.
+//
+// AllHandlers will panic if any of the functions would cause Handlers
+// to panic, or if more than one handler is defined for the same HTTP
+// method and path.
+func (srv *Server) AllHandlers(fs ...interface{}) []Handler {
+	type route struct {
+		method, path string
+	}
+	// defined maps from each route to the index of
+	// the function that defined it.
+	defined := make(map[route]int)
+	var hs []Handler
+	for i, f := range fs {
+		for _, h := range srv.handlers("", f) {
+			r := route{h.Method, h.Path}
+			if j, ok := defined[r]; ok {
+				panic(errgo.Newf("handler function %d defines %s %s which is already defined by handler function %d", i, h.Method, h.Path, j))
+			}
+			defined[r] = i
+			hs = append(hs, h)
+		}
+	}
+	return srv.addImplicitHandlers(hs)
+}
+
+// ServeMuxHandlers is like Handlers except that the handlers are
+// returned in a form suitable for registering with an http.ServeMux,
+// for use when httprouter is not otherwise in use. The returned map
+// holds an entry for each ServeMux pattern; for example:
+//
+//	for pattern, h := range srv.ServeMuxHandlers(f) {
+//		mux.Handle(pattern, h)
+//	}
+//
+// A route without path parameters is registered under its exact path.
+// A route with path parameters is registered under the subtree pattern
+// formed by its path up to the first parameter, so a route with the
+// path "/users/:name/details" is registered as "/users/". Note that
+// this means that the returned handlers will respond with a 404 (Not
+// Found) status to other paths in that subtree, and that patterns
+// registered separately with the ServeMux that fall within that
+// subtree will take precedence over it. Routing within the returned
+// handlers, including the extraction of path parameters, is done as
+// for Handlers.
+//
+// ServeMuxHandlers will panic in the same circumstances as Handlers.
+func (srv Server) ServeMuxHandlers(f interface{}) map[string]http.Handler {
+	hs := srv.Handlers(f)
+	router := httprouter.New()
+	AddHandlers(router, hs)
+	m := make(map[string]http.Handler)
+	for _, h := range hs {
+		m[serveMuxPattern(h.Path)] = router
+	}
+	return m
+}
+
+// serveMuxPattern returns the http.ServeMux pattern that matches all
+// the paths matched by the given httprouter path pattern.
+func serveMuxPattern(path string) string {
+	i := strings.IndexAny(path, ":*")
+	if i == -1 {
+		return path
+	}
+	return path[:strings.LastIndex(path[:i], "/")+1]
+}
+
+// methodHandlers returns the handlers for the given method, one for
+// each HTTP method in its route.
+func (srv *Server) methodHandlers(m reflect.Method, rootv reflect.Value, argInterfacet reflect.Type, hasClose bool, prefix string) ([]Handler, error) {
 	// The type in the Method struct includes the receiver type,
 	// which we don't want to look at (and we won't see when
 	// we get the method from the actual value at dispatch time),
@@ -208,11 +592,12 @@ func (srv *Server) methodHandler(m reflect.Method, rootv reflect.Value, argInter
 	mt := withoutReceiver(m.Type)
 	hf, err := srv.handlerFunc(mt, argInterfacet)
 	if err != nil {
-		return Handler{}, errgo.Notef(err, "bad type for method %s", m.Name)
+		return nil, errgo.Notef(err, "bad type for method %s", m.Name)
 	}
-	if hf.method == "" || hf.pathPattern == "" {
-		return Handler{}, errgo.Notef(err, "method %s does not specify route method and path", m.Name)
+	if len(hf.methods) == 0 || hf.pathPattern == "" {
+		return nil, errgo.Notef(err, "method %s does not specify route method and path", m.Name)
 	}
+	pathPattern := prefix + hf.pathPattern
 	handler := func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
 		ctx, cancel := contextFromRequest(req)
 		defer cancel()
@@ -220,7 +605,7 @@ func (srv *Server) methodHandler(m reflect.Method, rootv reflect.Value, argInter
 			Response:    w,
 			Request:     req,
 			PathVar:     p,
-			PathPattern: hf.pathPattern,
+			PathPattern: pathPattern,
 			Context:     ctx,
 		}
 		inv, err := hf.unmarshal(p1)
@@ -254,6 +639,13 @@ func (srv *Server) methodHandler(m reflect.Method, rootv reflect.Value, argInter
 			srv.WriteError(ctx, w, errv.Interface().(error))
 			return
 		}
+		if k := tv.Kind(); k != reflect.Ptr && k != reflect.Interface {
+			// Make the value addressable so that methods
+			// with pointer receivers can be called on it.
+			pv := reflect.New(tv.Type())
+			pv.Elem().Set(tv)
+			tv = pv
+		}
 		if hasClose {
 			defer tv.Interface().(io.Closer).Close()
 		}
@@ -261,15 +653,20 @@ func (srv *Server) methodHandler(m reflect.Method, rootv reflect.Value, argInter
 			Response:    w,
 			Request:     req,
 			PathVar:     p,
-			PathPattern: hf.pathPattern,
+			PathPattern: pathPattern,
 			Context:     ctx,
 		})
 	}
-	return Handler{
-		Method: hf.method,
-		Path:   hf.pathPattern,
-		Handle: handler,
-	}, nil
+	handle := srv.recoverPanics(handler)
+	hs := make([]Handler, len(hf.methods))
+	for i, method := range hf.methods {
+		hs[i] = Handler{
+			Method: method,
+			Path:   pathPattern,
+			Handle: handle,
+		}
+	}
+	return hs, nil
 }
 
 func checkHandlersWrapperFunc(fv reflect.Value) (returnt, argInterfacet reflect.Type, err error) {
@@ -315,12 +712,15 @@ func checkHandleType(t, argInterfacet reflect.Type) (*requestType, error) {
 		return nil, errgo.Newf("has %d result parameters, need 0, 1 or 2", t.NumOut())
 	}
 	if t.NumIn() == 2 {
-		if t.In(0) != paramsType {
-			return nil, errgo.Newf("first argument is %v, need httprequest.Params", t.In(0))
+		if t.In(0) != paramsType && t.In(0) != contextType {
+			return nil, errgo.Newf("first argument is %v, need httprequest.Params or context.Context", t.In(0))
 		}
 	} else {
-		if t.In(0) == paramsType {
+		switch t.In(0) {
+		case paramsType:
 			return nil, errgo.Newf("no argument parameter after Params argument")
+		case contextType:
+			return nil, errgo.Newf("no argument parameter after context.Context argument")
 		}
 	}
 	argt := t.In(t.NumIn() - 1)
@@ -350,40 +750,127 @@ func (srv *Server) handlerFunc(ft, argInterfacet reflect.Type) (handlerFunc, err
 		return handlerFunc{}, errgo.Mask(err)
 	}
 	return handlerFunc{
-		unmarshal:   handlerUnmarshaler(ft, rt),
+		unmarshal:   srv.handlerUnmarshaler(ft, rt),
 		call:        srv.handlerCaller(ft, rt),
-		method:      rt.method,
+		methods:     rt.methods,
 		pathPattern: rt.path,
 	}, nil
 }
 
-func handlerUnmarshaler(
+func (srv *Server) handlerUnmarshaler(
 	ft reflect.Type,
 	rt *requestType,
 ) func(p Params) (reflect.Value, error) {
 	argStructType := ft.In(ft.NumIn() - 1).Elem()
+	maxFormValues := srv.MaxFormValues
+	maxFormBodySize := srv.MaxFormBodySize
+	decompress := srv.DecompressBody
+	maxBodySize := srv.MaxDecompressedBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxDecompressedBodySize
+	}
+	rejectBody := srv.RejectUnexpectedBody && !rt.hasBody
+	unmarshalArg := unmarshal
+	if srv.CollectFieldErrors {
+		unmarshalArg = unmarshalAll
+	}
 	return func(p Params) (reflect.Value, error) {
-		if err := p.Request.ParseForm(); err != nil {
-			return reflect.Value{}, errgo.WithCausef(err, ErrUnmarshal, "cannot parse HTTP request form")
+		if decompress {
+			if err := decompressBody(p.Request, maxBodySize); err != nil {
+				return reflect.Value{}, errgo.Mask(err, errgo.Is(ErrUnmarshal))
+			}
+		}
+		if rejectBody && !(rt.hasForm && isFormBody(p.Request)) && hasBody(p.Request) {
+			return reflect.Value{}, unmarshalErrorf(nil, ErrBodyDecode, "unexpected request body")
+		}
+		if maxFormBodySize > 0 && p.Request.Body != nil && isFormBody(p.Request) {
+			p.Request.Body = http.MaxBytesReader(p.Response, p.Request.Body, maxFormBodySize)
+		}
+		if err := parseForm(p.Request, maxFormValues); err != nil {
+			return reflect.Value{}, errgo.Mask(err, errgo.Is(ErrUnmarshal))
 		}
 		argv := reflect.New(argStructType)
-		if err := unmarshal(p, argv, rt); err != nil {
+		if err := unmarshalArg(p, argv, rt); err != nil {
 			return reflect.Value{}, errgo.NoteMask(err, "cannot unmarshal parameters", errgo.Is(ErrUnmarshal))
 		}
 		return argv, nil
 	}
 }
 
+// parseForm parses the form in the given request, returning an error
+// with an ErrUnmarshal cause (see also ErrFormParse) if it is invalid
+// or holds more than maxValues values.
+// There is no limit if maxValues is zero.
+func parseForm(req *http.Request, maxValues int) error {
+	if maxValues > 0 && req.URL != nil {
+		// Check the raw query before parsing it so that we don't
+		// allocate space for a huge number of values.
+		if n := strings.Count(req.URL.RawQuery, "&") + 1; n > maxValues {
+			return unmarshalErrorf(nil, ErrFormParse, "too many form values in URL query (maximum %d)", maxValues)
+		}
+	}
+	if err := req.ParseForm(); err != nil {
+		return unmarshalErrorf(err, ErrFormParse, "cannot parse HTTP request form")
+	}
+	if maxValues <= 0 {
+		return nil
+	}
+	n := 0
+	for _, vs := range req.Form {
+		n += len(vs)
+	}
+	if n > maxValues {
+		return unmarshalErrorf(nil, ErrFormParse, "too many form values (maximum %d)", maxValues)
+	}
+	return nil
+}
+
+// isFormBody reports whether the body of the given
+// request holds a form that will be parsed by
+// http.Request.ParseForm or ParseMultipartForm.
+func isFormBody(req *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
+// hasBody reports whether the given request has a non-empty body. If
+// the length of the body is unknown, this may involve reading from it,
+// but only when the body is non-empty.
+func hasBody(req *http.Request) bool {
+	if req.Body == nil || req.ContentLength == 0 {
+		return false
+	}
+	if req.ContentLength > 0 {
+		return true
+	}
+	n, _ := req.Body.Read(make([]byte, 1))
+	return n > 0
+}
+
 func (srv *Server) handlerCaller(
 	ft reflect.Type,
 	rt *requestType,
 ) func(fv, argv reflect.Value, p Params) {
 	returnJSON := ft.NumOut() > 1
 	needsParams := ft.In(0) == paramsType
+	needsContext := ft.In(0) == contextType
 	respond := srv.handlerResponder(ft)
+	noContent := srv.NoContentForEmptyResponse && !returnJSON
 	return func(fv, argv reflect.Value, p Params) {
+		var w *responseWriter
+		if noContent {
+			w = &responseWriter{
+				ResponseWriter: p.Response,
+			}
+			p.Response = w
+		}
 		var rv []reflect.Value
-		if needsParams {
+		if needsContext {
+			rv = fv.Call([]reflect.Value{
+				reflect.ValueOf(&p.Context).Elem(),
+				argv,
+			})
+		} else if needsParams {
 			p := p
 			if returnJSON {
 				p.Response = headerOnlyResponseWriter{p.Response.Header()}
@@ -398,6 +885,9 @@ func (srv *Server) handlerCaller(
 			})
 		}
 		respond(p, rv)
+		if w != nil && !w.headerWritten {
+			w.WriteHeader(http.StatusNoContent)
+		}
 	}
 }
 
@@ -458,7 +948,7 @@ type ErrorHandler func(Params) error
 // Note that the Params argument passed to handle will not
 // have its PathPattern set as that information is not available.
 func (srv *Server) HandleJSON(handle JSONHandler) httprouter.Handle {
-	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	return srv.requestIDHandler(srv.recoverPanics(func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
 		ctx, cancel := contextFromRequest(req)
 		defer cancel()
 		val, err := handle(Params{
@@ -473,7 +963,7 @@ func (srv *Server) HandleJSON(handle JSONHandler) httprouter.Handle {
 			}
 		}
 		srv.WriteError(ctx, w, err)
-	}
+	}))
 }
 
 // HandleErrors returns a handler that passes any non-nil error returned
@@ -482,7 +972,7 @@ func (srv *Server) HandleJSON(handle JSONHandler) httprouter.Handle {
 // Note that the Params argument passed to handle will not
 // have its PathPattern set as that information is not available.
 func (srv *Server) HandleErrors(handle ErrorHandler) httprouter.Handle {
-	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	return srv.requestIDHandler(srv.recoverPanics(func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
 		w1 := responseWriter{
 			ResponseWriter: w,
 		}
@@ -506,7 +996,7 @@ func (srv *Server) HandleErrors(handle ErrorHandler) httprouter.Handle {
 			}
 			srv.WriteError(ctx, w, err)
 		}
-	}
+	}))
 }
 
 // WriteError writes an error to a ResponseWriter
@@ -516,9 +1006,45 @@ func (srv *Server) HandleErrors(handle ErrorHandler) httprouter.Handle {
 // the ErrorMapper so it is possible to add custom
 // headers to the HTTP error response by implementing
 // HeaderSetter.
+//
+// If the cause of err is ErrNotModified, a 304 (Not Modified)
+// status is written with no body instead.
 func (srv *Server) WriteError(ctx context.Context, w http.ResponseWriter, err error) {
-	status, resp := srv.ErrorMapper(ctx, err)
-	err1 := WriteJSON(w, status, resp)
+	if errgo.Cause(err) == ErrNotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	srv.writeError(ctx, w, 0, err)
+}
+
+// WriteErrorStatus is like WriteError except that the error is
+// always written with the given HTTP status code, regardless of the
+// status returned by the ErrorMapper. The body is still the one
+// returned by the ErrorMapper. This can be useful, for example, to
+// send a 503 (Service Unavailable) response while shutting down.
+func (srv *Server) WriteErrorStatus(ctx context.Context, w http.ResponseWriter, status int, err error) {
+	srv.writeError(ctx, w, status, err)
+}
+
+// writeError writes the given error to w. If status is non-zero,
+// it is used instead of the status returned by the ErrorMapper.
+func (srv *Server) writeError(ctx context.Context, w http.ResponseWriter, status int, err error) {
+	withStatus := func(mappedStatus int) int {
+		if status != 0 {
+			return status
+		}
+		return mappedStatus
+	}
+	if srv.Debug {
+		if ctx == nil {
+			// WriteError has always allowed a nil context,
+			// but context.WithValue does not.
+			ctx = context.Background()
+		}
+		ctx = context.WithValue(ctx, debugKey{}, true)
+	}
+	status1, resp := srv.ErrorMapper(ctx, err)
+	err1 := WriteJSON(w, withStatus(status1), resp)
 	if err1 == nil {
 		return
 	}
@@ -526,13 +1052,13 @@ func (srv *Server) WriteError(ctx context.Context, w http.ResponseWriter, err er
 
 	// JSON-marshaling the original error failed, so try to send that
 	// error instead; if that fails, give up and go home.
-	status1, resp1 := srv.ErrorMapper(ctx, errgo.Notef(err1, "cannot marshal error response %q", err))
-	err2 := WriteJSON(w, status1, resp1)
+	status2, resp2 := srv.ErrorMapper(ctx, errgo.Notef(err1, "cannot marshal error response %q", err))
+	err2 := WriteJSON(w, withStatus(status2), resp2)
 	if err2 == nil {
 		return
 	}
 
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(withStatus(http.StatusInternalServerError))
 	w.Write([]byte(fmt.Sprintf("really cannot marshal error response %q: %v", err, err1)))
 }
 
@@ -544,7 +1070,21 @@ func (srv *Server) WriteError(ctx context.Context, w http.ResponseWriter, err er
 // HTTP response. It is called after the Content-Type header
 // has been added, so can be used to override the content type
 // if required.
+//
+// If val is a RawResponse or a non-nil *RawResponse, its body is
+// written verbatim with its content type instead of being marshaled
+// as JSON.
 func WriteJSON(w http.ResponseWriter, code int, val interface{}) error {
+	switch r := val.(type) {
+	case RawResponse:
+		r.write(w, code)
+		return nil
+	case *RawResponse:
+		if r != nil {
+			r.write(w, code)
+			return nil
+		}
+	}
 	// TODO consider marshalling directly to w using json.NewEncoder.
 	// pro: this will not require a full buffer allocation.
 	// con: if there's an error after the first write, it will be lost.
@@ -593,6 +1133,39 @@ func (h CustomHeader) SetHeader(header http.Header) {
 	h.SetHeaderFunc(header)
 }
 
+// RawResponse holds a response body that is written as is by
+// WriteJSON rather than being marshaled as JSON. It may be returned
+// as the result of a handler (see Server.Handle and Server.HandleJSON)
+// that needs to return a non-JSON payload such as an image or CSV
+// data. For example:
+//
+//	func (h *handler) Export(*exportRequest) (httprequest.RawResponse, error) {
+//		return httprequest.RawResponse{
+//			ContentType: "text/csv",
+//			Body:        h.csvData(),
+//		}, nil
+//	}
+type RawResponse struct {
+	// ContentType holds the value of the Content-Type header of the
+	// response. If it is empty, application/octet-stream is used.
+	ContentType string
+
+	// Body holds the body of the response.
+	Body []byte
+}
+
+// write writes r to w with the given status code.
+func (r *RawResponse) write(w http.ResponseWriter, code int) {
+	contentType := r.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(r.Body)))
+	w.WriteHeader(code)
+	w.Write(r.Body)
+}
+
 // Ensure statically that responseWriter does implement http.Flusher.
 var _ http.Flusher = (*responseWriter)(nil)
 
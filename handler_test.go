@@ -4,11 +4,18 @@
 package httprequest_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sort"
+	"strings"
 
 	jc "github.com/juju/testing/checkers"
 	"github.com/juju/testing/httptesting"
@@ -18,6 +25,7 @@ import (
 	"gopkg.in/errgo.v1"
 
 	"github.com/juju/httprequest"
+	"github.com/juju/httprequest/httprequesttest"
 )
 
 type handlerSuite struct{}
@@ -276,6 +284,55 @@ var handleTests = []struct {
 		Code:    "unauthorized",
 	},
 	expectStatus: http.StatusUnauthorized,
+}, {
+	about: "function with no Params with struct value return",
+	f: func(c *gc.C) interface{} {
+		type testStruct struct {
+			A int `httprequest:"a,form"`
+		}
+		return func(s *testStruct) (*HeaderNumber, error) {
+			return &HeaderNumber{N: s.A * 2}, nil
+		}
+	},
+	req: &http.Request{
+		Form: url.Values{
+			"a": {"21"},
+		},
+	},
+	pathVar:    httprouter.Params{},
+	expectBody: HeaderNumber{N: 42},
+}, {
+	about: "function with no Params with value return that can't be marshaled as JSON",
+	f: func(c *gc.C) interface{} {
+		return func(s *struct{}) (chan int, error) {
+			return make(chan int), nil
+		}
+	},
+	req:     &http.Request{},
+	pathVar: httprouter.Params{},
+	expectBody: httprequest.RemoteError{
+		Message: "json: unsupported type: chan int",
+	},
+	expectStatus: http.StatusInternalServerError,
+}, {
+	about: "function with no Params with value return and route",
+	f: func(c *gc.C) interface{} {
+		type testStruct struct {
+			httprequest.Route `httprequest:"GET /foo/:bar"`
+			A                 string `httprequest:"bar,path"`
+		}
+		return func(s *testStruct) (string, error) {
+			return "got " + s.A, nil
+		}
+	},
+	req: &http.Request{},
+	pathVar: httprouter.Params{{
+		Key:   "bar",
+		Value: "val",
+	}},
+	expectMethod: "GET",
+	expectPath:   "/foo/:bar",
+	expectBody:   "got val",
 }, {
 	about: "error when unmarshaling",
 	f: func(c *gc.C) interface{} {
@@ -293,7 +350,7 @@ var handleTests = []struct {
 		Value: "not a number",
 	}},
 	expectBody: httprequest.RemoteError{
-		Message: `cannot unmarshal parameters: cannot unmarshal into field A: cannot parse "not a number" into int: expected integer`,
+		Message: `cannot unmarshal parameters: cannot unmarshal into field A: cannot parse path parameter "a" value "not a number" into int: expected integer`,
 		Code:    "bad request",
 	},
 	expectStatus: http.StatusBadRequest,
@@ -314,7 +371,7 @@ var handleTests = []struct {
 		Value: "not a number",
 	}},
 	expectBody: httprequest.RemoteError{
-		Message: `cannot unmarshal parameters: cannot unmarshal into field A: cannot parse "not a number" into int: expected integer`,
+		Message: `cannot unmarshal parameters: cannot unmarshal into field A: cannot parse path parameter "a" value "not a number" into int: expected integer`,
 		Code:    "bad request",
 	},
 	expectStatus: http.StatusBadRequest,
@@ -335,7 +392,7 @@ var handleTests = []struct {
 		Value: "not a number",
 	}},
 	expectBody: httprequest.RemoteError{
-		Message: `cannot unmarshal parameters: cannot unmarshal into field A: cannot parse "not a number" into int: expected integer`,
+		Message: `cannot unmarshal parameters: cannot unmarshal into field A: cannot parse path parameter "a" value "not a number" into int: expected integer`,
 		Code:    "bad request",
 	},
 	expectStatus: http.StatusBadRequest,
@@ -388,6 +445,46 @@ func (*handlerSuite) TestHandle(c *gc.C) {
 	}
 }
 
+func (*handlerSuite) TestHandleWithNoParamsSetsResultHeaders(c *gc.C) {
+	h := testServer.Handle(func(s *struct{}) (HeaderNumber, error) {
+		return HeaderNumber{N: 1234}, nil
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, &http.Request{}, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, `{"N":1234}`)
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "application/json")
+	c.Assert(rec.Header().Get("Some-Custom-Header"), gc.Equals, "yes")
+}
+
+func (*handlerSuite) TestHandleWithContext(c *gc.C) {
+	type testRequest struct {
+		A string `httprequest:"a,path"`
+	}
+	var gotCtx context.Context
+	h := testServer.Handle(func(ctx context.Context, arg *testRequest) (string, error) {
+		gotCtx = ctx
+		if arg.A == "bad" {
+			return "", errBadReq
+		}
+		return arg.A, nil
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, &http.Request{}, httprouter.Params{{
+		Key:   "a",
+		Value: "hello",
+	}})
+	httptesting.AssertJSONResponse(c, rec, http.StatusOK, "hello")
+	c.Assert(gotCtx, gc.NotNil)
+
+	rec = httptest.NewRecorder()
+	h.Handle(rec, &http.Request{}, httprouter.Params{{
+		Key:   "a",
+		Value: "bad",
+	}})
+	c.Assert(rec.Code, gc.Equals, http.StatusBadRequest)
+}
+
 var handlePanicTests = []struct {
 	f      interface{}
 	expect string
@@ -397,6 +494,9 @@ var handlePanicTests = []struct {
 }, {
 	f:      func(httprequest.Params) {},
 	expect: "bad handler function: no argument parameter after Params argument",
+}, {
+	f:      func(context.Context) {},
+	expect: "bad handler function: no argument parameter after context.Context argument",
 }, {
 	f:      func(httprequest.Params, *struct{}, struct{}) {},
 	expect: "bad handler function: has 3 parameters, need 1 or 2",
@@ -407,7 +507,7 @@ var handlePanicTests = []struct {
 	f: func(http.ResponseWriter, httprequest.Params) (struct{}, error) {
 		return struct{}{}, nil
 	},
-	expect: "bad handler function: first argument is http.ResponseWriter, need httprequest.Params",
+	expect: "bad handler function: first argument is http.ResponseWriter, need httprequest.Params or context.Context",
 }, {
 	f: func(httprequest.Params, *struct{}) (struct{}, struct{}) {
 		return struct{}{}, struct{}{}
@@ -415,7 +515,7 @@ var handlePanicTests = []struct {
 	expect: "bad handler function: final result parameter is struct {}, need error",
 }, {
 	f:      func(*http.Request, *struct{}) {},
-	expect: `bad handler function: first argument is \*http.Request, need httprequest.Params`,
+	expect: `bad handler function: first argument is \*http.Request, need httprequest.Params or context.Context`,
 }, {
 	f:      func(httprequest.Params, struct{}) {},
 	expect: "bad handler function: last argument cannot be used for Unmarshal: type is not pointer to struct",
@@ -458,6 +558,42 @@ var handlePanicTests = []struct {
 	}) {
 	},
 	expect: `bad handler function: last argument cannot be used for Unmarshal: bad route tag "httprequest:\\"BAD /foo\\"": invalid method`,
+}, {
+	f: func(*struct {
+		httprequest.Route `httprequest:"GET,BAD /foo"`
+	}) {
+	},
+	expect: `bad handler function: last argument cannot be used for Unmarshal: bad route tag "httprequest:\\"GET,BAD /foo\\"": invalid method`,
+}, {
+	f: func(*struct {
+		httprequest.Route `httprequest:"HEAD /foo"`
+	}) {
+	},
+	expect: `bad handler function: last argument cannot be used for Unmarshal: bad route tag "httprequest:\\"HEAD /foo\\"": invalid method`,
+}, {
+	f: func(*struct {
+		httprequest.Route `httprequest:"PUT,HEAD /foo"`
+	}) {
+	},
+	expect: `bad handler function: last argument cannot be used for Unmarshal: bad route tag "httprequest:\\"PUT,HEAD /foo\\"": HEAD method specified without GET`,
+}, {
+	f: func(*struct {
+		httprequest.Route `httprequest:"GET,GET /foo"`
+	}) {
+	},
+	expect: `bad handler function: last argument cannot be used for Unmarshal: bad route tag "httprequest:\\"GET,GET /foo\\"": duplicate method GET`,
+}, {
+	f: func(*struct {
+		httprequest.Route `httprequest:"GET,HEAD /foo"`
+	}) {
+	},
+	expect: `bad handler function: route specifies more than one method; use Handlers`,
+}, {
+	f: func(*struct {
+		Status int `httprequest:",status"`
+	}) {
+	},
+	expect: `bad handler function: last argument cannot be used for Unmarshal: response field Status not allowed in request type`,
 }}
 
 func (*handlerSuite) TestHandlePanicsWithBadFunctions(c *gc.C) {
@@ -732,7 +868,7 @@ var badHandlersFuncTests = []struct {
 }, {
 	about:       "bad type for close method",
 	f:           func(httprequest.Params) (_ badHandlersType3, _ context.Context, _ error) { return },
-	expectPanic: `bad type for Close method \(got func\(httprequest_test\.badHandlersType3\) want func\(httprequest_test.badHandlersType3\) error`,
+	expectPanic: `bad type for Close method \(got func\(\*httprequest_test\.badHandlersType3\) want func\(\*httprequest_test.badHandlersType3\) error`,
 }}
 
 type badHandlersType1 struct{}
@@ -767,6 +903,66 @@ func (*handlerSuite) TestBadHandlersFunc(c *gc.C) {
 	}
 }
 
+func (*handlerSuite) TestAllHandlers(c *gc.C) {
+	handleVal := testHandlers{
+		c: c,
+	}
+	f1 := func(p httprequest.Params) (*testHandlers, context.Context, error) {
+		handleVal.p = p
+		return &handleVal, p.Context, nil
+	}
+	f2 := func(p httprequest.Params) (*handlersWithRequestMethod, context.Context, error) {
+		return &handlersWithRequestMethod{}, p.Context, nil
+	}
+	handlers := testServer.AllHandlers(f1, f2)
+	routes := make([]string, len(handlers))
+	for i, h := range handlers {
+		routes[i] = h.Method + " " + h.Path
+	}
+	c.Assert(routes, jc.DeepEquals, []string{
+		"GET /m1/:p",
+		"GET /m2/:p",
+		"GET /m3/:p",
+		"POST /m3/:p",
+		"GET /x1/:p",
+	})
+	router := httprouter.New()
+	for _, h := range handlers {
+		router.Handle(h.Method, h.Path, h.Handle)
+	}
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    router,
+		URL:        "/m2/99",
+		ExpectBody: 999,
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    router,
+		URL:        "/x1/something",
+		ExpectBody: "something",
+	})
+}
+
+func (*handlerSuite) TestAllHandlersWithConflictingRoutes(c *gc.C) {
+	f1 := func(p httprequest.Params) (*handlersWithRequestMethod, context.Context, error) {
+		return &handlersWithRequestMethod{}, p.Context, nil
+	}
+	f2 := func(p httprequest.Params) (*testHandlers, context.Context, error) {
+		return &testHandlers{}, p.Context, nil
+	}
+	c.Assert(func() {
+		testServer.AllHandlers(f1, f2, f1)
+	}, gc.PanicMatches, `handler function 2 defines GET /x1/:p which is already defined by handler function 0`)
+}
+
+func (*handlerSuite) TestAllHandlersWithBadFunction(c *gc.C) {
+	f := func(p httprequest.Params) (*testHandlers, context.Context, error) {
+		return &testHandlers{}, p.Context, nil
+	}
+	c.Assert(func() {
+		testServer.AllHandlers(f, 123)
+	}, gc.PanicMatches, `bad handler function: expected function, got int`)
+}
+
 func (*handlerSuite) TestHandlersFuncReturningError(c *gc.C) {
 	handlers := testServer.Handlers(func(p httprequest.Params) (*testHandlers, context.Context, error) {
 		return nil, p.Context, errgo.WithCausef(errgo.New("failure"), errUnauth, "something")
@@ -841,6 +1037,261 @@ func (*handlerSuite) TestHandlersWithTypeThatImplementsIOCloser(c *gc.C) {
 	c.Assert(v.p, gc.Equals, 99)
 }
 
+type mixedReceiverHandlers struct {
+	prefix string
+}
+
+func (h mixedReceiverHandlers) V(arg *struct {
+	httprequest.Route `httprequest:"GET /v/:P"`
+	P                 string `httprequest:",path"`
+}) (string, error) {
+	return h.prefix + "v " + arg.P, nil
+}
+
+func (h *mixedReceiverHandlers) P(arg *struct {
+	httprequest.Route `httprequest:"GET /p/:P"`
+	P                 string `httprequest:",path"`
+}) (string, error) {
+	h.prefix += "changed "
+	return h.prefix + "p " + arg.P, nil
+}
+
+func (*handlerSuite) TestHandlersWithMixedReceivers(c *gc.C) {
+	v := mixedReceiverHandlers{
+		prefix: "hello ",
+	}
+	handlers := testServer.Handlers(func(p httprequest.Params) (mixedReceiverHandlers, context.Context, error) {
+		return v, p.Context, nil
+	})
+	c.Assert(handlers, gc.HasLen, 2)
+	router := httprouter.New()
+	for _, h := range handlers {
+		router.Handle(h.Method, h.Path, h.Handle)
+	}
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		URL:        "/v/a",
+		Handler:    router,
+		ExpectBody: "hello v a",
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		URL:        "/p/b",
+		Handler:    router,
+		ExpectBody: "hello changed p b",
+	})
+	// The pointer method is called on a copy of the value.
+	c.Assert(v.prefix, gc.Equals, "hello ")
+}
+
+type multiMethodHandlers struct{}
+
+func (multiMethodHandlers) Thing(p httprequest.Params, arg *struct {
+	httprequest.Route `httprequest:"GET,HEAD,PUT /thing/:id"`
+	ID                string `httprequest:"id,path"`
+}) (string, error) {
+	return p.Request.Method + " " + arg.ID, nil
+}
+
+func (*handlerSuite) TestHandlersWithMultipleMethods(c *gc.C) {
+	handlers := testServer.Handlers(func(p httprequest.Params) (multiMethodHandlers, context.Context, error) {
+		return multiMethodHandlers{}, p.Context, nil
+	})
+	c.Assert(handlers, gc.HasLen, 3)
+	for i, method := range []string{"GET", "HEAD", "PUT"} {
+		c.Assert(handlers[i].Method, gc.Equals, method)
+		c.Assert(handlers[i].Path, gc.Equals, "/thing/:id")
+	}
+	router := httprouter.New()
+	httprequest.AddHandlers(router, handlers)
+	for _, method := range []string{"GET", "PUT"} {
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Method:     method,
+			URL:        "/thing/x",
+			Handler:    router,
+			ExpectBody: method + " x",
+		})
+	}
+	req, err := http.NewRequest("HEAD", "/thing/x", nil)
+	c.Assert(err, gc.IsNil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+
+	// A client uses the first method.
+	var doer httprequesttest.Doer
+	doer.AddResponse(httprequesttest.JSONResponse(http.StatusOK, "ok"))
+	client := httprequest.Client{
+		BaseURL: "http://example.com",
+		Doer:    &doer,
+	}
+	err = client.Call(context.Background(), &struct {
+		httprequest.Route `httprequest:"GET,HEAD /thing/:id"`
+		ID                string `httprequest:"id,path"`
+	}{ID: "x"}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doer.Requests()[0].Method, gc.Equals, "GET")
+}
+
+type prefixHandlers struct{}
+
+func (prefixHandlers) M(p httprequest.Params, arg *struct {
+	httprequest.Route `httprequest:"GET /m/:P"`
+	Org               string `httprequest:"org,path"`
+	P                 string `httprequest:",path"`
+}) (string, error) {
+	return fmt.Sprintf("%s %s %s", p.PathPattern, arg.Org, arg.P), nil
+}
+
+func (*handlerSuite) TestHandlersWithPrefix(c *gc.C) {
+	handlers := testServer.HandlersWithPrefix("/v1/:org/", func(p httprequest.Params) (prefixHandlers, context.Context, error) {
+		return prefixHandlers{}, p.Context, nil
+	})
+	c.Assert(handlers, gc.HasLen, 1)
+	c.Assert(handlers[0].Method, gc.Equals, "GET")
+	c.Assert(handlers[0].Path, gc.Equals, "/v1/:org/m/:P")
+	router := httprouter.New()
+	httprequest.AddHandlers(router, handlers)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL: srv.URL + "/v1/canonical",
+	}
+	var resp string
+	err := client.Call(context.Background(), &struct {
+		httprequest.Route `httprequest:"GET /m/:P"`
+		P                 string `httprequest:",path"`
+	}{
+		P: "hello",
+	}, &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp, gc.Equals, "/v1/:org/m/:P canonical hello")
+}
+
+func (*handlerSuite) TestHandlersWithBadPrefix(c *gc.C) {
+	c.Assert(func() {
+		testServer.HandlersWithPrefix("v1", func(p httprequest.Params) (prefixHandlers, context.Context, error) {
+			return prefixHandlers{}, p.Context, nil
+		})
+	}, gc.PanicMatches, `path prefix "v1" does not start with a slash`)
+}
+
+type serveMuxHandlers struct{}
+
+func (serveMuxHandlers) Get(arg *struct {
+	httprequest.Route `httprequest:"GET /static"`
+}) (string, error) {
+	return "get static", nil
+}
+
+func (serveMuxHandlers) Post(arg *struct {
+	httprequest.Route `httprequest:"POST /static"`
+}) (string, error) {
+	return "post static", nil
+}
+
+func (serveMuxHandlers) User(arg *struct {
+	httprequest.Route `httprequest:"GET /users/:name/details"`
+	Name              string `httprequest:"name,path"`
+}) (string, error) {
+	return "user " + arg.Name, nil
+}
+
+func (serveMuxHandlers) File(arg *struct {
+	httprequest.Route `httprequest:"GET /files/*path"`
+	Path              string `httprequest:"path,path"`
+}) (string, error) {
+	return "file " + arg.Path, nil
+}
+
+var serveMuxHandlersTests = []struct {
+	method       string
+	path         string
+	expectStatus int
+	expectBody   interface{}
+}{{
+	method:     "GET",
+	path:       "/static",
+	expectBody: "get static",
+}, {
+	method:     "POST",
+	path:       "/static",
+	expectBody: "post static",
+}, {
+	method:     "GET",
+	path:       "/users/bob/details",
+	expectBody: "user bob",
+}, {
+	method:     "GET",
+	path:       "/files/a/b",
+	expectBody: "file /a/b",
+}, {
+	method:       "GET",
+	path:         "/users/bob",
+	expectStatus: http.StatusNotFound,
+}, {
+	method:       "GET",
+	path:         "/other",
+	expectStatus: http.StatusNotFound,
+}}
+
+func (*handlerSuite) TestServeMuxHandlers(c *gc.C) {
+	hs := testServer.ServeMuxHandlers(func(p httprequest.Params) (serveMuxHandlers, context.Context, error) {
+		return serveMuxHandlers{}, p.Context, nil
+	})
+	patterns := make([]string, 0, len(hs))
+	mux := http.NewServeMux()
+	for pattern, h := range hs {
+		patterns = append(patterns, pattern)
+		mux.Handle(pattern, h)
+	}
+	sort.Strings(patterns)
+	c.Assert(patterns, jc.DeepEquals, []string{"/files/", "/static", "/users/"})
+	for i, test := range serveMuxHandlersTests {
+		c.Logf("test %d: %s %s", i, test.method, test.path)
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(test.method, test.path, strings.NewReader(""))
+		c.Assert(err, gc.IsNil)
+		mux.ServeHTTP(rec, req)
+		if test.expectStatus != 0 {
+			c.Assert(rec.Code, gc.Equals, test.expectStatus)
+			continue
+		}
+		c.Assert(rec.Code, gc.Equals, http.StatusOK)
+		var body interface{}
+		err = json.Unmarshal(rec.Body.Bytes(), &body)
+		c.Assert(err, gc.IsNil)
+		c.Assert(body, jc.DeepEquals, test.expectBody)
+	}
+}
+
+func (*handlerSuite) TestHandlerWith(c *gc.C) {
+	var calls []string
+	middleware := func(name string) func(httprouter.Handle) httprouter.Handle {
+		return func(h httprouter.Handle) httprouter.Handle {
+			return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+				calls = append(calls, name)
+				h(w, req, p)
+			}
+		}
+	}
+	type testRequest struct {
+		httprequest.Route `httprequest:"GET /foo"`
+	}
+	h0 := testServer.Handle(func(p httprequest.Params, _ *testRequest) {
+		calls = append(calls, "handler")
+	})
+	h := h0.With(middleware("a"), middleware("b"))
+	c.Assert(h.Method, gc.Equals, "GET")
+	c.Assert(h.Path, gc.Equals, "/foo")
+	h.Handle(httptest.NewRecorder(), &http.Request{}, nil)
+	c.Assert(calls, jc.DeepEquals, []string{"a", "b", "handler"})
+
+	// Check that the original handler is unchanged.
+	calls = nil
+	h0.Handle(httptest.NewRecorder(), &http.Request{}, nil)
+	c.Assert(calls, jc.DeepEquals, []string{"handler"})
+}
+
 func (*handlerSuite) TestBadForm(c *gc.C) {
 	h := testServer.Handle(func(p httprequest.Params, _ *struct{}) {
 		c.Fatalf("shouldn't be called")
@@ -855,6 +1306,464 @@ func (*handlerSuite) TestBadFormNoParams(c *gc.C) {
 	testBadForm(c, h.Handle)
 }
 
+var maxFormValuesTests = []struct {
+	about         string
+	query         string
+	body          string
+	expectStatus  int
+	expectMessage string
+}{{
+	about:        "within limit",
+	query:        "a=1&b=2",
+	body:         "c=3",
+	expectStatus: http.StatusOK,
+}, {
+	about:         "too many query values",
+	query:         "a=1&a=2&a=3&a=4",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `too many form values in URL query (maximum 3)`,
+}, {
+	about:         "too many values in total",
+	query:         "a=1&b=2",
+	body:          "c=3&c=4",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `too many form values (maximum 3)`,
+}}
+
+func (*handlerSuite) TestMaxFormValues(c *gc.C) {
+	srv := testServer
+	srv.MaxFormValues = 3
+	h := srv.Handle(func(p httprequest.Params, _ *struct{}) {})
+	for i, test := range maxFormValuesTests {
+		c.Logf("test %d: %s", i, test.about)
+		req, err := http.NewRequest("POST", "/x?"+test.query, strings.NewReader(test.body))
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.Handle(rec, req, httprouter.Params{})
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		if test.expectMessage != "" {
+			resp := parseErrorResponse(c, rec.Body.Bytes())
+			c.Assert(resp.Message, gc.Equals, test.expectMessage)
+		}
+	}
+}
+
+var maxFormBodySizeTests = []struct {
+	about         string
+	body          string
+	expectStatus  int
+	expectMessage string
+}{{
+	about:        "form within limit",
+	body:         "a=1&b=22",
+	expectStatus: http.StatusOK,
+}, {
+	about:         "form too large",
+	body:          "a=1&b=222",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "cannot parse HTTP request form: http: request body too large",
+}}
+
+func (*handlerSuite) TestMaxFormBodySize(c *gc.C) {
+	var specificCause error
+	srv := testServer
+	srv.MaxFormBodySize = 8
+	srv.ErrorMapper = func(ctx context.Context, err error) (int, interface{}) {
+		specificCause = httprequest.UnmarshalErrorCause(err)
+		return testErrorMapper(ctx, err)
+	}
+	h := srv.Handle(func(p httprequest.Params, arg *struct {
+		A int `httprequest:"a,form"`
+	}) {
+	})
+	for i, test := range maxFormBodySizeTests {
+		c.Logf("test %d: %s", i, test.about)
+		specificCause = nil
+		req, err := http.NewRequest("POST", "/x", strings.NewReader(test.body))
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.Handle(rec, req, httprouter.Params{})
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		if test.expectMessage != "" {
+			resp := parseErrorResponse(c, rec.Body.Bytes())
+			c.Assert(resp.Message, gc.Equals, test.expectMessage)
+			c.Assert(specificCause, gc.Equals, httprequest.ErrFormParse)
+		}
+	}
+}
+
+func gzipData(data string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+	return buf.String()
+}
+
+func zlibData(data string) string {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+	return buf.String()
+}
+
+var decompressBodyTests = []struct {
+	about         string
+	disabled      bool
+	encoding      string
+	body          string
+	expectStatus  int
+	expectBody    string
+	expectMessage string
+}{{
+	about:        "no encoding",
+	body:         `{"A":"hello"}`,
+	expectStatus: http.StatusOK,
+	expectBody:   "hello",
+}, {
+	about:        "identity encoding",
+	encoding:     "identity",
+	body:         `{"A":"hello"}`,
+	expectStatus: http.StatusOK,
+	expectBody:   "hello",
+}, {
+	about:        "gzip encoding",
+	encoding:     "gzip",
+	body:         gzipData(`{"A":"hello"}`),
+	expectStatus: http.StatusOK,
+	expectBody:   "hello",
+}, {
+	about:        "deflate encoding",
+	encoding:     "Deflate",
+	body:         zlibData(`{"A":"hello"}`),
+	expectStatus: http.StatusOK,
+	expectBody:   "hello",
+}, {
+	about:        "body at maximum size",
+	encoding:     "gzip",
+	body:         gzipData(`{"A":"` + strings.Repeat("x", 42) + `"}`),
+	expectStatus: http.StatusOK,
+	expectBody:   strings.Repeat("x", 42),
+}, {
+	about:         "body too large",
+	encoding:      "gzip",
+	body:          gzipData(`{"A":"` + strings.Repeat("x", 43) + `"}`),
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `cannot unmarshal parameters: cannot unmarshal into field Body: cannot read request body: decompressed request body too large \(maximum 50 bytes\)`,
+}, {
+	about:         "invalid gzip header",
+	encoding:      "gzip",
+	body:          `{"A":"hello"}`,
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `cannot decompress request body: gzip: invalid header`,
+}, {
+	about:         "corrupt gzip data",
+	encoding:      "gzip",
+	body:          gzipData(`{"A":"hello"}`)[:20],
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `cannot unmarshal parameters: cannot unmarshal into field Body: cannot read request body: cannot decompress request body: unexpected EOF`,
+}, {
+	about:         "unsupported encoding",
+	encoding:      "br",
+	body:          `{"A":"hello"}`,
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `unsupported request Content-Encoding "br"`,
+}, {
+	about:         "decompression disabled",
+	disabled:      true,
+	encoding:      "gzip",
+	body:          gzipData(`{"A":"hello"}`),
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `cannot unmarshal parameters: cannot unmarshal into field Body: cannot unmarshal request body: invalid character .*`,
+}}
+
+func (*handlerSuite) TestDecompressBody(c *gc.C) {
+	for i, test := range decompressBodyTests {
+		c.Logf("test %d: %s", i, test.about)
+		srv := testServer
+		srv.DecompressBody = !test.disabled
+		srv.MaxDecompressedBodySize = 50
+		h := srv.Handle(func(p httprequest.Params, arg *struct {
+			Body struct {
+				A string
+			} `httprequest:",body"`
+		}) (string, error) {
+			return arg.Body.A, nil
+		})
+		req, err := http.NewRequest("POST", "/x", strings.NewReader(test.body))
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("Content-Type", "application/json")
+		if test.encoding != "" {
+			req.Header.Set("Content-Encoding", test.encoding)
+		}
+		rec := httptest.NewRecorder()
+		h.Handle(rec, req, httprouter.Params{})
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		if test.expectMessage != "" {
+			resp := parseErrorResponse(c, rec.Body.Bytes())
+			c.Assert(resp.Message, gc.Matches, test.expectMessage)
+			continue
+		}
+		var body string
+		err = json.Unmarshal(rec.Body.Bytes(), &body)
+		c.Assert(err, gc.IsNil)
+		c.Assert(body, gc.Equals, test.expectBody)
+	}
+}
+
+var noContentForEmptyResponseTests = []struct {
+	about        string
+	disabled     bool
+	f            interface{}
+	expectStatus int
+	expectBody   string
+}{{
+	about:        "error-returning handler returns nil",
+	f:            func(p httprequest.Params, _ *struct{}) error { return nil },
+	expectStatus: http.StatusNoContent,
+}, {
+	about:        "handler with no results",
+	f:            func(p httprequest.Params, _ *struct{}) {},
+	expectStatus: http.StatusNoContent,
+}, {
+	about:        "context handler returns nil",
+	f:            func(ctx context.Context, _ *struct{}) error { return nil },
+	expectStatus: http.StatusNoContent,
+}, {
+	about:        "option disabled",
+	disabled:     true,
+	f:            func(p httprequest.Params, _ *struct{}) error { return nil },
+	expectStatus: http.StatusOK,
+}, {
+	about: "handler writes body",
+	f: func(p httprequest.Params, _ *struct{}) error {
+		p.Response.Write([]byte("hello"))
+		return nil
+	},
+	expectStatus: http.StatusOK,
+	expectBody:   "hello",
+}, {
+	about: "handler writes status",
+	f: func(p httprequest.Params, _ *struct{}) error {
+		p.Response.WriteHeader(http.StatusCreated)
+		return nil
+	},
+	expectStatus: http.StatusCreated,
+}, {
+	about: "handler returns error",
+	f: func(p httprequest.Params, _ *struct{}) error {
+		return errUnauth
+	},
+	expectStatus: http.StatusUnauthorized,
+	expectBody:   `{"Message":"unauth","Code":"unauthorized"}`,
+}, {
+	about: "JSON-returning handler",
+	f: func(p httprequest.Params, _ *struct{}) (interface{}, error) {
+		return nil, nil
+	},
+	expectStatus: http.StatusOK,
+	expectBody:   "null",
+}}
+
+func (*handlerSuite) TestNoContentForEmptyResponse(c *gc.C) {
+	for i, test := range noContentForEmptyResponseTests {
+		c.Logf("test %d: %s", i, test.about)
+		srv := testServer
+		srv.NoContentForEmptyResponse = !test.disabled
+		h := srv.Handle(test.f)
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/x", nil)
+		c.Assert(err, gc.IsNil)
+		h.Handle(rec, req, httprouter.Params{})
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		c.Assert(rec.Body.String(), gc.Equals, test.expectBody)
+	}
+}
+
+var rejectUnexpectedBodyTests = []struct {
+	about         string
+	disabled      bool
+	f             interface{}
+	contentType   string
+	body          io.Reader
+	expectStatus  int
+	expectMessage string
+}{{
+	about:        "no body",
+	f:            func(*struct{}) {},
+	expectStatus: http.StatusOK,
+}, {
+	about:         "unexpected body",
+	f:             func(*struct{}) {},
+	contentType:   "application/json",
+	body:          strings.NewReader(`{}`),
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "unexpected request body",
+}, {
+	about:         "unexpected body of unknown length",
+	f:             func(*struct{}) {},
+	contentType:   "application/json",
+	body:          io.MultiReader(strings.NewReader(`{}`)),
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "unexpected request body",
+}, {
+	about:        "empty body of unknown length",
+	f:            func(*struct{}) {},
+	body:         io.MultiReader(),
+	expectStatus: http.StatusOK,
+}, {
+	about:        "option disabled",
+	disabled:     true,
+	f:            func(*struct{}) {},
+	contentType:  "application/json",
+	body:         strings.NewReader(`{}`),
+	expectStatus: http.StatusOK,
+}, {
+	about: "body field",
+	f: func(*struct {
+		Body struct{} `httprequest:",body"`
+	}) {
+	},
+	contentType:  "application/json",
+	body:         strings.NewReader(`{}`),
+	expectStatus: http.StatusOK,
+}, {
+	about: "form body with form fields",
+	f: func(*struct {
+		A int `httprequest:"a,form"`
+	}) {
+	},
+	contentType:  "application/x-www-form-urlencoded",
+	body:         strings.NewReader(`a=1`),
+	expectStatus: http.StatusOK,
+}, {
+	about:         "form body without form fields",
+	f:             func(*struct{}) {},
+	contentType:   "application/x-www-form-urlencoded",
+	body:          strings.NewReader(`a=1`),
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "unexpected request body",
+}, {
+	about: "non-form body with form fields",
+	f: func(*struct {
+		A int `httprequest:"a,form"`
+	}) {
+	},
+	contentType:   "application/json",
+	body:          strings.NewReader(`{"a":1}`),
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "unexpected request body",
+}}
+
+func (*handlerSuite) TestRejectUnexpectedBody(c *gc.C) {
+	for i, test := range rejectUnexpectedBodyTests {
+		c.Logf("test %d: %s", i, test.about)
+		srv := testServer
+		srv.RejectUnexpectedBody = !test.disabled
+		h := srv.Handle(test.f)
+		method := "POST"
+		if test.body == nil {
+			method = "GET"
+		}
+		req, err := http.NewRequest(method, "/x", test.body)
+		c.Assert(err, gc.IsNil)
+		if _, ok := test.body.(*strings.Reader); !ok && test.body != nil {
+			// Simulate a chunked request body.
+			req.ContentLength = -1
+		}
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		rec := httptest.NewRecorder()
+		h.Handle(rec, req, httprouter.Params{})
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		if test.expectMessage != "" {
+			resp := parseErrorResponse(c, rec.Body.Bytes())
+			c.Assert(resp.Message, gc.Equals, test.expectMessage)
+		}
+	}
+}
+
+var unmarshalErrorCauseTests = []struct {
+	about       string
+	query       string
+	body        string
+	encoding    string
+	expectCause error
+}{{
+	about:       "bad form",
+	query:       "%6",
+	body:        `{}`,
+	expectCause: httprequest.ErrFormParse,
+}, {
+	about:       "too many form values",
+	query:       "a=1&b=2&c=3",
+	body:        `{}`,
+	expectCause: httprequest.ErrFormParse,
+}, {
+	about:       "bad field value",
+	query:       "n=x",
+	body:        `{}`,
+	expectCause: httprequest.ErrFieldParse,
+}, {
+	about:       "bad body",
+	query:       "n=1",
+	body:        `{"A":`,
+	expectCause: httprequest.ErrBodyDecode,
+}, {
+	about:       "unsupported encoding",
+	query:       "n=1",
+	body:        `{}`,
+	encoding:    "br",
+	expectCause: httprequest.ErrBodyDecode,
+}}
+
+func (*handlerSuite) TestUnmarshalErrorCause(c *gc.C) {
+	var cause, specificCause error
+	srv := httprequest.Server{
+		ErrorMapper: func(ctx context.Context, err error) (int, interface{}) {
+			cause = errgo.Cause(err)
+			specificCause = httprequest.UnmarshalErrorCause(err)
+			return testErrorMapper(ctx, err)
+		},
+		MaxFormValues:  2,
+		DecompressBody: true,
+	}
+	h := srv.Handle(func(p httprequest.Params, arg *struct {
+		N    int `httprequest:"n,form"`
+		Body struct {
+			A string
+		} `httprequest:",body"`
+	}) {
+	})
+	for i, test := range unmarshalErrorCauseTests {
+		c.Logf("test %d: %s", i, test.about)
+		cause, specificCause = nil, nil
+		req, err := http.NewRequest("POST", "/x", strings.NewReader(test.body))
+		c.Assert(err, gc.IsNil)
+		req.URL.RawQuery = test.query
+		req.Header.Set("Content-Type", "application/json")
+		if test.encoding != "" {
+			req.Header.Set("Content-Encoding", test.encoding)
+		}
+		rec := httptest.NewRecorder()
+		h.Handle(rec, req, httprouter.Params{})
+		c.Assert(rec.Code, gc.Equals, http.StatusBadRequest)
+		c.Assert(cause, gc.Equals, httprequest.ErrUnmarshal)
+		c.Assert(specificCause, gc.Equals, test.expectCause)
+	}
+}
+
+func (*handlerSuite) TestUnmarshalErrorCauseWithOtherError(c *gc.C) {
+	c.Assert(httprequest.UnmarshalErrorCause(errBadReq), gc.Equals, errBadReq)
+	err := errgo.WithCausef(nil, httprequest.ErrUnmarshal, "something")
+	c.Assert(httprequest.UnmarshalErrorCause(err), gc.Equals, httprequest.ErrUnmarshal)
+	c.Assert(httprequest.UnmarshalErrorCause(nil), gc.IsNil)
+}
+
 func testBadForm(c *gc.C, h httprouter.Handle) {
 	rec := httptest.NewRecorder()
 	req := &http.Request{
@@ -897,6 +1806,285 @@ func (*handlerSuite) TestWriteJSON(c *gc.C) {
 	c.Assert(rec.Header().Get("content-type"), gc.Equals, "application/json")
 }
 
+func (*handlerSuite) TestHandleStreamingBody(c *gc.C) {
+	type item struct {
+		N int
+	}
+	h := testServer.Handle(func(p httprequest.Params, arg *struct {
+		Items *json.Decoder `httprequest:",body" httpcontenttype:"application/x-ndjson"`
+	}) (int, error) {
+		total := 0
+		for {
+			var it item
+			err := arg.Items.Decode(&it)
+			if err == io.EOF {
+				return total, nil
+			}
+			if err != nil {
+				return 0, errgo.Mask(err)
+			}
+			total += it.N
+		}
+	})
+	req, err := http.NewRequest("POST", "/", io.MultiReader(strings.NewReader("{\"N\": 1}\n{\"N\": 2}\n"), strings.NewReader("{\"N\": 39}\n")))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, "42")
+
+	req, err = http.NewRequest("POST", "/", strings.NewReader("a,b"))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "text/csv")
+	rec = httptest.NewRecorder()
+	h.Handle(rec, req, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusBadRequest)
+	resp := parseErrorResponse(c, rec.Body.Bytes())
+	c.Assert(resp.Message, gc.Matches, `cannot unmarshal parameters: cannot unmarshal into field Items: unexpected content type text/csv; want application/json; content: "a,b"`)
+}
+
+type userKey struct{}
+
+type contextValueHandlers struct{}
+
+func (contextValueHandlers) Whoami(p httprequest.Params, arg *struct {
+	httprequest.Route `httprequest:"GET /whoami"`
+}) (string, error) {
+	user, _ := p.Value(userKey{}).(string)
+	return user, nil
+}
+
+func (*handlerSuite) TestParamsValueFromHandlersContext(c *gc.C) {
+	hs := testServer.Handlers(func(p httprequest.Params) (contextValueHandlers, context.Context, error) {
+		return contextValueHandlers{}, context.WithValue(p.Context, userKey{}, p.Request.Header.Get("X-User")), nil
+	})
+	router := httprouter.New()
+	httprequest.AddHandlers(router, hs)
+	req, err := http.NewRequest("GET", "/whoami", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("X-User", "bob")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, `"bob"`)
+
+	c.Assert(httprequest.Params{}.Value(userKey{}), gc.IsNil)
+}
+
+type visibilityKey struct{}
+
+// visibility is added to the context by the Handlers function
+// so that a handler method can record whether the user may know
+// that the requested resource exists.
+type visibility struct {
+	visible bool
+}
+
+func (contextValueHandlers) Thing(p httprequest.Params, arg *struct {
+	httprequest.Route `httprequest:"GET /thing/:id"`
+	ID                string `httprequest:"id,path"`
+}) error {
+	if arg.ID == "public" {
+		p.Value(visibilityKey{}).(*visibility).visible = true
+	}
+	return errgo.WithCausef(nil, errNoAccess, "cannot access %q", arg.ID)
+}
+
+var errorMapperContextTests = []struct {
+	about        string
+	user         string
+	path         string
+	expectStatus int
+	expectError  httprequest.RemoteError
+}{{
+	about:        "visible resource",
+	user:         "bob",
+	path:         "/thing/public",
+	expectStatus: http.StatusForbidden,
+	expectError: httprequest.RemoteError{
+		Message: `cannot access "public"`,
+		Code:    "bob",
+	},
+}, {
+	about:        "invisible resource",
+	user:         "bob",
+	path:         "/thing/secret",
+	expectStatus: http.StatusNotFound,
+	expectError: httprequest.RemoteError{
+		Message: `cannot access "secret"`,
+		Code:    "bob",
+	},
+}, {
+	about:        "error from Handlers function",
+	path:         "/thing/public",
+	expectStatus: http.StatusNotFound,
+	expectError: httprequest.RemoteError{
+		Message: "no user",
+	},
+}}
+
+func (*handlerSuite) TestErrorMapperContextFromHandlers(c *gc.C) {
+	srv := httprequest.Server{
+		ErrorMapper: func(ctx context.Context, err error) (int, interface{}) {
+			user, _ := ctx.Value(userKey{}).(string)
+			status := http.StatusInternalServerError
+			if errgo.Cause(err) == errNoAccess {
+				status = http.StatusNotFound
+				if ctx.Value(visibilityKey{}).(*visibility).visible {
+					status = http.StatusForbidden
+				}
+			}
+			return status, &httprequest.RemoteError{
+				Message: err.Error(),
+				Code:    user,
+			}
+		},
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (contextValueHandlers, context.Context, error) {
+		ctx := context.WithValue(p.Context, visibilityKey{}, new(visibility))
+		user := p.Request.Header.Get("X-User")
+		if user == "" {
+			return contextValueHandlers{}, ctx, errgo.WithCausef(nil, errNoAccess, "no user")
+		}
+		return contextValueHandlers{}, context.WithValue(ctx, userKey{}, user), nil
+	})
+	router := httprouter.New()
+	httprequest.AddHandlers(router, hs)
+	for i, test := range errorMapperContextTests {
+		c.Logf("test %d: %s", i, test.about)
+		req, err := http.NewRequest("GET", test.path, nil)
+		c.Assert(err, gc.IsNil)
+		if test.user != "" {
+			req.Header.Set("X-User", test.user)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		var resp httprequest.RemoteError
+		err = json.Unmarshal(rec.Body.Bytes(), &resp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp, jc.DeepEquals, test.expectError)
+	}
+}
+
+func (*handlerSuite) TestParamsBind(c *gc.C) {
+	type paging struct {
+		Limit  int `httprequest:"limit,form"`
+		Offset int `httprequest:"offset,form"`
+	}
+	type bodyOnly struct {
+		Body struct {
+			N int
+		} `httprequest:",body"`
+	}
+	var bound paging
+	var bindBodyErr error
+	h := testServer.Handle(func(p httprequest.Params, arg *struct {
+		ID   string `httprequest:"id,path"`
+		Body struct {
+			N int
+		} `httprequest:",body"`
+	}) (string, error) {
+		if err := p.Bind(&bound); err != nil {
+			return "", errgo.Mask(err)
+		}
+		bindBodyErr = p.Bind(&bodyOnly{})
+		return fmt.Sprintf("%s %d", arg.ID, arg.Body.N), nil
+	})
+	req, err := http.NewRequest("POST", "/?limit=10&offset=20", strings.NewReader(`{"N": 99}`))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req, httprouter.Params{{Key: "id", Value: "x"}})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, `"x 99"`)
+	c.Assert(bound, gc.Equals, paging{Limit: 10, Offset: 20})
+	// The body has already been read, so it cannot be bound again.
+	c.Assert(bindBodyErr, gc.ErrorMatches, `cannot unmarshal into field Body: cannot unmarshal request body: unexpected end of JSON input`)
+	c.Assert(errgo.Cause(bindBodyErr), gc.Equals, httprequest.ErrUnmarshal)
+
+	err = httprequest.Params{}.Bind(struct{}{})
+	c.Assert(err, gc.ErrorMatches, `bad type struct {}: type is not pointer to struct`)
+	c.Assert(errgo.Cause(err), gc.Equals, httprequest.ErrBadUnmarshalType)
+}
+
+var writeJSONRawResponseTests = []struct {
+	about             string
+	val               interface{}
+	expectBody        string
+	expectContentType string
+}{{
+	about: "raw response",
+	val: httprequest.RawResponse{
+		ContentType: "text/csv",
+		Body:        []byte("a,b\n1,2\n"),
+	},
+	expectBody:        "a,b\n1,2\n",
+	expectContentType: "text/csv",
+}, {
+	about: "raw response pointer",
+	val: &httprequest.RawResponse{
+		ContentType: "image/png",
+		Body:        []byte("\x89PNG"),
+	},
+	expectBody:        "\x89PNG",
+	expectContentType: "image/png",
+}, {
+	about: "raw response with no content type",
+	val: httprequest.RawResponse{
+		Body: []byte("data"),
+	},
+	expectBody:        "data",
+	expectContentType: "application/octet-stream",
+}, {
+	about:             "nil raw response pointer",
+	val:               (*httprequest.RawResponse)(nil),
+	expectBody:        "null",
+	expectContentType: "application/json",
+}}
+
+func (*handlerSuite) TestWriteJSONRawResponse(c *gc.C) {
+	for i, test := range writeJSONRawResponseTests {
+		c.Logf("test %d: %s", i, test.about)
+		rec := httptest.NewRecorder()
+		err := httprequest.WriteJSON(rec, http.StatusTeapot, test.val)
+		c.Assert(err, gc.IsNil)
+		c.Assert(rec.Code, gc.Equals, http.StatusTeapot)
+		c.Assert(rec.Body.String(), gc.Equals, test.expectBody)
+		c.Assert(rec.Header().Get("Content-Type"), gc.Equals, test.expectContentType)
+	}
+}
+
+func (*handlerSuite) TestHandleRawResponse(c *gc.C) {
+	h := testServer.HandleJSON(func(p httprequest.Params) (interface{}, error) {
+		return httprequest.RawResponse{
+			ContentType: "text/plain",
+			Body:        []byte("hello"),
+		}, nil
+	})
+	rec := httptest.NewRecorder()
+	h(rec, &http.Request{}, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, "hello")
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "text/plain")
+	c.Assert(rec.Header().Get("Content-Length"), gc.Equals, "5")
+
+	h = testServer.Handle(func(p httprequest.Params, _ *struct{}) (*httprequest.RawResponse, error) {
+		return &httprequest.RawResponse{
+			ContentType: "text/csv",
+			Body:        []byte("a,b"),
+		}, nil
+	}).Handle
+	rec = httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, gc.IsNil)
+	h(rec, req, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, "a,b")
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "text/csv")
+}
+
 var (
 	errUnauth             = errors.New("unauth")
 	errBadReq             = errors.New("bad request")
@@ -904,6 +2092,7 @@ var (
 	errCustomHeaders      = errors.New("custom headers")
 	errUnmarshalableError = errors.New("unmarshalable error")
 	errNil                = errors.New("nil result")
+	errNoAccess           = errors.New("no access")
 )
 
 type HeaderNumber struct {
@@ -1014,6 +2203,61 @@ func (s *handlerSuite) TestWriteError(c *gc.C) {
 	}
 }
 
+func (s *handlerSuite) TestWriteErrorStatus(c *gc.C) {
+	rec := httptest.NewRecorder()
+	testServer.WriteErrorStatus(context.TODO(), rec, http.StatusServiceUnavailable, errUnauth)
+	c.Assert(rec.Code, gc.Equals, http.StatusServiceUnavailable)
+	resp := parseErrorResponse(c, rec.Body.Bytes())
+	c.Assert(resp, gc.DeepEquals, &httprequest.RemoteError{
+		Message: errUnauth.Error(),
+		Code:    "unauthorized",
+	})
+
+	// The status is overridden even when the error
+	// response cannot be marshaled.
+	rec = httptest.NewRecorder()
+	testServer.WriteErrorStatus(context.TODO(), rec, http.StatusServiceUnavailable, errUnmarshalableError)
+	c.Assert(rec.Code, gc.Equals, http.StatusServiceUnavailable)
+}
+
+func (s *handlerSuite) TestWriteErrorWithContextFreeErrorMapper(c *gc.C) {
+	srv := httprequest.Server{
+		ErrorMapper: httprequest.ErrorMapperWithContext(func(err error) (int, interface{}) {
+			return http.StatusTeapot, &httprequest.RemoteError{
+				Message: err.Error(),
+				Code:    "teapot",
+			}
+		}),
+	}
+	rec := httptest.NewRecorder()
+	srv.WriteError(context.TODO(), rec, errOther)
+	c.Assert(rec.Code, gc.Equals, http.StatusTeapot)
+	resp := parseErrorResponse(c, rec.Body.Bytes())
+	c.Assert(resp, gc.DeepEquals, &httprequest.RemoteError{
+		Message: errOther.Error(),
+		Code:    "teapot",
+	})
+}
+
+func (s *handlerSuite) TestErrorMapperReceivesRequestContext(c *gc.C) {
+	var handlerCtx, mapperCtx context.Context
+	srv := httprequest.Server{
+		ErrorMapper: func(ctx context.Context, err error) (int, interface{}) {
+			mapperCtx = ctx
+			return testErrorMapper(ctx, err)
+		},
+	}
+	h := srv.Handle(func(p httprequest.Params, arg *struct{}) error {
+		handlerCtx = p.Context
+		return errUnauth
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, new(http.Request), nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized)
+	c.Assert(handlerCtx, gc.NotNil)
+	c.Assert(mapperCtx, gc.Equals, handlerCtx)
+}
+
 func parseErrorResponse(c *gc.C, body []byte) *httprequest.RemoteError {
 	var errResp *httprequest.RemoteError
 	err := json.Unmarshal(body, &errResp)
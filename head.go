@@ -0,0 +1,108 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// addHEADHandlers returns hs with a HEAD handler added for each GET
+// handler in hs for which there is no HEAD handler already.
+func addHEADHandlers(hs []Handler) []Handler {
+	hasHEAD := make(map[string]bool)
+	for _, h := range hs {
+		if h.Method == "HEAD" {
+			hasHEAD[h.Path] = true
+		}
+	}
+	for _, h := range hs {
+		if h.Method != "GET" || hasHEAD[h.Path] {
+			continue
+		}
+		hasHEAD[h.Path] = true
+		hs = append(hs, Handler{
+			Method: "HEAD",
+			Path:   h.Path,
+			Handle: headHandler(h.Handle),
+		})
+	}
+	return hs
+}
+
+// headHandler returns a handler that calls the given GET handler and
+// writes only the header of its response.
+func headHandler(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		w1 := &headResponseWriter{
+			ResponseWriter: w,
+		}
+		h(w1, req, p)
+		w1.writeHeader(true)
+	}
+}
+
+// Ensure statically that headResponseWriter does implement http.Flusher.
+var _ http.Flusher = (*headResponseWriter)(nil)
+
+// headResponseWriter wraps http.ResponseWriter, discarding any body
+// written to it. The header is not written until writeHeader is
+// called, so that the Content-Length header can be set from the length
+// of the discarded body.
+type headResponseWriter struct {
+	http.ResponseWriter
+
+	// status holds the status code passed to WriteHeader,
+	// or zero if it has not been called.
+	status int
+
+	// length holds the number of body bytes discarded.
+	length int64
+
+	// headerWritten records whether the header has been
+	// written to the underlying ResponseWriter.
+	headerWritten bool
+}
+
+func (w *headResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.length += int64(len(data))
+	return len(data), nil
+}
+
+func (w *headResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+// Flush implements http.Flusher.Flush by writing the header.
+// No Content-Length header will be set after this.
+func (w *headResponseWriter) Flush() {
+	w.writeHeader(false)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeHeader writes the header to the underlying ResponseWriter
+// if it has not already been written. If setLength is true, the
+// Content-Length header is set if the handler has not set it.
+func (w *headResponseWriter) writeHeader(setLength bool) {
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if setLength && w.length > 0 && w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(w.length, 10))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
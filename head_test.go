@@ -0,0 +1,132 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type headSuite struct{}
+
+var _ = gc.Suite(&headSuite{})
+
+type headHandlers struct{}
+
+func (headHandlers) Get(*struct {
+	httprequest.Route `httprequest:"GET /item/:id"`
+}) (string, error) {
+	return "some content", nil
+}
+
+func (headHandlers) Put(*struct {
+	httprequest.Route `httprequest:"PUT /item/:id"`
+}) error {
+	return nil
+}
+
+func (headHandlers) GetBoth(p httprequest.Params, _ *struct {
+	httprequest.Route `httprequest:"GET,HEAD /both"`
+}) {
+	p.Response.Header().Set("X-Method", p.Request.Method)
+}
+
+func (headHandlers) Stream(p httprequest.Params, _ *struct {
+	httprequest.Route `httprequest:"GET /stream"`
+}) {
+	p.Response.WriteHeader(http.StatusAccepted)
+	p.Response.Write([]byte("first"))
+	p.Response.(http.Flusher).Flush()
+	p.Response.Write([]byte("second"))
+}
+
+func (headHandlers) Empty(p httprequest.Params, _ *struct {
+	httprequest.Route `httprequest:"GET /empty"`
+}) {
+	p.Response.WriteHeader(http.StatusNoContent)
+}
+
+func newHeadHandlers(p httprequest.Params) (headHandlers, context.Context, error) {
+	return headHandlers{}, p.Context, nil
+}
+
+func (*headSuite) TestHandleHEADDisabled(c *gc.C) {
+	for _, h := range testServer.Handlers(newHeadHandlers) {
+		if h.Method == "HEAD" {
+			c.Assert(h.Path, gc.Equals, "/both")
+		}
+	}
+}
+
+var handleHEADTests = []struct {
+	about        string
+	path         string
+	expectStatus int
+	expectHeader http.Header
+}{{
+	about:        "JSON result",
+	path:         "/item/1",
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type":   {"application/json"},
+		"Content-Length": {"14"},
+	},
+}, {
+	about:        "explicit HEAD method",
+	path:         "/both",
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"X-Method": {"HEAD"},
+	},
+}, {
+	about:        "flushed response",
+	path:         "/stream",
+	expectStatus: http.StatusAccepted,
+	expectHeader: http.Header{
+		"Content-Length": nil,
+	},
+}, {
+	about:        "empty response",
+	path:         "/empty",
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Content-Length": nil,
+	},
+}}
+
+func (*headSuite) TestHandleHEAD(c *gc.C) {
+	srv := testServer
+	srv.HandleHEAD = true
+	hs := srv.Handlers(newHeadHandlers)
+	methods := make(map[string][]string)
+	for _, h := range hs {
+		methods[h.Path] = append(methods[h.Path], h.Method)
+	}
+	c.Assert(methods, gc.DeepEquals, map[string][]string{
+		"/item/:id": {"GET", "PUT", "HEAD"},
+		"/both":     {"GET", "HEAD"},
+		"/stream":   {"GET", "HEAD"},
+		"/empty":    {"GET", "HEAD"},
+	})
+	router := httprouter.New()
+	httprequest.AddHandlers(router, hs)
+	for i, test := range handleHEADTests {
+		c.Logf("test %d: %s", i, test.about)
+		req, err := http.NewRequest("HEAD", test.path, nil)
+		c.Assert(err, gc.IsNil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		c.Assert(rec.Body.String(), gc.Equals, "")
+		for k, v := range test.expectHeader {
+			c.Assert(rec.HeaderMap[k], gc.DeepEquals, v, gc.Commentf("header %q", k))
+		}
+	}
+}
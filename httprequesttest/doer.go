@@ -0,0 +1,158 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package httprequesttest provides helpers for testing code that uses
+// httprequest.Client.
+package httprequesttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/net/context"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+var (
+	_ httprequest.Doer            = (*Doer)(nil)
+	_ httprequest.DoerWithContext = (*Doer)(nil)
+)
+
+// Request holds a request that was made with a Doer.
+type Request struct {
+	// Method, URL and Header hold the corresponding
+	// fields of the request.
+	Method string
+	URL    *url.URL
+	Header http.Header
+
+	// Body holds the entire request body.
+	Body []byte
+
+	// Context holds the context passed to DoWithContext,
+	// or nil if the request was made with Do.
+	Context context.Context
+}
+
+// Doer is a mock implementation of httprequest.Doer and
+// httprequest.DoerWithContext, suitable for use as
+// httprequest.Client.Doer. It records all the requests made
+// with it and replies to them with canned responses.
+//
+// It is safe to use concurrently.
+type Doer struct {
+	mu        sync.Mutex
+	requests  []Request
+	responses []doerResponse
+}
+
+type doerResponse struct {
+	resp *http.Response
+	err  error
+}
+
+// AddResponse adds a response to the queue of responses. Each
+// request made with d uses the response at the head of the queue,
+// which is used only once. The Request field of the response will be
+// set to the request.
+func (d *Doer) AddResponse(resp *http.Response) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.responses = append(d.responses, doerResponse{resp: resp})
+}
+
+// AddError adds an error to the queue of responses, so that the
+// corresponding request will fail with the given error.
+func (d *Doer) AddError(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.responses = append(d.responses, doerResponse{err: err})
+}
+
+// Requests returns all the requests that have been made with d,
+// in the order they were made.
+func (d *Doer) Requests() []Request {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Request(nil), d.requests...)
+}
+
+// Do implements httprequest.Doer.Do. If there are no
+// remaining responses, it returns an error.
+func (d *Doer) Do(req *http.Request) (*http.Response, error) {
+	return d.do(nil, req)
+}
+
+// DoWithContext implements httprequest.DoerWithContext.DoWithContext.
+func (d *Doer) DoWithContext(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return d.do(ctx, req)
+}
+
+func (d *Doer) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	r := Request{
+		Method:  req.Method,
+		URL:     req.URL,
+		Header:  req.Header,
+		Context: ctx,
+	}
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot read request body")
+		}
+		r.Body = data
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests = append(d.requests, r)
+	if len(d.responses) == 0 {
+		return nil, errgo.Newf("no response for request %s %s", req.Method, req.URL)
+	}
+	resp := d.responses[0]
+	d.responses = d.responses[1:]
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	resp.resp.Request = req
+	return resp.resp, nil
+}
+
+// NewResponse returns a response with the given status code,
+// header and body, suitable for passing to Doer.AddResponse.
+// The header may be nil.
+func NewResponse(status int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+// JSONResponse returns a response with the given status code
+// and the JSON-marshaled value as its body. It panics if
+// the value cannot be marshaled.
+func JSONResponse(status int, val interface{}) *http.Response {
+	data, err := json.Marshal(val)
+	if err != nil {
+		panic(errgo.Notef(err, "cannot marshal response body"))
+	}
+	return NewResponse(status, http.Header{
+		"Content-Type": {"application/json"},
+	}, data)
+}
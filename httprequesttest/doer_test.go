@@ -0,0 +1,105 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequesttest_test
+
+import (
+	"net/http"
+	"testing"
+
+	jc "github.com/juju/testing/checkers"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+	"github.com/juju/httprequest/httprequesttest"
+)
+
+func TestPackage(t *testing.T) {
+	gc.TestingT(t)
+}
+
+type doerSuite struct{}
+
+var _ = gc.Suite(&doerSuite{})
+
+type getReq struct {
+	httprequest.Route `httprequest:"GET /x/:P"`
+	P                 string `httprequest:",path"`
+	Token             string `httprequest:"Token,header"`
+}
+
+type postReq struct {
+	httprequest.Route `httprequest:"POST /y"`
+	Body              struct {
+		N int
+	} `httprequest:",body"`
+}
+
+type resp struct {
+	N int
+}
+
+func (*doerSuite) TestDoer(c *gc.C) {
+	var doer httprequesttest.Doer
+	doer.AddResponse(httprequesttest.JSONResponse(http.StatusOK, resp{1}))
+	doer.AddResponse(httprequesttest.JSONResponse(http.StatusBadRequest, &httprequest.RemoteError{
+		Message: "bad",
+	}))
+	doer.AddError(errgo.New("network failure"))
+	client := httprequest.Client{
+		BaseURL: "http://example.com",
+		Doer:    &doer,
+	}
+	ctx := context.Background()
+
+	var r resp
+	err := client.Call(ctx, &getReq{P: "a", Token: "tok"}, &r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(r, jc.DeepEquals, resp{1})
+
+	req := &postReq{}
+	req.Body.N = 99
+	err = client.Call(ctx, req, nil)
+	c.Assert(err, gc.ErrorMatches, `Post http://example.com/y: bad`)
+
+	err = client.Call(ctx, &getReq{P: "b"}, nil)
+	c.Assert(err, gc.ErrorMatches, `Get http://example.com/x/b: network failure`)
+
+	err = client.Call(ctx, &getReq{P: "c"}, nil)
+	c.Assert(err, gc.ErrorMatches, `Get http://example.com/x/c: no response for request GET http://example.com/x/c`)
+
+	reqs := doer.Requests()
+	c.Assert(reqs, gc.HasLen, 4)
+	c.Assert(reqs[0].Method, gc.Equals, "GET")
+	c.Assert(reqs[0].URL.String(), gc.Equals, "http://example.com/x/a")
+	c.Assert(reqs[0].Header.Get("Token"), gc.Equals, "tok")
+	c.Assert(reqs[0].Body, gc.HasLen, 0)
+	c.Assert(reqs[0].Context, gc.NotNil)
+	c.Assert(reqs[1].Method, gc.Equals, "POST")
+	c.Assert(reqs[1].URL.String(), gc.Equals, "http://example.com/y")
+	c.Assert(string(reqs[1].Body), gc.Equals, `{"N":99}`)
+	c.Assert(reqs[1].Header.Get("Content-Type"), gc.Equals, "application/json")
+}
+
+func (*doerSuite) TestDoWithoutContext(c *gc.C) {
+	var doer httprequesttest.Doer
+	doer.AddResponse(httprequesttest.NewResponse(http.StatusNoContent, nil, nil))
+	req, err := http.NewRequest("DELETE", "http://example.com/z", nil)
+	c.Assert(err, gc.IsNil)
+	resp, err := doer.Do(req)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	c.Assert(resp.Status, gc.Equals, "204 No Content")
+	c.Assert(resp.Request, gc.Equals, req)
+	reqs := doer.Requests()
+	c.Assert(reqs, gc.HasLen, 1)
+	c.Assert(reqs[0].Context, gc.IsNil)
+}
+
+func (*doerSuite) TestJSONResponseWithUnmarshalableValue(c *gc.C) {
+	c.Assert(func() {
+		httprequesttest.JSONResponse(http.StatusOK, make(chan int))
+	}, gc.PanicMatches, `cannot marshal response body: json: unsupported type: chan int`)
+}
@@ -0,0 +1,265 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	"gopkg.in/errgo.v1"
+)
+
+// IdempotencyStore is used by Server to store the responses to
+// requests made with an Idempotency-Key header so that they can be
+// replayed when the same request is retried. See
+// Server.IdempotencyStore.
+type IdempotencyStore interface {
+	// Get returns the response stored with the given key,
+	// or nil if there is none.
+	Get(ctx context.Context, key string) (*StoredResponse, error)
+
+	// Add stores the given response with the given key if there
+	// is no response already stored with that key, and reports
+	// whether it did so. It must do this atomically, as it is
+	// used to reserve a key while its request is being handled.
+	Add(ctx context.Context, key string, resp *StoredResponse) (bool, error)
+
+	// Set stores the given response with the given key.
+	// Implementations will usually discard responses after
+	// some time.
+	Set(ctx context.Context, key string, resp *StoredResponse) error
+
+	// Delete removes any response stored with the given key.
+	Delete(ctx context.Context, key string) error
+}
+
+// StoredResponse holds a response stored in an IdempotencyStore.
+type StoredResponse struct {
+	// StatusCode holds the HTTP status of the response.
+	// It is zero when the request is still being handled.
+	StatusCode int
+
+	// BodyHash holds the hex-encoded SHA-256 hash of the
+	// body of the request.
+	BodyHash string
+
+	// Header holds the response header.
+	Header http.Header
+
+	// Body holds the response body.
+	Body []byte
+}
+
+// idempotentReplayHeader is the header set on a response
+// that has been replayed from an IdempotencyStore.
+const idempotentReplayHeader = "Idempotent-Replayed"
+
+// statusUnprocessableEntity holds the 422 (Unprocessable Entity)
+// status, which is not defined by net/http before Go 1.7.
+const statusUnprocessableEntity = 422
+
+// idempotencyHandler returns a handler that calls h, storing the
+// response in store when the request has an Idempotency-Key header,
+// and replaying the stored response instead of calling h when there
+// is one. See Server.IdempotencyStore for details.
+func (srv *Server) idempotencyHandler(store IdempotencyStore, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		key := req.Header.Get("Idempotency-Key")
+		if key == "" {
+			h(w, req, p)
+			return
+		}
+		ctx, cancel := contextFromRequest(req)
+		defer cancel()
+		// Scope the key to the route, and to the caller if
+		// possible, so that the same key used for different
+		// operations or by different callers does not clash.
+		key = req.Method + " " + req.URL.Path + " " + key
+		if srv.IdempotencyScope != nil {
+			key = strconv.Quote(srv.IdempotencyScope(req)) + " " + key
+		}
+		body, err := readRequestBody(req)
+		if err != nil {
+			srv.WriteError(ctx, w, errgo.WithCausef(err, ErrUnmarshal, "cannot read request body"))
+			return
+		}
+		bodyHash := sha256.Sum256(body)
+		hash := hex.EncodeToString(bodyHash[:])
+		// Reserve the key so that a concurrent retry of the
+		// request does not call the handler too.
+		reserved, err := store.Add(ctx, key, &StoredResponse{
+			BodyHash: hash,
+		})
+		if err != nil {
+			srv.WriteError(ctx, w, errgo.Notef(err, "cannot reserve idempotency key"))
+			return
+		}
+		if !reserved {
+			resp, err := store.Get(ctx, key)
+			switch {
+			case err != nil:
+				srv.WriteError(ctx, w, errgo.Notef(err, "cannot get stored response"))
+			case resp != nil && resp.BodyHash != hash:
+				srv.WriteErrorStatus(ctx, w, statusUnprocessableEntity, errgo.New("idempotency key reused with a different request body"))
+			case resp == nil || resp.StatusCode == 0:
+				// The first request is still in progress,
+				// or it has just failed and its reservation
+				// has been removed, in which case the
+				// client can retry.
+				srv.WriteErrorStatus(ctx, w, http.StatusConflict, errgo.New("request with the same idempotency key is in progress"))
+			default:
+				writeStoredResponse(w, resp)
+			}
+			return
+		}
+		stored := false
+		defer func() {
+			if stored {
+				return
+			}
+			// Remove the reservation so that the
+			// request can be retried.
+			if err := store.Delete(ctx, key); err != nil {
+				srv.logIdempotencyError(ctx, errgo.Notef(err, "cannot remove idempotency key reservation"))
+			}
+		}()
+		// Headers already set by the time the handler is called,
+		// such as CORS and request ID headers, depend on the
+		// request rather than the response, so don't store them.
+		omit := make(map[string]bool)
+		for k := range w.Header() {
+			omit[k] = true
+		}
+		w1 := &recordingResponseWriter{
+			ResponseWriter: w,
+			omit:           omit,
+		}
+		h(w1, req, p)
+		if w1.status == 0 {
+			w1.status = http.StatusOK
+		}
+		if w1.status >= 500 {
+			// Don't store server errors, which are
+			// usually transient, so that the client
+			// can retry the request.
+			return
+		}
+		// The response has already been written, so all we
+		// can do if it cannot be stored is report the error.
+		err = store.Set(ctx, key, &StoredResponse{
+			StatusCode: w1.status,
+			BodyHash:   hash,
+			Header:     w1.header,
+			Body:       w1.body.Bytes(),
+		})
+		if err != nil {
+			srv.logIdempotencyError(ctx, errgo.Notef(err, "cannot store response"))
+			return
+		}
+		stored = true
+	}
+}
+
+// logIdempotencyError passes err to srv.IdempotencyErrorLogger
+// if it is set.
+func (srv *Server) logIdempotencyError(ctx context.Context, err error) {
+	if srv.IdempotencyErrorLogger != nil {
+		srv.IdempotencyErrorLogger(ctx, err)
+	}
+}
+
+// readRequestBody reads all of the body of req, replacing it
+// so that it can be read again by the handler.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// writeStoredResponse writes resp to w. Headers that have already
+// been set in w for the current request are not overwritten.
+func writeStoredResponse(w http.ResponseWriter, resp *StoredResponse) {
+	h := w.Header()
+	for k, v := range resp.Header {
+		if _, ok := h[k]; !ok {
+			h[k] = v
+		}
+	}
+	h.Set(idempotentReplayHeader, "true")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
+
+// Ensure statically that recordingResponseWriter does implement http.Flusher.
+var _ http.Flusher = (*recordingResponseWriter)(nil)
+
+// recordingResponseWriter wraps http.ResponseWriter, recording a copy
+// of the response written to it.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+
+	// status holds the status code of the response,
+	// or zero if the header has not been written.
+	status int
+
+	// header holds a copy of the header as it was
+	// when the status was written, without the
+	// headers in omit.
+	header http.Header
+
+	// omit holds the names of headers that
+	// should not be recorded.
+	omit map[string]bool
+
+	// body holds the body written so far.
+	body bytes.Buffer
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	w.recordHeader(http.StatusOK)
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingResponseWriter) WriteHeader(code int) {
+	w.recordHeader(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher.Flush.
+func (w *recordingResponseWriter) Flush() {
+	w.recordHeader(http.StatusOK)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// recordHeader records the given status and the current header
+// if they have not already been recorded.
+func (w *recordingResponseWriter) recordHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	w.header = make(http.Header)
+	for k, v := range w.Header() {
+		if w.omit[k] {
+			continue
+		}
+		w.header[k] = append([]string(nil), v...)
+	}
+}
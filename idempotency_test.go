@@ -0,0 +1,442 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type idempotencySuite struct{}
+
+var _ = gc.Suite(&idempotencySuite{})
+
+// memStore is an in-memory implementation of
+// httprequest.IdempotencyStore.
+type memStore struct {
+	mu        sync.Mutex
+	responses map[string]*httprequest.StoredResponse
+	getError  error
+	addError  error
+	setError  error
+}
+
+func (s *memStore) Get(ctx context.Context, key string) (*httprequest.StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.getError != nil {
+		return nil, s.getError
+	}
+	return s.responses[key], nil
+}
+
+func (s *memStore) Set(ctx context.Context, key string, resp *httprequest.StoredResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.setError != nil {
+		return s.setError
+	}
+	if s.responses == nil {
+		s.responses = make(map[string]*httprequest.StoredResponse)
+	}
+	s.responses[key] = resp
+	return nil
+}
+
+func (s *memStore) Add(ctx context.Context, key string, resp *httprequest.StoredResponse) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.addError != nil {
+		return false, s.addError
+	}
+	if _, ok := s.responses[key]; ok {
+		return false, nil
+	}
+	if s.responses == nil {
+		s.responses = make(map[string]*httprequest.StoredResponse)
+	}
+	s.responses[key] = resp
+	return true, nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.responses, key)
+	return nil
+}
+
+type idempotencyHandlers struct {
+	calls *int
+}
+
+func (h idempotencyHandlers) Create(p httprequest.Params, arg *struct {
+	httprequest.Route `httprequest:"POST /items/:id"`
+	ID                string `httprequest:"id,path"`
+}) (string, error) {
+	*h.calls++
+	switch arg.ID {
+	case "unauth":
+		return "", errgo.WithCausef(nil, errUnauth, "no entry")
+	case "fail":
+		return "", errgo.New("server error")
+	}
+	p.Response.Header().Set("X-Call", strings.Repeat("x", *h.calls))
+	return "created " + arg.ID, nil
+}
+
+func (h idempotencyHandlers) Get(arg *struct {
+	httprequest.Route `httprequest:"GET /items/:id"`
+}) (string, error) {
+	*h.calls++
+	return "got", nil
+}
+
+var idempotencyTests = []struct {
+	about        string
+	method       string
+	path         string
+	key          string
+	body         string
+	expectStatus int
+	expectBody   string
+	expectHeader http.Header
+	expectCalls  int
+}{{
+	about:        "first request",
+	method:       "POST",
+	path:         "/items/a",
+	key:          "k1",
+	expectStatus: http.StatusOK,
+	expectBody:   `"created a"`,
+	expectHeader: http.Header{
+		"Content-Type": {"application/json"},
+		"X-Call":       {"x"},
+	},
+	expectCalls: 1,
+}, {
+	about:        "replayed request",
+	method:       "POST",
+	path:         "/items/a",
+	key:          "k1",
+	expectStatus: http.StatusOK,
+	expectBody:   `"created a"`,
+	expectHeader: http.Header{
+		"Content-Type":        {"application/json"},
+		"X-Call":              {"x"},
+		"Idempotent-Replayed": {"true"},
+	},
+	expectCalls: 1,
+}, {
+	about:        "same key with a different body",
+	method:       "POST",
+	path:         "/items/a",
+	key:          "k1",
+	body:         "other",
+	expectStatus: 422,
+	expectBody:   `{"Message":"idempotency key reused with a different request body"}`,
+	expectCalls:  1,
+}, {
+	about:        "same key on a different path",
+	method:       "POST",
+	path:         "/items/b",
+	key:          "k1",
+	expectStatus: http.StatusOK,
+	expectBody:   `"created b"`,
+	expectHeader: http.Header{
+		"Content-Type": {"application/json"},
+		"X-Call":       {"xx"},
+	},
+	expectCalls: 2,
+}, {
+	about:        "no key",
+	method:       "POST",
+	path:         "/items/a",
+	expectStatus: http.StatusOK,
+	expectBody:   `"created a"`,
+	expectHeader: http.Header{
+		"Content-Type": {"application/json"},
+		"X-Call":       {"xxx"},
+	},
+	expectCalls: 3,
+}, {
+	about:        "client error is stored",
+	method:       "POST",
+	path:         "/items/unauth",
+	key:          "k2",
+	expectStatus: http.StatusUnauthorized,
+	expectBody:   `{"Message":"no entry","Code":"unauthorized"}`,
+	expectCalls:  4,
+}, {
+	about:        "replayed client error",
+	method:       "POST",
+	path:         "/items/unauth",
+	key:          "k2",
+	expectStatus: http.StatusUnauthorized,
+	expectBody:   `{"Message":"no entry","Code":"unauthorized"}`,
+	expectCalls:  4,
+}, {
+	about:        "server error is not stored",
+	method:       "POST",
+	path:         "/items/fail",
+	key:          "k3",
+	expectStatus: http.StatusInternalServerError,
+	expectBody:   `{"Message":"server error"}`,
+	expectCalls:  5,
+}, {
+	about:        "retried server error",
+	method:       "POST",
+	path:         "/items/fail",
+	key:          "k3",
+	expectStatus: http.StatusInternalServerError,
+	expectBody:   `{"Message":"server error"}`,
+	expectCalls:  6,
+}, {
+	about:        "GET requests are not stored",
+	method:       "GET",
+	path:         "/items/a",
+	key:          "k4",
+	expectStatus: http.StatusOK,
+	expectBody:   `"got"`,
+	expectCalls:  7,
+}, {
+	about:        "repeated GET request",
+	method:       "GET",
+	path:         "/items/a",
+	key:          "k4",
+	expectStatus: http.StatusOK,
+	expectBody:   `"got"`,
+	expectCalls:  8,
+}}
+
+func (*idempotencySuite) TestIdempotencyStore(c *gc.C) {
+	calls := 0
+	srv := testServer
+	srv.IdempotencyStore = new(memStore)
+	router := httprouter.New()
+	httprequest.AddHandlers(router, srv.Handlers(func(p httprequest.Params) (idempotencyHandlers, context.Context, error) {
+		return idempotencyHandlers{&calls}, p.Context, nil
+	}))
+	for i, test := range idempotencyTests {
+		c.Logf("test %d: %s", i, test.about)
+		req, err := http.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		c.Assert(err, gc.IsNil)
+		if test.key != "" {
+			req.Header.Set("Idempotency-Key", test.key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		c.Assert(rec.Body.String(), gc.Equals, test.expectBody)
+		if test.expectHeader != nil {
+			c.Assert(rec.HeaderMap, gc.DeepEquals, test.expectHeader)
+		}
+		c.Assert(calls, gc.Equals, test.expectCalls)
+	}
+}
+
+func (*idempotencySuite) TestIdempotencyScope(c *gc.C) {
+	calls := 0
+	srv := testServer
+	srv.IdempotencyStore = new(memStore)
+	srv.IdempotencyScope = func(req *http.Request) string {
+		return req.Header.Get("X-User")
+	}
+	router := httprouter.New()
+	httprequest.AddHandlers(router, srv.Handlers(func(p httprequest.Params) (idempotencyHandlers, context.Context, error) {
+		return idempotencyHandlers{&calls}, p.Context, nil
+	}))
+	do := func(user string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/items/a", strings.NewReader(""))
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("Idempotency-Key", "k1")
+		req.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Code, gc.Equals, http.StatusOK)
+		return rec
+	}
+	rec := do("alice")
+	c.Assert(rec.Header().Get("X-Call"), gc.Equals, "x")
+	c.Assert(calls, gc.Equals, 1)
+
+	// The same key from another caller is not replayed.
+	rec = do("bob")
+	c.Assert(rec.Header().Get("X-Call"), gc.Equals, "xx")
+	c.Assert(rec.Header().Get("Idempotent-Replayed"), gc.Equals, "")
+	c.Assert(calls, gc.Equals, 2)
+
+	// but it is for the same caller.
+	rec = do("alice")
+	c.Assert(rec.Header().Get("X-Call"), gc.Equals, "x")
+	c.Assert(rec.Header().Get("Idempotent-Replayed"), gc.Equals, "true")
+	c.Assert(calls, gc.Equals, 2)
+}
+
+func (*idempotencySuite) TestIdempotencyStoreGetError(c *gc.C) {
+	calls := 0
+	srv := testServer
+	srv.IdempotencyStore = &memStore{
+		responses: map[string]*httprequest.StoredResponse{
+			"POST /items/a k1": {},
+		},
+		getError: errgo.New("store unavailable"),
+	}
+	router := httprouter.New()
+	httprequest.AddHandlers(router, srv.Handlers(func(p httprequest.Params) (idempotencyHandlers, context.Context, error) {
+		return idempotencyHandlers{&calls}, p.Context, nil
+	}))
+	req, err := http.NewRequest("POST", "/items/a", strings.NewReader(""))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Idempotency-Key", "k1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, gc.Equals, http.StatusInternalServerError)
+	c.Assert(rec.Body.String(), gc.Equals, `{"Message":"cannot get stored response: store unavailable"}`)
+	c.Assert(calls, gc.Equals, 0)
+}
+
+func (*idempotencySuite) TestIdempotencyStoreAddError(c *gc.C) {
+	calls := 0
+	srv := testServer
+	srv.IdempotencyStore = &memStore{
+		addError: errgo.New("store unavailable"),
+	}
+	router := httprouter.New()
+	httprequest.AddHandlers(router, srv.Handlers(func(p httprequest.Params) (idempotencyHandlers, context.Context, error) {
+		return idempotencyHandlers{&calls}, p.Context, nil
+	}))
+	req, err := http.NewRequest("POST", "/items/a", strings.NewReader(""))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Idempotency-Key", "k1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, gc.Equals, http.StatusInternalServerError)
+	c.Assert(rec.Body.String(), gc.Equals, `{"Message":"cannot reserve idempotency key: store unavailable"}`)
+	c.Assert(calls, gc.Equals, 0)
+}
+
+func (*idempotencySuite) TestIdempotencyStoreSetError(c *gc.C) {
+	calls := 0
+	var logged []string
+	srv := testServer
+	srv.IdempotencyStore = &memStore{
+		setError: errgo.New("store unavailable"),
+	}
+	srv.IdempotencyErrorLogger = func(ctx context.Context, err error) {
+		logged = append(logged, err.Error())
+	}
+	router := httprouter.New()
+	httprequest.AddHandlers(router, srv.Handlers(func(p httprequest.Params) (idempotencyHandlers, context.Context, error) {
+		return idempotencyHandlers{&calls}, p.Context, nil
+	}))
+	req, err := http.NewRequest("POST", "/items/a", strings.NewReader(""))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Idempotency-Key", "k1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, `"created a"`)
+	c.Assert(calls, gc.Equals, 1)
+	c.Assert(logged, gc.DeepEquals, []string{"cannot store response: store unavailable"})
+}
+
+func (*idempotencySuite) TestReplayedResponseHasRequestHeaders(c *gc.C) {
+	calls := 0
+	srv := testServer
+	srv.IdempotencyStore = new(memStore)
+	srv.RequestIDHeader = httprequest.DefaultRequestIDHeader
+	srv.CORS = &httprequest.CORSPolicy{
+		AllowedOrigins: []string{"http://a.example.com", "http://b.example.com"},
+	}
+	router := httprouter.New()
+	httprequest.AddHandlers(router, srv.Handlers(func(p httprequest.Params) (idempotencyHandlers, context.Context, error) {
+		return idempotencyHandlers{&calls}, p.Context, nil
+	}))
+	do := func(origin, id string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/items/a", strings.NewReader(""))
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("Idempotency-Key", "k1")
+		req.Header.Set("Origin", origin)
+		req.Header.Set("X-Request-Id", id)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Code, gc.Equals, http.StatusOK)
+		return rec
+	}
+	rec := do("http://a.example.com", "id1")
+	c.Assert(rec.Header().Get("Access-Control-Allow-Origin"), gc.Equals, "http://a.example.com")
+	c.Assert(rec.Header().Get("X-Request-Id"), gc.Equals, "id1")
+
+	rec = do("http://b.example.com", "id2")
+	c.Assert(rec.Header().Get("Idempotent-Replayed"), gc.Equals, "true")
+	c.Assert(rec.Header().Get("X-Call"), gc.Equals, "x")
+	c.Assert(rec.Header()["Access-Control-Allow-Origin"], gc.DeepEquals, []string{"http://b.example.com"})
+	c.Assert(rec.Header()["X-Request-Id"], gc.DeepEquals, []string{"id2"})
+	c.Assert(calls, gc.Equals, 1)
+}
+
+type blockingHandlers struct {
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (h blockingHandlers) Create(arg *struct {
+	httprequest.Route `httprequest:"POST /items/:id"`
+}) (string, error) {
+	h.started <- struct{}{}
+	<-h.unblock
+	return "created", nil
+}
+
+func (*idempotencySuite) TestConcurrentRetry(c *gc.C) {
+	h := blockingHandlers{
+		started: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+	srv := testServer
+	srv.IdempotencyStore = new(memStore)
+	router := httprouter.New()
+	httprequest.AddHandlers(router, srv.Handlers(func(p httprequest.Params) (blockingHandlers, context.Context, error) {
+		return h, p.Context, nil
+	}))
+	do := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/items/a", strings.NewReader(""))
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("Idempotency-Key", "k1")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- do()
+	}()
+	<-h.started
+
+	// A retry while the first request is in progress
+	// does not call the handler.
+	rec := do()
+	c.Assert(rec.Code, gc.Equals, http.StatusConflict)
+	c.Assert(rec.Body.String(), gc.Equals, `{"Message":"request with the same idempotency key is in progress"}`)
+
+	close(h.unblock)
+	rec = <-done
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, `"created"`)
+
+	rec = do()
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, `"created"`)
+	c.Assert(rec.Header().Get("Idempotent-Replayed"), gc.Equals, "true")
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// jsonAPIMediaType holds the media type used by JSON:API
+// (see http://jsonapi.org/format/#content-negotiation).
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// JSONAPIErrors holds an error response in the format defined by
+// JSON:API (see http://jsonapi.org/format/#errors). It can be used
+// as an alternative to RemoteError.
+type JSONAPIErrors struct {
+	Errors []JSONAPIError `json:"errors"`
+}
+
+// JSONAPIError holds a single error in a JSON:API error response.
+type JSONAPIError struct {
+	// Status holds the HTTP status code as a string.
+	Status string `json:"status,omitempty"`
+
+	// Code may hold a code that classifies the error.
+	Code string `json:"code,omitempty"`
+
+	// Title may hold a short summary of the kind of error.
+	Title string `json:"title,omitempty"`
+
+	// Detail holds the error message.
+	Detail string `json:"detail,omitempty"`
+}
+
+// Error implements the error interface. It returns the details of
+// all the errors, separated by semicolons.
+func (e *JSONAPIErrors) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		if err.Detail != "" {
+			msgs = append(msgs, err.Detail)
+		}
+	}
+	if len(msgs) == 0 {
+		return "httprequest: no error message found"
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// SetHeader implements HeaderSetter by setting
+// the JSON:API content type.
+func (e *JSONAPIErrors) SetHeader(h http.Header) {
+	h.Set("Content-Type", jsonAPIMediaType)
+}
+
+// JSONAPIErrorMapper returns a function suitable for use as
+// Server.ErrorMapper that writes errors in JSON:API format.
+// The given function is used to determine the HTTP status
+// and error code for an error; the error message is used
+// as the error detail.
+func JSONAPIErrorMapper(f func(ctx context.Context, err error) (httpStatus int, code string)) func(ctx context.Context, err error) (httpStatus int, errorBody interface{}) {
+	return func(ctx context.Context, err error) (int, interface{}) {
+		status, code := f(ctx, err)
+		return status, &JSONAPIErrors{
+			Errors: []JSONAPIError{{
+				Status: strconv.Itoa(status),
+				Code:   code,
+				Detail: err.Error(),
+			}},
+		}
+	}
+}
+
+// JSONAPIErrorUnmarshaler is an error unmarshaler, suitable for
+// use as Client.UnmarshalError, that unmarshals errors written
+// in JSON:API format into a *JSONAPIErrors value. The JSON:API
+// media type is accepted because the client accepts any media
+// type with a "+json" suffix in a response.
+var JSONAPIErrorUnmarshaler = ErrorUnmarshaler(new(JSONAPIErrors))
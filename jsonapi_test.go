@@ -0,0 +1,86 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type jsonAPISuite struct{}
+
+var _ = gc.Suite(&jsonAPISuite{})
+
+var jsonAPIServer = httprequest.Server{
+	ErrorMapper: httprequest.JSONAPIErrorMapper(func(ctx context.Context, err error) (int, string) {
+		if errgo.Cause(err) == errUnauth {
+			return http.StatusUnauthorized, "unauthorized"
+		}
+		return http.StatusInternalServerError, ""
+	}),
+}
+
+func (*jsonAPISuite) TestWriteError(c *gc.C) {
+	rec := httptest.NewRecorder()
+	jsonAPIServer.WriteError(context.TODO(), rec, errUnauth)
+	c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized)
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "application/vnd.api+json")
+	c.Assert(rec.Body.String(), gc.Equals, `{"errors":[{"status":"401","code":"unauthorized","detail":"unauth"}]}`)
+}
+
+func (*jsonAPISuite) TestClientRoundTrip(c *gc.C) {
+	router := httprouter.New()
+	router.Handle("GET", "/x", jsonAPIServer.HandleErrors(func(p httprequest.Params) error {
+		return errUnauth
+	}))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	client := httprequest.Client{
+		BaseURL:        srv.URL,
+		UnmarshalError: httprequest.JSONAPIErrorUnmarshaler,
+	}
+	err := client.Get(context.Background(), "/x", nil)
+	c.Assert(err, gc.ErrorMatches, `Get http://.*/x: unauth`)
+	c.Assert(errgo.Cause(err), jc.DeepEquals, &httprequest.JSONAPIErrors{
+		Errors: []httprequest.JSONAPIError{{
+			Status: "401",
+			Code:   "unauthorized",
+			Detail: "unauth",
+		}},
+	})
+}
+
+var jsonAPIErrorsErrorTests = []struct {
+	err    *httprequest.JSONAPIErrors
+	expect string
+}{{
+	err:    &httprequest.JSONAPIErrors{},
+	expect: "httprequest: no error message found",
+}, {
+	err: &httprequest.JSONAPIErrors{
+		Errors: []httprequest.JSONAPIError{{
+			Detail: "first",
+		}, {
+			Code: "no detail",
+		}, {
+			Detail: "second",
+		}},
+	},
+	expect: "first; second",
+}}
+
+func (*jsonAPISuite) TestJSONAPIErrorsError(c *gc.C) {
+	for i, test := range jsonAPIErrorsErrorTests {
+		c.Logf("test %d", i)
+		c.Assert(test.err.Error(), gc.Equals, test.expect)
+	}
+}
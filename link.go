@@ -0,0 +1,142 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ParseLinkHeader parses the Link headers in h as specified by RFC 5988
+// and returns a map from each link relation type to the corresponding
+// link target URL. This is commonly used for pagination, where the
+// "next" relation holds the URL of the next page of results. For
+// example, the header
+//
+//	Link: <https://example.com/items?page=2>; rel="next", <https://example.com/items?page=9>; rel="last"
+//
+// results in a map with the keys "next" and "last".
+//
+// Relation types are compared case-insensitively and are returned
+// in lower case. A link with several space-separated relation types
+// is entered under each of them. If more than one link has the same
+// relation type, the first one is used. Target URLs are returned as
+// they are found in the header; relative URLs should be resolved
+// against the request URL. Malformed links are ignored.
+func ParseLinkHeader(h http.Header) map[string]string {
+	links := make(map[string]string)
+	for _, v := range h["Link"] {
+		parseLinks(v, links)
+	}
+	return links
+}
+
+// parseLinks parses a single Link header value and adds
+// the links found in it to links.
+func parseLinks(s string, links map[string]string) {
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return
+		}
+		if s[0] != '<' {
+			s = skipLink(s)
+			continue
+		}
+		end := strings.IndexByte(s, '>')
+		if end == -1 {
+			return
+		}
+		target := s[1:end]
+		s = s[end+1:]
+		var rels []string
+		foundRel := false
+		for {
+			s = strings.TrimLeft(s, " \t")
+			if s == "" || s[0] == ',' {
+				break
+			}
+			if s[0] != ';' {
+				// Malformed parameter; ignore the rest of the link.
+				s = skipLink(s)
+				rels = nil
+				break
+			}
+			var name, value string
+			name, s = linkToken(strings.TrimLeft(s[1:], " \t"))
+			s = strings.TrimLeft(s, " \t")
+			if s != "" && s[0] == '=' {
+				value, s = linkParamValue(strings.TrimLeft(s[1:], " \t"))
+			}
+			// Only the first rel parameter is significant.
+			if !foundRel && strings.EqualFold(name, "rel") {
+				foundRel = true
+				rels = strings.Fields(value)
+			}
+		}
+		for _, rel := range rels {
+			rel = strings.ToLower(rel)
+			if _, ok := links[rel]; !ok {
+				links[rel] = target
+			}
+		}
+	}
+}
+
+// linkToken returns the token at the start of s
+// and the rest of s after it.
+func linkToken(s string) (string, string) {
+	i := strings.IndexAny(s, "=;, \t")
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+// linkParamValue returns the value of the parameter,
+// which may be a token or a quoted string, at the start
+// of s, and the rest of s after it.
+func linkParamValue(s string) (string, string) {
+	if s == "" || s[0] != '"' {
+		return linkToken(s)
+	}
+	value := make([]byte, 0, len(s))
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return string(value), s[i+1:]
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				value = append(value, s[i])
+			}
+		default:
+			value = append(value, c)
+		}
+	}
+	// Unterminated quoted string.
+	return string(value), ""
+}
+
+// skipLink returns the rest of s after the end of the current
+// link, ignoring any commas within quoted strings or angle
+// brackets.
+func skipLink(s string) string {
+	inQuote, inTarget := false, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inQuote && c == '\\':
+			i++
+		case c == '"' && !inTarget:
+			inQuote = !inQuote
+		case c == '<' && !inQuote:
+			inTarget = true
+		case c == '>' && !inQuote:
+			inTarget = false
+		case c == ',' && !inQuote && !inTarget:
+			return s[i:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type linkSuite struct{}
+
+var _ = gc.Suite(&linkSuite{})
+
+var parseLinkHeaderTests = []struct {
+	about  string
+	header []string
+	expect map[string]string
+}{{
+	about:  "no header",
+	expect: map[string]string{},
+}, {
+	about: "single link",
+	header: []string{
+		`<https://example.com/items?page=2>; rel="next"`,
+	},
+	expect: map[string]string{
+		"next": "https://example.com/items?page=2",
+	},
+}, {
+	about: "several links",
+	header: []string{
+		`<https://example.com/items?page=2>; rel="next", <https://example.com/items?page=9>; rel="last"`,
+	},
+	expect: map[string]string{
+		"next": "https://example.com/items?page=2",
+		"last": "https://example.com/items?page=9",
+	},
+}, {
+	about: "several header lines",
+	header: []string{
+		`<https://example.com/1>; rel=prev`,
+		`<https://example.com/3>; rel=next`,
+	},
+	expect: map[string]string{
+		"prev": "https://example.com/1",
+		"next": "https://example.com/3",
+	},
+}, {
+	about: "multiple relation types and case",
+	header: []string{
+		`</a>; rel="Next  Last"`,
+	},
+	expect: map[string]string{
+		"next": "/a",
+		"last": "/a",
+	},
+}, {
+	about: "first link for a relation wins",
+	header: []string{
+		`</a>; rel=next, </b>; rel=next`,
+	},
+	expect: map[string]string{
+		"next": "/a",
+	},
+}, {
+	about: "only first rel parameter is used",
+	header: []string{
+		`</a>; rel=next; rel=prev`,
+	},
+	expect: map[string]string{
+		"next": "/a",
+	},
+}, {
+	about: "other parameters and quoted commas",
+	header: []string{
+		`</a,b>; title="x, y; \"z\""; REL="next" ; type=text/html, </c>;rel=prev`,
+	},
+	expect: map[string]string{
+		"next": "/a,b",
+		"prev": "/c",
+	},
+}, {
+	about: "parameter without value",
+	header: []string{
+		`</a>; foo; rel=next`,
+	},
+	expect: map[string]string{
+		"next": "/a",
+	},
+}, {
+	about: "link without rel",
+	header: []string{
+		`</a>; title=foo, </b>; rel=next`,
+	},
+	expect: map[string]string{
+		"next": "/b",
+	},
+}, {
+	about: "malformed links are skipped",
+	header: []string{
+		`nonsense, "a, b", </a> junk; rel=prev, </b>; rel=next`,
+	},
+	expect: map[string]string{
+		"next": "/b",
+	},
+}, {
+	about: "unterminated target",
+	header: []string{
+		`</b>; rel=next, </a; rel=prev`,
+	},
+	expect: map[string]string{
+		"next": "/b",
+	},
+}, {
+	about: "unterminated quoted string",
+	header: []string{
+		`</a>; rel="next`,
+	},
+	expect: map[string]string{
+		"next": "/a",
+	},
+}}
+
+func (*linkSuite) TestParseLinkHeader(c *gc.C) {
+	for i, test := range parseLinkHeaderTests {
+		c.Logf("test %d: %s", i, test.about)
+		h := make(http.Header)
+		for _, v := range test.header {
+			h.Add("Link", v)
+		}
+		c.Assert(httprequest.ParseLinkHeader(h), jc.DeepEquals, test.expect)
+	}
+}
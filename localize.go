@@ -0,0 +1,159 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// requestKey is the context key used to record
+// the HTTP request that a context was created for.
+type requestKey struct{}
+
+// RequestFromContext returns the HTTP request that the given context
+// was created for, or nil if there is none. The contexts created by
+// Server for its handlers, including Params.Context and so the
+// context passed to Server.ErrorMapper, record their request, which
+// allows an ErrorMapper to tailor the error response to it; see
+// LocalizedErrorMapper for an example.
+//
+// As Server.WriteError allows a nil context, so does
+// RequestFromContext.
+func RequestFromContext(ctx context.Context) *http.Request {
+	if ctx == nil {
+		return nil
+	}
+	req, _ := ctx.Value(requestKey{}).(*http.Request)
+	return req
+}
+
+// MessageCatalog holds localized error messages. It maps from an
+// error code to a map from language tag, such as "fr" or "pt-BR",
+// to the message in that language.
+type MessageCatalog map[string]map[string]string
+
+// Message returns the message for the given error code in the
+// language that best matches the given Accept-Language header value,
+// and reports whether one was found.
+//
+// The language ranges in the header are tried in order of preference,
+// as given by their quality values. A range matches a language tag in
+// the catalog if it is equal to it, ignoring case; if there is no such
+// tag, the range is progressively truncated at its last "-" and tried
+// again, so that "pt-BR" will fall back to "pt", as described by the
+// "Lookup" scheme in RFC 4647.
+func (c MessageCatalog) Message(code, acceptLanguage string) (string, bool) {
+	msgs := c[code]
+	if len(msgs) == 0 {
+		return "", false
+	}
+	for _, lang := range languageRanges(acceptLanguage) {
+		for lang != "" {
+			for tag, msg := range msgs {
+				if strings.EqualFold(tag, lang) {
+					return msg, true
+				}
+			}
+			i := strings.LastIndex(lang, "-")
+			if i == -1 {
+				break
+			}
+			lang = lang[0:i]
+		}
+	}
+	return "", false
+}
+
+// LocalizedErrorMapper returns a function suitable for use as
+// Server.ErrorMapper that uses f to map the error and then, if the
+// resulting error body is a *RemoteError, replaces its message with
+// the one for its error code in the catalog in the language that best
+// matches the request's Accept-Language header (see
+// MessageCatalog.Message).
+//
+// If the catalog has no suitable message, or the context passed to
+// the mapper does not record its request (see RequestFromContext),
+// the error body returned by f is used unchanged.
+func LocalizedErrorMapper(catalog MessageCatalog, f func(ctx context.Context, err error) (httpStatus int, errorBody interface{})) func(ctx context.Context, err error) (httpStatus int, errorBody interface{}) {
+	return func(ctx context.Context, err error) (int, interface{}) {
+		status, body := f(ctx, err)
+		rerr, ok := body.(*RemoteError)
+		if !ok || rerr == nil || rerr.Code == "" {
+			return status, body
+		}
+		req := RequestFromContext(ctx)
+		if req == nil {
+			return status, body
+		}
+		msg, ok := catalog.Message(rerr.Code, req.Header.Get("Accept-Language"))
+		if !ok {
+			return status, body
+		}
+		// Copy the error so that we don't change
+		// a value that might be shared.
+		rerr1 := *rerr
+		rerr1.Message = msg
+		return status, &rerr1
+	}
+}
+
+// languageRange holds a language range from an
+// Accept-Language header with its quality value.
+type languageRange struct {
+	lang string
+	q    float64
+}
+
+// languageRanges returns the language ranges in the given
+// Accept-Language header value in order of preference. Ranges with
+// a quality value of zero and the wildcard range "*" are omitted.
+func languageRanges(acceptLanguage string) []string {
+	var ranges []languageRange
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.TrimSpace(fields[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if v, err := strconv.ParseFloat(param[len("q="):], 64); err == nil {
+				q = v
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		ranges = append(ranges, languageRange{lang, q})
+	}
+	sort.Stable(byQuality(ranges))
+	langs := make([]string, len(ranges))
+	for i, r := range ranges {
+		langs[i] = r.lang
+	}
+	return langs
+}
+
+type byQuality []languageRange
+
+func (r byQuality) Len() int {
+	return len(r)
+}
+
+func (r byQuality) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r byQuality) Less(i, j int) bool {
+	return r[i].q > r[j].q
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type localizeSuite struct{}
+
+var _ = gc.Suite(&localizeSuite{})
+
+var testCatalog = httprequest.MessageCatalog{
+	"unauthorized": {
+		"fr":    "non autorisé",
+		"pt":    "não autorizado",
+		"en-GB": "unauthorised",
+	},
+}
+
+var messageTests = []struct {
+	about          string
+	code           string
+	acceptLanguage string
+	expectMessage  string
+	expectOK       bool
+}{{
+	about:          "exact match",
+	code:           "unauthorized",
+	acceptLanguage: "fr",
+	expectMessage:  "non autorisé",
+	expectOK:       true,
+}, {
+	about:          "match ignoring case",
+	code:           "unauthorized",
+	acceptLanguage: "EN-gb",
+	expectMessage:  "unauthorised",
+	expectOK:       true,
+}, {
+	about:          "truncated range",
+	code:           "unauthorized",
+	acceptLanguage: "pt-BR",
+	expectMessage:  "não autorizado",
+	expectOK:       true,
+}, {
+	about:          "quality values",
+	code:           "unauthorized",
+	acceptLanguage: "fr;q=0.5, de, pt;q=0.8",
+	expectMessage:  "não autorizado",
+	expectOK:       true,
+}, {
+	about:          "zero quality value",
+	code:           "unauthorized",
+	acceptLanguage: "fr;q=0",
+}, {
+	about:          "no matching language",
+	code:           "unauthorized",
+	acceptLanguage: "de, en, *",
+}, {
+	about:          "unknown code",
+	code:           "bad request",
+	acceptLanguage: "fr",
+}, {
+	about: "no header",
+	code:  "unauthorized",
+}}
+
+func (*localizeSuite) TestMessage(c *gc.C) {
+	for i, test := range messageTests {
+		c.Logf("test %d: %s", i, test.about)
+		msg, ok := testCatalog.Message(test.code, test.acceptLanguage)
+		c.Assert(ok, gc.Equals, test.expectOK)
+		c.Assert(msg, gc.Equals, test.expectMessage)
+	}
+}
+
+func (*localizeSuite) TestRequestFromContext(c *gc.C) {
+	var got *http.Request
+	handler := testServer.HandleErrors(func(p httprequest.Params) error {
+		got = httprequest.RequestFromContext(p.Context)
+		return nil
+	})
+	req, err := http.NewRequest("GET", "/x", nil)
+	c.Assert(err, gc.IsNil)
+	handler(httptest.NewRecorder(), req, nil)
+	c.Assert(got, gc.Equals, req)
+
+	c.Assert(httprequest.RequestFromContext(context.Background()), gc.IsNil)
+}
+
+var localizedErrorMapperTests = []struct {
+	about          string
+	err            error
+	acceptLanguage string
+	expectBody     string
+}{{
+	about:          "localized message",
+	err:            errgo.WithCausef(nil, errUnauth, "access denied"),
+	acceptLanguage: "fr-CA, en;q=0.5",
+	expectBody:     `{"Message":"non autorisé","Code":"unauthorized"}`,
+}, {
+	about:          "no matching language",
+	err:            errgo.WithCausef(nil, errUnauth, "access denied"),
+	acceptLanguage: "de",
+	expectBody:     `{"Message":"access denied","Code":"unauthorized"}`,
+}, {
+	about:      "no Accept-Language header",
+	err:        errgo.WithCausef(nil, errUnauth, "access denied"),
+	expectBody: `{"Message":"access denied","Code":"unauthorized"}`,
+}, {
+	about:          "no code",
+	err:            errgo.New("something went wrong"),
+	acceptLanguage: "fr",
+	expectBody:     `{"Message":"something went wrong"}`,
+}}
+
+func (*localizeSuite) TestLocalizedErrorMapper(c *gc.C) {
+	srv := httprequest.Server{
+		ErrorMapper: httprequest.LocalizedErrorMapper(testCatalog, httprequest.RemoteErrorMapper(func(_ context.Context, err error) (int, string) {
+			if errgo.Cause(err) == errUnauth {
+				return http.StatusUnauthorized, "unauthorized"
+			}
+			return http.StatusInternalServerError, ""
+		})),
+	}
+	for i, test := range localizedErrorMapperTests {
+		c.Logf("test %d: %s", i, test.about)
+		err := test.err
+		handler := srv.HandleErrors(func(p httprequest.Params) error {
+			return err
+		})
+		req, err1 := http.NewRequest("GET", "/x", nil)
+		c.Assert(err1, gc.IsNil)
+		if test.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", test.acceptLanguage)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req, nil)
+		c.Assert(rec.Body.String(), gc.Equals, test.expectBody)
+	}
+}
+
+func (*localizeSuite) TestLocalizedErrorMapperWithoutRequest(c *gc.C) {
+	mapper := httprequest.LocalizedErrorMapper(testCatalog, func(context.Context, error) (int, interface{}) {
+		return http.StatusUnauthorized, &httprequest.RemoteError{
+			Message: "access denied",
+			Code:    "unauthorized",
+		}
+	})
+	status, body := mapper(context.Background(), errgo.New("access denied"))
+	c.Assert(status, gc.Equals, http.StatusUnauthorized)
+	c.Assert(body, gc.DeepEquals, &httprequest.RemoteError{
+		Message: "access denied",
+		Code:    "unauthorized",
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
@@ -37,15 +38,79 @@ var emptyReader = bytes.NewReader(nil)
 // If a field in baseURL is a suffix of the form "*var" (a trailing wildcard element
 // that holds the rest of the path), the marshaled string must begin with a "/".
 // This matches the httprouter convention that it always returns such fields
-// with a "/" prefix.
+// with a "/" prefix. If the field has an "addslash" attribute, a "/" is
+// instead added to the start of a marshaled string that does not already
+// begin with one, which is convenient when the value is produced by
+// a type's MarshalText method. For example:
+//
+//	Name PathName `httprequest:"name,path,addslash"`
+//
+// The attribute is intended only for "*var" fields and has no effect
+// on unmarshaling, so the unmarshaled value still begins with "/".
+//
+// Path parameter values are percent-encoded in the resulting URL, so a
+// value containing a "/" or "?" will remain within its path segment.
+// This does not apply to the slashes in a trailing wildcard element.
 //
 // If a field is of type string or []string, the value of the field will
 // be used directly; otherwise if implements encoding.TextMarshaler, that
 // will be used to marshal the field, otherwise fmt.Sprint will be used.
+// Each element of any other slice type in a form or header field is
+// marshaled in the same way as a separate value.
+//
+// Header fields are set using the tag name as given, so a field tagged
+// with `httprequest:"X-Request-ID,header"` will be sent as the
+// X-Request-ID header (stored under its canonical key X-Request-Id).
+//
+// If a field tag holds several names separated by "|", only
+// the first is used.
+//
+// The values in an "allform" field are added to the URL query
+// along with any values from "form" fields. A "method" field is
+// ignored; the request method is taken from the Route field.
+//
+// The value of a "rawquery" field is added to the URL query exactly
+// as it is, without any encoding. It is an error to marshal a value
+// that has both a rawquery field and any form or allform fields.
+//
+// The value of a "host" field, if it is not empty, is used as the
+// request's Host (see http.Request.Host), which is sent as the Host
+// header in place of the host in the URL. This makes it possible to
+// address a particular virtual host on a server.
+//
+// The values of "basicuser" and "basicpass" fields are sent as the
+// user name and password of the request's HTTP Basic Authentication
+// credentials (see http.Request.SetBasicAuth). No Authorization
+// header is set if both are empty.
+//
+// A body field is marshaled as JSON with the content type
+// application/json, unless the field has an "httpcontenttype" tag,
+// in which case its value is used as the content type instead. For
+// example:
+//
+//	Body Foo `httprequest:",body" httpcontenttype:"application/vnd.foo+json"`
+//
+// A body field with a "yaml" attribute is marshaled as YAML with
+// the content type application/yaml instead (again unless it has an
+// "httpcontenttype" tag). A body field of a type registered with
+// RegisterBodyCodec is marshaled with that codec rather than as JSON.
+//
+// A body is marshaled whatever the request method, including GET,
+// because although unusual, some servers expect a body with such
+// requests. A nil pointer body field produces no body. See
+// Client.StrictBodyMethods for a way to restrict bodies to the
+// methods that conventionally have them.
 //
 // An "omitempty" attribute on a form or header field specifies that
 // if the form or header value is empty, the form or header entry
-// will be omitted.
+// will be omitted. A header field with an "omitempty" attribute
+// will also be omitted if it holds the zero value of a numeric or
+// boolean type, so, for example, a zero int header field is not
+// sent as "0".
+//
+// If x implements RequestMarshaler, its MarshalRequest method will
+// be called after all the fields have been marshaled, allowing it to
+// make arbitrary changes to the request.
 //
 // For example, this code:
 //
@@ -74,6 +139,24 @@ var emptyReader = bytes.NewReader(nil)
 // http://example.com/users/bob/details?context=1234 and a JSON-encoded
 // body holding `{"Age":36}`.
 //
+// Any query parameters in baseURL are preserved, and the form
+// fields in x are added to them. A form field replaces any parameter
+// in baseURL with the same name, so baseURL can be used to provide
+// default values for fields that are omitted.
+//
+// The resulting URL query is deterministic, so that it is suitable
+// for uses such as request signing and caching: the parameters from
+// baseURL come first, in their original order, followed by the form
+// values sorted by name, as for url.Values.Encode. The values of a
+// slice or allform field with several values for the same name are
+// kept in their original order. The value of any rawquery field
+// comes last.
+//
+// A matrix field is added to the path segment of its path parameter,
+// so a field tagged `httprequest:"version,matrix" httpmatrix:"thing"`
+// with the value 2 and a thing field with the value "x" will produce
+// the segment "x;version=2".
+//
 // It is an error if there is a field specified in the URL that is not
 // found in x.
 func Marshal(baseURL, method string, x interface{}) (*http.Request, error) {
@@ -101,11 +184,32 @@ func Marshal(baseURL, method string, x interface{}) (*http.Request, error) {
 	if headerSetter, ok := x.(HeaderSetter); ok {
 		headerSetter.SetHeader(p.Request.Header)
 	}
+	if m, ok := x.(RequestMarshaler); ok {
+		if err := m.MarshalRequest(p.Request); err != nil {
+			return nil, errgo.NoteMask(err, "cannot marshal request", errgo.Any)
+		}
+	}
 	return p.Request, nil
 }
 
+// RequestMarshaler may be implemented by a value passed to Marshal to
+// customize the resulting HTTP request. After the request has been
+// built from the value's fields, and after any HeaderSetter.SetHeader
+// call, the MarshalRequest method will be called with the request.
+// It may modify the request as it wishes; for example, it may
+// add a header holding a signature over the request.
+//
+// Any error returned by MarshalRequest is returned from
+// Marshal with its cause preserved.
+type RequestMarshaler interface {
+	MarshalRequest(req *http.Request) error
+}
+
 // marshal is the internal version of Marshal.
 func marshal(p *Params, xv reflect.Value, pt *requestType) error {
+	if pt.hasForm && pt.hasRawQuery {
+		return errgo.New("cannot marshal both form and rawquery fields")
+	}
 	xv = xv.Elem()
 	for _, f := range pt.fields {
 		fv := xv.FieldByIndex(f.index)
@@ -121,51 +225,129 @@ func marshal(p *Params, xv reflect.Value, pt *requestType) error {
 			return errgo.WithCausef(err, ErrUnmarshal, "cannot marshal field")
 		}
 	}
-	path, err := buildPath(p.Request.URL.Path, p.PathVar)
+	// Work from the escaped path so that any escaped characters
+	// in the base URL, such as escaped slashes, are preserved.
+	path, rawPath, err := buildPath(p.Request.URL.EscapedPath(), p.PathVar)
 	if err != nil {
 		return errgo.Mask(err)
 	}
 	p.Request.URL.Path = path
-	if q := p.Request.Form.Encode(); q != "" && p.Request.URL.RawQuery != "" {
-		p.Request.URL.RawQuery += "&" + q
-	} else {
-		p.Request.URL.RawQuery += q
-	}
+	p.Request.URL.RawPath = rawPath
+	p.Request.URL.RawQuery = mergeQuery(p.Request.URL.RawQuery, p.Request.Form)
 	return nil
 }
 
-func buildPath(path string, p httprouter.Params) (string, error) {
+// mergeQuery returns the result of adding the given form values to the
+// given raw URL query. Any parameters in the query that have the same
+// name as a form value are removed; the rest are left as they are.
+func mergeQuery(rawQuery string, form url.Values) string {
+	q := form.Encode()
+	if q == "" {
+		return rawQuery
+	}
+	var parts []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		name := part
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		if name, err := url.QueryUnescape(name); err == nil {
+			if _, ok := form[name]; ok {
+				continue
+			}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(append(parts, q), "&")
+}
+
+// buildPath substitutes the path parameters in p into the given
+// escaped path pattern. It returns the resulting path and its encoded
+// form.
+//
+// In the encoded form, the values of ":" parameters are
+// percent-encoded so that any slashes they contain remain part of the
+// same path segment; the values of "*" parameters may legitimately
+// contain slashes, so those are left as path separators.
+func buildPath(path string, p httprouter.Params) (string, string, error) {
 	pathBytes := make([]byte, 0, len(path)*2)
+	rawPathBytes := make([]byte, 0, len(path)*2)
 	for {
 		s, rest := nextPathSegment(path)
 		if s == "" {
 			break
 		}
 		if s[0] != ':' && s[0] != '*' {
-			pathBytes = append(pathBytes, s...)
+			unescaped, err := url.PathUnescape(s)
+			if err != nil {
+				return "", "", errgo.Mask(err)
+			}
+			pathBytes = append(pathBytes, unescaped...)
+			rawPathBytes = append(rawPathBytes, s...)
 			path = rest
 			continue
 		}
 		if s[0] == '*' && rest != "" {
-			return "", errgo.New("star path parameter is not at end of path")
+			return "", "", errgo.New("star path parameter is not at end of path")
 		}
 		if len(s) == 1 {
-			return "", errgo.New("empty path parameter")
+			return "", "", errgo.New("empty path parameter")
 		}
 		val := p.ByName(s[1:])
 		if val == "" {
-			return "", errgo.Newf("missing value for path parameter %q", s[1:])
+			return "", "", errgo.Newf("missing value for path parameter %q", s[1:])
+		}
+		val, err := addMatrixParams(val, s[1:], p)
+		if err != nil {
+			return "", "", errgo.Mask(err)
 		}
+		rawVal := escapePath(val)
 		if s[0] == '*' {
 			if !strings.HasPrefix(val, "/") {
-				return "", errgo.Newf("value %q for path parameter %q does not start with required /", val, s)
+				return "", "", errgo.Newf("value %q for path parameter %q does not start with required /", val, s)
 			}
 			val = val[1:]
+			rawVal = rawVal[1:]
+		} else {
+			rawVal = strings.Replace(rawVal, "/", "%2F", -1)
 		}
 		pathBytes = append(pathBytes, val...)
+		rawPathBytes = append(rawPathBytes, rawVal...)
 		path = rest
 	}
-	return string(pathBytes), nil
+	return string(pathBytes), string(rawPathBytes), nil
+}
+
+// addMatrixParams returns val with any matrix parameters for the
+// given path parameter in p appended, separated by semicolons.
+func addMatrixParams(val string, pathParam string, p httprouter.Params) (string, error) {
+	prefix := pathParam + ";"
+	hasMatrix := false
+	for _, pv := range p {
+		if !strings.HasPrefix(pv.Key, prefix) {
+			continue
+		}
+		if !hasMatrix && strings.Contains(val, ";") {
+			return "", errgo.Newf("value %q for path parameter %q with matrix parameters contains ';'", val, pathParam)
+		}
+		hasMatrix = true
+		name := pv.Key[len(prefix):]
+		if strings.Contains(pv.Value, ";") {
+			return "", errgo.Newf("value %q for matrix parameter %q contains ';'", pv.Value, name)
+		}
+		val += ";" + name + "=" + pv.Value
+	}
+	return val, nil
+}
+
+// escapePath returns s escaped so that it can be
+// used in a URL path. Slashes are not escaped.
+func escapePath(s string) string {
+	u := url.URL{Path: s}
+	return u.EscapedPath()
 }
 
 // nextPathSegment returns the next wildcard or constant
@@ -191,10 +373,39 @@ func nextPathSegment(s string) (string, string) {
 // a field with the given tag into and http request.
 func getMarshaler(tag tag, t reflect.Type) (marshaler, error) {
 	switch {
-	case tag.source == sourceNone:
+	case tag.source == sourceNone, tag.source == sourceMethod:
 		return marshalNop, nil
+	case tag.source == sourceRawQuery:
+		if t.Kind() != reflect.String {
+			return nil, errgo.Newf("invalid target type %s for rawquery field; need string", t)
+		}
+		return marshalRawQuery, nil
+	case tag.source == sourceHost:
+		if t.Kind() != reflect.String {
+			return nil, errgo.Newf("invalid target type %s for host field; need string", t)
+		}
+		return marshalHost, nil
+	case tag.source == sourceBasicUser, tag.source == sourceBasicPass:
+		if t.Kind() != reflect.String {
+			return nil, errgo.Newf("invalid target type %s for basic auth field; need string", t)
+		}
+		return marshalBasicAuth(tag.source == sourceBasicUser), nil
+	case tag.source == sourceBody && t == jsonDecoderType:
+		return marshalStreamingBody, nil
+	case tag.source == sourceBody && tag.yaml:
+		return marshalYAMLBody(tag.contentType), nil
 	case tag.source == sourceBody:
-		return marshalBody, nil
+		return marshalBody(tag.contentType), nil
+	case tag.source == sourceAllForm:
+		if t != reflect.TypeOf(url.Values(nil)) {
+			return nil, errgo.Newf("invalid target type %s for allform field; need url.Values", t)
+		}
+		return marshalAllForm, nil
+	case tag.base != 0:
+		if !isIntegerKind(t.Kind()) {
+			return nil, errgo.Newf("httpbase cannot be used on type %s", t)
+		}
+		return marshalWithBase(t, tag), nil
 	case t == reflect.TypeOf([]string(nil)):
 		switch tag.source {
 		default:
@@ -208,6 +419,11 @@ func getMarshaler(tag tag, t reflect.Type) (marshaler, error) {
 		return marshalString(tag), nil
 	case implementsTextMarshaler(t):
 		return marshalWithMarshalText(t, tag), nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+		if tag.source != sourceForm && tag.source != sourceHeader {
+			return nil, errgo.Newf("invalid target type %s for path parameter", t)
+		}
+		return marshalSlice(t, tag), nil
 	default:
 		return marshalWithSprint(tag), nil
 	}
@@ -218,16 +434,91 @@ func marshalNop(v reflect.Value, p *Params) error {
 	return nil
 }
 
-// mashalBody marshals the specified value into the body of the http request.
-func marshalBody(v reflect.Value, p *Params) error {
-	// TODO allow body types that aren't necessarily JSON.
-	data, err := json.Marshal(v.Addr().Interface())
-	if err != nil {
-		return errgo.Notef(err, "cannot marshal request body")
+// marshalRawQuery adds the value of a rawquery field
+// to the request URL query without any encoding.
+func marshalRawQuery(v reflect.Value, p *Params) error {
+	q := v.String()
+	if q == "" {
+		return nil
+	}
+	if p.Request.URL.RawQuery != "" {
+		p.Request.URL.RawQuery += "&" + q
+	} else {
+		p.Request.URL.RawQuery = q
+	}
+	return nil
+}
+
+// mashalBody returns a marshaler that marshals the specified value as
+// JSON into the body of the http request, with the given content type.
+// If contentType is empty, application/json is used. If a codec has
+// been registered for the type of the value with RegisterBodyCodec,
+// it is used instead of JSON.
+func marshalBody(contentType string) marshaler {
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return func(v reflect.Value, p *Params) error {
+		var data []byte
+		var err error
+		if codec := getBodyCodec(v.Type()); codec != nil {
+			data, err = codec.MarshalBody(v.Addr().Interface())
+		} else {
+			data, err = json.Marshal(v.Addr().Interface())
+		}
+		if err != nil {
+			return errgo.Notef(err, "cannot marshal request body")
+		}
+		p.Request.Body = BytesReaderCloser{bytes.NewReader(data)}
+		p.Request.ContentLength = int64(len(data))
+		p.Request.Header.Set("Content-Type", contentType)
+		return nil
+	}
+}
+
+// marshalHost sets the host that the request is addressed
+// to from a string field. Nothing is set if the field is empty.
+func marshalHost(v reflect.Value, p *Params) error {
+	if s := v.String(); s != "" {
+		p.Request.Host = s
+	}
+	return nil
+}
+
+// marshalBasicAuth returns a marshaler that sets the user name (if
+// user is true) or password of the request's basic authentication
+// credentials from a string field, keeping the other part of the
+// credentials. Nothing is set if the field is empty and the request
+// has no credentials yet.
+func marshalBasicAuth(user bool) marshaler {
+	return func(v reflect.Value, p *Params) error {
+		s := v.String()
+		username, password, ok := p.Request.BasicAuth()
+		if s == "" && !ok {
+			return nil
+		}
+		if user {
+			username = s
+		} else {
+			password = s
+		}
+		p.Request.SetBasicAuth(username, password)
+		return nil
+	}
+}
+
+// marshalStreamingBody is the marshaler for a *json.Decoder
+// body field, which can only be unmarshaled.
+func marshalStreamingBody(v reflect.Value, p *Params) error {
+	return errgo.New("cannot marshal *json.Decoder body field")
+}
+
+// marshalAllForm adds all the values in a url.Values
+// field to the request form.
+func marshalAllForm(v reflect.Value, p *Params) error {
+	for k, vs := range v.Interface().(url.Values) {
+		p.Request.Form[k] = append(p.Request.Form[k], vs...)
 	}
-	p.Request.Body = BytesReaderCloser{bytes.NewReader(data)}
-	p.Request.ContentLength = int64(len(data))
-	p.Request.Header.Set("Content-Type", "application/json")
 	return nil
 }
 
@@ -243,6 +534,7 @@ func marshalAllField(name string) marshaler {
 
 // marshalAllHeader marshals a []string slice into a header.
 func marshalAllHeader(name string) marshaler {
+	name = http.CanonicalHeaderKey(name)
 	return func(v reflect.Value, p *Params) error {
 		if ss := v.Interface().([]string); len(ss) > 0 {
 			p.Request.Header[name] = ss
@@ -251,6 +543,54 @@ func marshalAllHeader(name string) marshaler {
 	}
 }
 
+// marshalSlice marshals each element of a slice into
+// a separate form or header value. The elements may
+// be pointers, in which case they must not be nil.
+func marshalSlice(t reflect.Type, tag tag) marshaler {
+	name := tag.name
+	if tag.source == sourceHeader {
+		name = http.CanonicalHeaderKey(name)
+	}
+	elemType := t.Elem()
+	elemIsPointer := elemType.Kind() == reflect.Ptr
+	if elemIsPointer {
+		elemType = elemType.Elem()
+	}
+	return func(v reflect.Value, p *Params) error {
+		if v.Len() == 0 {
+			return nil
+		}
+		vals := make([]string, v.Len())
+		for i := range vals {
+			ev := v.Index(i)
+			if elemIsPointer {
+				if ev.IsNil() {
+					return errgo.Newf("nil element %d in %s", i, tag.describe())
+				}
+				ev = ev.Elem()
+			}
+			switch {
+			case implementsTextMarshaler(elemType):
+				data, err := ev.Addr().Interface().(encodingTextMarshaler).MarshalText()
+				if err != nil {
+					return errgo.Mask(err)
+				}
+				vals[i] = string(data)
+			case elemType.Kind() == reflect.String:
+				vals[i] = ev.String()
+			default:
+				vals[i] = formatValue(ev)
+			}
+		}
+		if tag.source == sourceHeader {
+			p.Request.Header[name] = vals
+		} else {
+			p.Request.Form[name] = vals
+		}
+		return nil
+	}
+}
+
 // marshalString marshals s string field.
 func marshalString(tag tag) marshaler {
 	formSet := formSetter(tag)
@@ -294,22 +634,100 @@ func marshalWithMarshalText(t reflect.Type, tag tag) marshaler {
 }
 
 // marshalWithSprint returns an marshaler
-// that unmarshals the given tag using fmt.Sprint.
+// that marshals the given tag using formatValue.
+// A header field with omitempty is omitted if
+// its value is the zero value of its type.
 func marshalWithSprint(tag tag) marshaler {
 	formSet := formSetter(tag)
+	omitZero := tag.omitempty && tag.source == sourceHeader
 	return func(v reflect.Value, p *Params) error {
-		formSet(tag.name, fmt.Sprint(v.Interface()), p)
+		if omitZero && isZeroValue(v) {
+			return nil
+		}
+		formSet(tag.name, formatValue(v), p)
 		return nil
 	}
 }
 
+var (
+	stringerType  = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	formatterType = reflect.TypeOf((*fmt.Formatter)(nil)).Elem()
+)
+
+// formatValue returns v formatted with fmt.Sprint, except that a
+// floating point value is formatted without an exponent, so that, for
+// example, 1e21 is formatted as "1000000000000000000000", unless its
+// type has its own String or Format method.
+func formatValue(v reflect.Value) string {
+	switch t := v.Type(); t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if !t.Implements(stringerType) && !t.Implements(formatterType) {
+			return strconv.FormatFloat(v.Float(), 'f', -1, t.Bits())
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// marshalWithBase returns a marshaler that marshals an
+// integer of type t in the numeric base specified by the tag.
+// As with marshalWithSprint, a header field with omitempty
+// is omitted if its value is zero.
+func marshalWithBase(t reflect.Type, tag tag) marshaler {
+	formSet := formSetter(tag)
+	omitZero := tag.omitempty && tag.source == sourceHeader
+	signed := t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64
+	return func(v reflect.Value, p *Params) error {
+		if omitZero && isZeroValue(v) {
+			return nil
+		}
+		if signed {
+			formSet(tag.name, strconv.FormatInt(v.Int(), tag.base), p)
+		} else {
+			formSet(tag.name, strconv.FormatUint(v.Uint(), tag.base), p)
+		}
+		return nil
+	}
+}
+
+// isZeroValue reports whether v holds the zero value
+// of a basic type.
+func isZeroValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.String:
+		return v.Len() == 0
+	}
+	return false
+}
+
 // formSetter returns a function that can set the value
 // for a given tag.
 func formSetter(t tag) func(name, value string, p *Params) {
-	formSet := formSetters[t.source]
+	var formSet func(name, value string, p *Params)
+	switch {
+	case t.source == sourceMatrix:
+		formSet = matrixSetter(t.matrixParam)
+	case int(t.source) < len(formSetters):
+		formSet = formSetters[t.source]
+	}
 	if formSet == nil {
 		panic("unexpected source")
 	}
+	if t.addSlash {
+		return func(name, value string, p *Params) {
+			if !strings.HasPrefix(value, "/") {
+				value = "/" + value
+			}
+			formSet(name, value, p)
+		}
+	}
 	if !t.omitempty {
 		return formSet
 	}
@@ -335,6 +753,19 @@ var formSetters = []func(string, string, *Params){
 	},
 }
 
+// matrixSetter returns a function that sets the value of a matrix
+// parameter in the segment of the given path parameter. The matrix
+// parameter is recorded in p.PathVar with a key of the form
+// "pathparam;name" so that buildPath can add it to the segment.
+func matrixSetter(pathParam string) func(name, value string, p *Params) {
+	return func(name, value string, p *Params) {
+		p.PathVar = append(p.PathVar, httprouter.Param{
+			Key:   pathParam + ";" + name,
+			Value: value,
+		})
+	}
+}
+
 // BytesReaderCloser is a bytes.Reader which
 // implements io.Closer with a no-op Close method.
 type BytesReaderCloser struct {
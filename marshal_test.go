@@ -4,9 +4,17 @@
 package httprequest_test
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
 
+	jc "github.com/juju/testing/checkers"
+	"github.com/julienschmidt/httprouter"
 	gc "gopkg.in/check.v1"
 	"gopkg.in/errgo.v1"
 
@@ -31,6 +39,7 @@ var marshalTests = []struct {
 	expectURLString string
 	expectBody      *string
 	expectHeader    http.Header
+	expectHost      string
 	expectError     string
 }{{
 	about:     "struct with simple fields",
@@ -287,6 +296,324 @@ var marshalTests = []struct {
 		F1: "test",
 	},
 	expectError: `value \"test\" for path parameter \"\*name\" does not start with required /`,
+}, {
+	about:     "marshal to path with * placeholder and addslash",
+	urlString: "http://localhost:8081/u/*name",
+	val: &struct {
+		F1 testMarshaler `httprequest:"name,path,addslash"`
+	}{
+		F1: "a/b",
+	},
+	expectURLString: "http://localhost:8081/u/test_a/b",
+}, {
+	about:     "addslash with a value that already starts with /",
+	urlString: "http://localhost:8081/u/*name",
+	val: &struct {
+		F1 string `httprequest:"name,path,addslash"`
+	}{
+		F1: "/a/b",
+	},
+	expectURLString: "http://localhost:8081/u/a/b",
+}, {
+	about:     "unsigned and floating point fields",
+	urlString: "http://localhost:8081/u/:u",
+	val: &struct {
+		U   uint64    `httprequest:"u,path"`
+		F32 float32   `httprequest:"f32,form"`
+		F64 float64   `httprequest:"f64,form"`
+		Fs  []float64 `httprequest:"fs,form"`
+		H   float64   `httprequest:"X-H,header"`
+	}{
+		U:   18446744073709551615,
+		F32: 0.1,
+		F64: 1e21,
+		Fs:  []float64{1e-7, 2.5},
+		H:   123456789,
+	},
+	expectURLString: "http://localhost:8081/u/18446744073709551615?f32=0.1&f64=1000000000000000000000&fs=0.0000001&fs=2.5",
+	expectHeader: http.Header{
+		"X-H": {"123456789"},
+	},
+}, {
+	about:     "duration fields",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		Timeout time.Duration   `httprequest:"timeout,form"`
+		Ds      []time.Duration `httprequest:"ds,form"`
+	}{
+		Timeout: 90 * time.Second,
+		Ds:      []time.Duration{time.Hour, 250 * time.Millisecond},
+	},
+	expectURLString: "http://localhost:8081/u?ds=1h0m0s&ds=250ms&timeout=1m30s",
+}, {
+	about:     "readonly fields are marshaled",
+	urlString: "http://localhost:8081/u/:id",
+	val: &struct {
+		ID      string `httprequest:"id,path,readonly"`
+		Created int    `httprequest:"created,form,readonly"`
+	}{
+		ID:      "99",
+		Created: 1234,
+	},
+	expectURLString: "http://localhost:8081/u/99?created=1234",
+}, {
+	about:     "matrix parameters",
+	urlString: "http://localhost:8081/u/:thing/sub",
+	val: &struct {
+		Thing   string  `httprequest:"thing,path"`
+		Version int     `httprequest:"version,matrix" httpmatrix:"thing"`
+		Lang    *string `httprequest:"lang,matrix" httpmatrix:"thing"`
+		Name    string  `httprequest:"name,matrix" httpmatrix:"thing"`
+	}{
+		Thing:   "a b",
+		Version: 2,
+		Name:    "x/y",
+	},
+	expectURLString: "http://localhost:8081/u/a%20b;version=2;name=x%2Fy/sub",
+}, {
+	about:     "matrix parameter with semicolon",
+	urlString: "http://localhost:8081/u/:thing",
+	val: &struct {
+		Thing string `httprequest:"thing,path"`
+		Name  string `httprequest:"name,matrix" httpmatrix:"thing"`
+	}{
+		Thing: "a",
+		Name:  "x;y",
+	},
+	expectError: `value "x;y" for matrix parameter "name" contains ';'`,
+}, {
+	about:     "path parameter with semicolon and matrix parameters",
+	urlString: "http://localhost:8081/u/:thing",
+	val: &struct {
+		Thing string `httprequest:"thing,path"`
+		Name  string `httprequest:"name,matrix" httpmatrix:"thing"`
+	}{
+		Thing: "a;b",
+		Name:  "x",
+	},
+	expectError: `value "a;b" for path parameter "thing" with matrix parameters contains ';'`,
+}, {
+	about:     "path parameter with special characters",
+	urlString: "http://localhost:8081/u/:name/x",
+	val: &struct {
+		F1 string `httprequest:"name,path"`
+	}{
+		F1: "a/b?c d#e%f",
+	},
+	expectURLString: "http://localhost:8081/u/a%2Fb%3Fc%20d%23e%25f/x",
+}, {
+	about:     "* placeholder with special characters",
+	urlString: "http://localhost:8081/u/*name",
+	val: &struct {
+		F1 string `httprequest:"name,path"`
+	}{
+		F1: "/a/b?c d",
+	},
+	expectURLString: "http://localhost:8081/u/a/b%3Fc%20d",
+}, {
+	about:     "anonymous struct field with marker tag",
+	urlString: "http://localhost:8081/u/:id",
+	val: &struct {
+		CommonParams     `httprequest:",form"`
+		*PtrCommonParams `httprequest:",path"`
+	}{
+		CommonParams: CommonParams{
+			Limit: 10,
+			Token: "tok",
+		},
+		PtrCommonParams: &PtrCommonParams{
+			ID: "id1",
+		},
+	},
+	expectURLString: "http://localhost:8081/u/id1?limit=10",
+	expectHeader: http.Header{
+		"Token": {"tok"},
+	},
+}, {
+	about:     "allform field",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		A   int        `httprequest:"a,form"`
+		All url.Values `httprequest:",allform"`
+	}{
+		A: 1,
+		All: url.Values{
+			"a": {"2"},
+			"b": {"3", "4"},
+		},
+	},
+	expectURLString: "http://localhost:8081/u?a=1&a=2&b=3&b=4",
+}, {
+	about:     "method field is ignored",
+	urlString: "http://localhost:8081/u",
+	method:    "PUT",
+	val: &struct {
+		M string `httprequest:",method"`
+		A int    `httprequest:"a,form"`
+	}{
+		M: "DELETE",
+		A: 1,
+	},
+	expectURLString: "http://localhost:8081/u?a=1",
+}, {
+	about:     "rawquery field",
+	urlString: "http://localhost:8081/u?x=1",
+	val: &struct {
+		Q string `httprequest:",rawquery"`
+		H string `httprequest:"h,header"`
+	}{
+		Q: "b=2&a=%2f+",
+		H: "v",
+	},
+	expectURLString: "http://localhost:8081/u?x=1&b=2&a=%2f+",
+}, {
+	about:     "empty rawquery field",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		Q string `httprequest:",rawquery"`
+	}{},
+	expectURLString: "http://localhost:8081/u",
+}, {
+	about:     "rawquery field with form field",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		Q string `httprequest:",rawquery"`
+		A int    `httprequest:"a,form"`
+	}{
+		Q: "b=2",
+		A: 1,
+	},
+	expectError: `cannot marshal both form and rawquery fields`,
+}, {
+	about:     "rawquery field with wrong type",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		Q []byte `httprequest:",rawquery"`
+	}{},
+	expectError: `bad type .*: invalid target type \[\]uint8 for rawquery field; need string`,
+}, {
+	about:     "host field",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		H string `httprequest:",host"`
+		A int    `httprequest:"a,form"`
+	}{
+		H: "tenant1.example.com",
+		A: 1,
+	},
+	expectURLString: "http://localhost:8081/u?a=1",
+	expectHost:      "tenant1.example.com",
+}, {
+	about:     "empty host field",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		H string `httprequest:",host"`
+	}{},
+	expectURLString: "http://localhost:8081/u",
+	expectHost:      "localhost:8081",
+}, {
+	about:     "host field with wrong type",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		H []byte `httprequest:",host"`
+	}{},
+	expectError: `bad type .*: invalid target type \[\]uint8 for host field; need string`,
+}, {
+	about:     "body with custom content type",
+	urlString: "http://localhost:8081/u",
+	method:    "POST",
+	val: &struct {
+		B sFG `httprequest:",body" httpcontenttype:"application/vnd.foo+json"`
+	}{
+		B: sFG{F: 1},
+	},
+	expectURLString: "http://localhost:8081/u",
+	expectHeader: http.Header{
+		"Content-Type": {"application/vnd.foo+json"},
+	},
+}, {
+	about:     "yaml body",
+	urlString: "http://localhost:8081/u",
+	method:    "PUT",
+	val: &struct {
+		B yamlConfig `httprequest:",body,yaml"`
+	}{
+		B: yamlConfig{Name: "test", Ports: []int{80, 443}},
+	},
+	expectURLString: "http://localhost:8081/u",
+	expectBody:      newString("name: test\nports:\n- 80\n- 443\n"),
+	expectHeader: http.Header{
+		"Content-Type": {"application/yaml"},
+	},
+}, {
+	about:     "yaml body with custom content type",
+	urlString: "http://localhost:8081/u",
+	method:    "PUT",
+	val: &struct {
+		B yamlConfig `httprequest:",body,yaml" httpcontenttype:"text/yaml"`
+	}{
+		B: yamlConfig{Name: "test"},
+	},
+	expectURLString: "http://localhost:8081/u",
+	expectBody:      newString("name: test\n"),
+	expectHeader: http.Header{
+		"Content-Type": {"text/yaml"},
+	},
+}, {
+	about:     "basic auth fields",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		User     string `httprequest:",basicuser"`
+		Password string `httprequest:",basicpass"`
+	}{
+		User:     "bob",
+		Password: "secret",
+	},
+	expectURLString: "http://localhost:8081/u",
+	expectHeader: http.Header{
+		"Authorization": {"Basic Ym9iOnNlY3JldA=="},
+	},
+}, {
+	about:     "basic auth password only",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		User     string `httprequest:",basicuser"`
+		Password string `httprequest:",basicpass"`
+	}{
+		Password: "secret",
+	},
+	expectURLString: "http://localhost:8081/u",
+	expectHeader: http.Header{
+		"Authorization": {"Basic OnNlY3JldA=="},
+	},
+}, {
+	about:     "empty basic auth fields",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		User     string `httprequest:",basicuser"`
+		Password string `httprequest:",basicpass"`
+	}{},
+	expectURLString: "http://localhost:8081/u",
+	expectHeader: http.Header{
+		"Authorization": nil,
+	},
+}, {
+	about:     "basic auth field with wrong type",
+	urlString: "http://localhost:8081/u",
+	val: &struct {
+		User int `httprequest:",basicuser"`
+	}{},
+	expectError: `bad type .*: invalid target type int for basic auth field; need string`,
+}, {
+	about:     "streaming body",
+	urlString: "http://localhost:8081/u",
+	method:    "POST",
+	val: &struct {
+		B *json.Decoder `httprequest:",body"`
+	}{
+		B: json.NewDecoder(strings.NewReader("{}")),
+	},
+	expectError: `cannot marshal field: cannot marshal \*json.Decoder body field`,
 }, {
 	about:     "* placeholder allowed only at the end",
 	urlString: "http://localhost:8081/u/*name/document",
@@ -325,6 +652,29 @@ var marshalTests = []struct {
 		F1: "test",
 	},
 	expectURLString: "http://localhost?a=b&f1=test",
+}, {
+	about:     "url with several query parameters",
+	urlString: "http://localhost/x?a=b&c=d%2F&e",
+	val: &struct {
+		F1 string `httprequest:"f1,form"`
+		F2 []int  `httprequest:"f2,form"`
+	}{
+		F1: "x y",
+		F2: []int{1, 2},
+	},
+	expectURLString: "http://localhost/x?a=b&c=d%2F&e&f1=x+y&f2=1&f2=2",
+}, {
+	about:     "url with query parameters overridden by form",
+	urlString: "http://localhost/x?a=b&limit=10&a%20b=1&limit=20&c=d",
+	val: &struct {
+		Limit int    `httprequest:"limit,form"`
+		AB    string `httprequest:"a b,form"`
+		E     string `httprequest:"c,form,omitempty"`
+	}{
+		Limit: 5,
+		AB:    "2",
+	},
+	expectURLString: "http://localhost/x?a=b&c=d&a+b=2&limit=5",
 }, {
 	about:           "url with query parameters no form",
 	urlString:       "http://localhost?a=b",
@@ -354,6 +704,34 @@ var marshalTests = []struct {
 		"F3": []string{"true"},
 		"F5": []string{"something"},
 	},
+}, {
+	about:     "struct with omitempty headers",
+	urlString: "http://localhost:8081/",
+	val: &struct {
+		H1 string   `httprequest:",header,omitempty"`
+		H2 int      `httprequest:",header,omitempty"`
+		H3 bool     `httprequest:",header,omitempty"`
+		H4 float64  `httprequest:",header,omitempty"`
+		H5 *int     `httprequest:",header,omitempty"`
+		H6 int      `httprequest:",header,omitempty"`
+		H7 *int     `httprequest:",header,omitempty"`
+		H8 textPair `httprequest:",header,omitempty"`
+		F1 int      `httprequest:",form,omitempty"`
+	}{
+		H6: 5,
+		H7: newInt(0),
+	},
+	expectURLString: "http://localhost:8081/?F1=0",
+	expectHeader: http.Header{
+		"H1": nil,
+		"H2": nil,
+		"H3": nil,
+		"H4": nil,
+		"H5": nil,
+		"H6": {"5"},
+		"H7": nil,
+		"H8": {"-"},
+	},
 }, {
 	about:     "struct with header slice",
 	urlString: "http://localhost:8081/:F1",
@@ -368,6 +746,21 @@ var marshalTests = []struct {
 	},
 	expectURLString: "http://localhost:8081/99?F2=some+text",
 	expectHeader:    http.Header{"F3": []string{"A", "B", "C"}},
+}, {
+	about:     "struct with non-canonical header names",
+	urlString: "http://localhost:8081/",
+	val: &struct {
+		F1 string   `httprequest:"X-Request-ID,header"`
+		F2 []string `httprequest:"x-multi-value,header"`
+	}{
+		F1: "1234",
+		F2: []string{"A", "B"},
+	},
+	expectURLString: "http://localhost:8081/",
+	expectHeader: http.Header{
+		"X-Request-Id":  []string{"1234"},
+		"X-Multi-Value": []string{"A", "B"},
+	},
 }, {
 	about:     "SetHeader called after marshaling",
 	urlString: "http://localhost:8081/",
@@ -391,6 +784,68 @@ var marshalTests = []struct {
 		"F2": []string{"some other text"},
 		"F3": []string{"false"},
 	},
+}, {
+	about:     "fields with alternative names",
+	urlString: "http://localhost:8081/:id",
+	val: &struct {
+		F1 int    `httprequest:"id|name,path"`
+		F2 int    `httprequest:"limit|per_page,form"`
+		F3 string `httprequest:"X-New|X-Old,header"`
+	}{
+		F1: 99,
+		F2: 20,
+		F3: "h val",
+	},
+	expectURLString: "http://localhost:8081/99?limit=20",
+	expectHeader: http.Header{
+		"X-New": []string{"h val"},
+		"X-Old": nil,
+	},
+}, {
+	about:     "fields with numeric base",
+	urlString: "http://localhost:8081/:id",
+	val: &struct {
+		ID uint64 `httprequest:"id,path" httpbase:"16"`
+		F  int    `httprequest:"f,form" httpbase:"2"`
+		H  int16  `httprequest:"X-H,header" httpbase:"16"`
+		Z  int16  `httprequest:"X-Z,header,omitempty" httpbase:"16"`
+	}{
+		ID: 0xdeadbeef,
+		F:  -6,
+		H:  0x7f,
+	},
+	expectURLString: "http://localhost:8081/deadbeef?f=-110",
+	expectHeader: http.Header{
+		"X-H": {"7f"},
+		"X-Z": nil,
+	},
+}, {
+	about:     "text marshaler slice fields",
+	urlString: "http://localhost:8081/",
+	val: &struct {
+		F1 []textPair  `httprequest:"f1,form"`
+		F2 *[]textPair `httprequest:"f2,form"`
+		F3 []*textPair `httprequest:"f3,form"`
+		H  []textPair  `httprequest:"X-Pairs,header"`
+	}{
+		F1: []textPair{{"a", "b"}, {"c", "d"}},
+		F2: &[]textPair{{"e", "f"}},
+		F3: []*textPair{{"g", "h"}, {"i", "j"}},
+		H:  []textPair{{"k", "l"}, {"m", "n"}},
+	},
+	expectURLString: "http://localhost:8081/?f1=a-b&f1=c-d&f2=e-f&f3=g-h&f3=i-j",
+	expectHeader: http.Header{
+		"X-Pairs": {"k-l", "m-n"},
+	},
+}, {
+	about:     "nil element in pointer slice field",
+	urlString: "http://localhost:8081/",
+	val: &struct {
+		F []*textPair `httprequest:"f,form"`
+	}{
+		F: []*textPair{{"a", "b"}, nil},
+	},
+	expectError: `cannot marshal field: nil element 1 in form field "f"`,
 }}
 
 func getStruct() interface{} {
@@ -420,7 +875,7 @@ func (*marshalSuite) TestMarshal(c *gc.C) {
 		if test.expectBody != nil {
 			data, err := ioutil.ReadAll(req.Body)
 			c.Assert(err, gc.IsNil)
-			if *test.expectBody != "" {
+			if *test.expectBody != "" && test.expectHeader.Get("Content-Type") == "" {
 				c.Assert(req.Header.Get("Content-Type"), gc.Equals, "application/json")
 			}
 			c.Assert(string(data), gc.DeepEquals, *test.expectBody)
@@ -428,9 +883,320 @@ func (*marshalSuite) TestMarshal(c *gc.C) {
 		for k, v := range test.expectHeader {
 			c.Assert(req.Header[k], gc.DeepEquals, v)
 		}
+		if test.expectHost != "" {
+			c.Assert(req.Host, gc.Equals, test.expectHost)
+		}
+	}
+}
+
+type deterministicQueryParams struct {
+	Zeta  string     `httprequest:"zeta,form"`
+	Alpha []string   `httprequest:"alpha,form"`
+	Extra url.Values `httprequest:",allform"`
+	Mid   int        `httprequest:"mid,form"`
+	Space string     `httprequest:"a b,form"`
+	Last  []int      `httprequest:"alpha2,form"`
+}
+
+func (*marshalSuite) TestMarshalQueryIsDeterministic(c *gc.C) {
+	val := &deterministicQueryParams{
+		Zeta:  "z",
+		Alpha: []string{"3", "1", "2"},
+		Extra: url.Values{
+			"beta": {"b2", "b1"},
+			"gam":  {"g"},
+		},
+		Mid:   7,
+		Space: "x&y",
+		Last:  []int{9, 8},
+	}
+	const expect = "http://example.com/u?q=1&z=2&a+b=x%26y&alpha=3&alpha=1&alpha=2&alpha2=9&alpha2=8&beta=b2&beta=b1&gam=g&mid=7&zeta=z"
+	for i := 0; i < 20; i++ {
+		req, err := httprequest.Marshal("http://example.com/u?q=1&z=2", "GET", val)
+		c.Assert(err, gc.IsNil)
+		c.Assert(req.URL.String(), gc.Equals, expect)
+	}
+}
+
+var roundTripTests = []struct {
+	about string
+	path  string
+	val   interface{}
+}{{
+	about: "simple fields",
+	path:  "/x/:P/:Q",
+	val: &struct {
+		P  string  `httprequest:",path"`
+		Q  int     `httprequest:",path"`
+		F1 string  `httprequest:",form"`
+		F2 int     `httprequest:",form"`
+		F3 bool    `httprequest:",form"`
+		F4 float64 `httprequest:",form"`
+		H1 string  `httprequest:"X-Request-ID,header"`
+		H2 int     `httprequest:"x-count,header"`
+	}{
+		P:  "a b?c",
+		Q:  99,
+		F1: "some text",
+		F2: -35,
+		F3: true,
+		F4: 1.5,
+		H1: "1234",
+		H2: 42,
+	},
+}, {
+	about: "matrix parameters",
+	path:  "/x/:P/sub",
+	val: &struct {
+		P       string `httprequest:",path"`
+		Version int    `httprequest:"version,matrix" httpmatrix:"P"`
+		Lang    string `httprequest:"lang,matrix" httpmatrix:"P"`
+	}{
+		P:       "thing",
+		Version: 2,
+		Lang:    "en",
+	},
+}, {
+	about: "basic auth fields",
+	path:  "/x",
+	val: &struct {
+		User     string  `httprequest:",basicuser"`
+		Password *string `httprequest:",basicpass"`
+	}{
+		User:     "bob",
+		Password: newString("secret:password"),
+	},
+}, {
+	about: "slice fields",
+	path:  "/x",
+	val: &struct {
+		F1 []string    `httprequest:",form"`
+		F2 []int       `httprequest:",form"`
+		F3 *[]int      `httprequest:",form"`
+		F4 []float64   `httprequest:",form"`
+		H1 []string    `httprequest:"X-Multi,header"`
+		H2 []int       `httprequest:"X-Numbers,header"`
+		T  []textPair  `httprequest:",form"`
+		TP *[]textPair `httprequest:",form"`
+		PT []*textPair `httprequest:",form"`
+		TH []textPair  `httprequest:"X-Pairs,header"`
+	}{
+		F1: []string{"c", "a", "b"},
+		F2: []int{3, 1, 2},
+		F3: &[]int{4, 5},
+		F4: []float64{0.5, 2},
+		H1: []string{"z", "y"},
+		H2: []int{7, 8, 9},
+		T:  []textPair{{"a", "b"}, {"c", "d"}},
+		TP: &[]textPair{{"e", "f"}},
+		PT: []*textPair{{"g", "h"}, {"i", "j"}},
+		TH: []textPair{{"k", "l"}},
+	},
+}, {
+	about: "fields with numeric base",
+	path:  "/x/:id",
+	val: &struct {
+		ID uint64 `httprequest:"id,path" httpbase:"16"`
+		F  int    `httprequest:"f,form" httpbase:"2"`
+		G  *int32 `httprequest:"g,form" httpbase:"36"`
+		H  int8   `httprequest:"X-H,header" httpbase:"16"`
+		O  uint16 `httprequest:"o,form,omitempty" httpbase:"16"`
+		Z  uint16 `httprequest:"X-Z,header,omitempty" httpbase:"16"`
+	}{
+		ID: 0xdeadbeef,
+		F:  -6,
+		G:  newInt32(123456),
+		H:  -0x80,
+	},
+}, {
+	about: "composite path key",
+	path:  "/x/:id/y",
+	val: &struct {
+		ID textPair `httprequest:"id,path"`
+	}{
+		ID: textPair{" al ice%", "my?repo#1 "},
+	},
+}, {
+	about: "pointer fields",
+	path:  "/x/:P",
+	val: &struct {
+		P  *string    `httprequest:",path"`
+		F1 *int       `httprequest:",form"`
+		F2 *string    `httprequest:",form"`
+		F3 *int       `httprequest:",form"`
+		F4 *textPair  `httprequest:",form"`
+		F5 *textPair  `httprequest:",form"`
+		H  *string    `httprequest:",header"`
+		B  *bodyValue `httprequest:",body"`
+	}{
+		P:  newString("p"),
+		F1: newInt(0),
+		F2: newString(""),
+		F4: &textPair{"a", "b"},
+		H:  newString("h"),
+	},
+}, {
+	about: "text marshalers and body",
+	path:  "/x/:P",
+	val: &struct {
+		P textPair  `httprequest:",path"`
+		F textPair  `httprequest:",form"`
+		B bodyValue `httprequest:",body"`
+	}{
+		P: textPair{"p", "q"},
+		F: textPair{"f", "g"},
+		B: bodyValue{
+			N: 1,
+			S: []string{"x"},
+		},
+	},
+}, {
+	about: "allowed value fields",
+	path:  "/x/:P",
+	val: &struct {
+		P colour  `httprequest:",path"`
+		F colour  `httprequest:",form"`
+		H *colour `httprequest:",header"`
+	}{
+		P: "red",
+		F: "blue",
+		H: newColour("green"),
+	},
+}, {
+	about: "raw JSON body",
+	path:  "/x",
+	val: &struct {
+		B json.RawMessage `httprequest:",body"`
+	}{
+		B: json.RawMessage(`{"a":[1,2,{"b":null}]}`),
+	},
+}, {
+	about: "raw JSON body pointer",
+	path:  "/x",
+	val: &struct {
+		B *json.RawMessage `httprequest:",body"`
+	}{
+		B: rawMessage(`"some text"`),
+	},
+}, {
+	about: "body with raw JSON fields",
+	path:  "/x",
+	val: &struct {
+		B struct {
+			Kind   string
+			Detail json.RawMessage
+			Extra  *json.RawMessage
+		} `httprequest:",body"`
+	}{
+		B: struct {
+			Kind   string
+			Detail json.RawMessage
+			Extra  *json.RawMessage
+		}{
+			Kind:   "x",
+			Detail: json.RawMessage(`{"n":99}`),
+			Extra:  rawMessage(`[true]`),
+		},
+	},
+}}
+
+func rawMessage(s string) *json.RawMessage {
+	m := json.RawMessage(s)
+	return &m
+}
+
+func (*marshalSuite) TestRoundTrip(c *gc.C) {
+	for i, test := range roundTripTests {
+		c.Logf("test %d: %s", i, test.about)
+		req, err := httprequest.Marshal("http://localhost"+test.path, "POST", test.val)
+		c.Assert(err, gc.IsNil)
+		// Send the request through a router so that the path
+		// parameters and form are parsed as they would be
+		// on a server.
+		got := reflect.New(reflect.TypeOf(test.val).Elem())
+		called := false
+		r := httprouter.New()
+		r.Handle("POST", test.path, func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+			called = true
+			err := req.ParseForm()
+			c.Assert(err, gc.IsNil)
+			err = httprequest.Unmarshal(httprequest.Params{
+				Response: w,
+				Request:  req,
+				PathVar:  p,
+			}, got.Interface())
+			c.Assert(err, gc.IsNil)
+		})
+		serverReq, err := http.NewRequest("POST", req.URL.String(), req.Body)
+		c.Assert(err, gc.IsNil)
+		serverReq.Header = req.Header
+		r.ServeHTTP(httptest.NewRecorder(), serverReq)
+		c.Assert(called, gc.Equals, true)
+		c.Assert(got.Interface(), jc.DeepEquals, test.val)
 	}
 }
 
+type signedRequest struct {
+	httprequest.Route `httprequest:"GET /x/:P"`
+	P                 string `httprequest:",path"`
+	F                 string `httprequest:"f,form"`
+	fail              error
+}
+
+func (r *signedRequest) SetHeader(h http.Header) {
+	h.Set("X-Extra", "extra")
+}
+
+// MarshalRequest implements httprequest.RequestMarshaler
+// by adding a header that depends on the rest of the request.
+func (r *signedRequest) MarshalRequest(req *http.Request) error {
+	if r.fail != nil {
+		return r.fail
+	}
+	req.Header.Set("Signature", req.Method+" "+req.URL.String()+" "+req.Header.Get("X-Extra"))
+	return nil
+}
+
+func (*marshalSuite) TestMarshalWithRequestMarshaler(c *gc.C) {
+	req, err := httprequest.Marshal("http://localhost/x/:P", "GET", &signedRequest{
+		P: "p",
+		F: "f",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(req.Header.Get("Signature"), gc.Equals, "GET http://localhost/x/p?f=f extra")
+
+	_, err = httprequest.Marshal("http://localhost/x/:P", "GET", &signedRequest{
+		P:    "p",
+		fail: errBadReq,
+	})
+	c.Assert(err, gc.ErrorMatches, "cannot marshal request: bad request")
+	c.Assert(errgo.Cause(err), gc.Equals, errBadReq)
+}
+
+// textPair implements encoding.TextMarshaler and
+// encoding.TextUnmarshaler symmetrically.
+type textPair struct {
+	A, B string
+}
+
+func (t *textPair) MarshalText() ([]byte, error) {
+	return []byte(t.A + "-" + t.B), nil
+}
+
+func (t *textPair) UnmarshalText(data []byte) error {
+	parts := strings.SplitN(string(data), "-", 2)
+	if len(parts) != 2 {
+		return errgo.Newf("invalid text pair %q", data)
+	}
+	t.A, t.B = parts[0], parts[1]
+	return nil
+}
+
+type bodyValue struct {
+	N int
+	S []string
+}
+
 type testMarshaler string
 
 func (t *testMarshaler) MarshalText() ([]byte, error) {
@@ -0,0 +1,92 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrMethodNotAllowed is the cause of the error passed to the
+// ErrorMapper by a handler returned by Server.MethodNotAllowedHandler.
+var ErrMethodNotAllowed = errgo.New("method not allowed")
+
+// MethodNotAllowedHandler returns a handler suitable for use as the
+// MethodNotAllowed field of an httprouter.Router to which the given
+// handlers have been added (see AddHandlers), so that a request with
+// a method that is not registered for its path results in an error
+// response in the same format as other errors from srv.
+//
+// The handler sets the Allow header of the response to the methods
+// of all the handlers whose path pattern matches the request path,
+// and then writes an error with an ErrMethodNotAllowed cause using
+// srv.WriteError. The ErrorMapper will usually map that error to a
+// 405 (Method Not Allowed) status.
+func (srv *Server) MethodNotAllowedHandler(hs []Handler) http.Handler {
+	hs = append([]Handler(nil), hs...)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := contextFromRequest(req)
+		defer cancel()
+		if allow := allowedMethods(hs, req.URL.Path); len(allow) > 0 {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+		}
+		srv.WriteError(ctx, w, errgo.WithCausef(nil, ErrMethodNotAllowed, "%s not allowed for %s", req.Method, req.URL.Path))
+	})
+}
+
+// allowedMethods returns the sorted set of methods of all the handlers
+// in hs whose path pattern matches the given path.
+func allowedMethods(hs []Handler, path string) []string {
+	found := make(map[string]bool)
+	var methods []string
+	for _, h := range hs {
+		if found[h.Method] || !pathPatternMatches(h.Path, path) {
+			continue
+		}
+		found[h.Method] = true
+		methods = append(methods, h.Method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// pathPatternMatches reports whether the given path matches the
+// httprouter path pattern, in which a segment starting with ':'
+// matches any single non-empty path segment and a segment starting
+// with '*' matches the rest of the path.
+func pathPatternMatches(pattern, path string) bool {
+	for {
+		if pattern != "" && pattern[0] == '*' {
+			return true
+		}
+		if pattern == "" || path == "" {
+			return pattern == path
+		}
+		if pattern[0] == ':' {
+			pattern = pattern[segmentLen(pattern):]
+			n := segmentLen(path)
+			if n == 0 {
+				return false
+			}
+			path = path[n:]
+			continue
+		}
+		if pattern[0] != path[0] {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+}
+
+// segmentLen returns the length of the path segment
+// at the start of s.
+func segmentLen(s string) int {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		return i
+	}
+	return len(s)
+}
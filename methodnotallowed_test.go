@@ -0,0 +1,148 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type methodNotAllowedSuite struct{}
+
+var _ = gc.Suite(&methodNotAllowedSuite{})
+
+var pathPatternMatchesTests = []struct {
+	pattern     string
+	path        string
+	expectMatch bool
+}{{
+	pattern:     "/",
+	path:        "/",
+	expectMatch: true,
+}, {
+	pattern:     "/foo",
+	path:        "/foo",
+	expectMatch: true,
+}, {
+	pattern: "/foo",
+	path:    "/foo/",
+}, {
+	pattern: "/foo",
+	path:    "/fo",
+}, {
+	pattern:     "/foo/:id",
+	path:        "/foo/123",
+	expectMatch: true,
+}, {
+	pattern: "/foo/:id",
+	path:    "/foo/",
+}, {
+	pattern: "/foo/:id",
+	path:    "/foo/123/bar",
+}, {
+	pattern:     "/foo/:id/bar",
+	path:        "/foo/123/bar",
+	expectMatch: true,
+}, {
+	pattern:     "/static/*path",
+	path:        "/static/",
+	expectMatch: true,
+}, {
+	pattern:     "/static/*path",
+	path:        "/static/a/b/c",
+	expectMatch: true,
+}, {
+	pattern: "/static/*path",
+	path:    "/static",
+}}
+
+func (*methodNotAllowedSuite) TestPathPatternMatches(c *gc.C) {
+	for i, test := range pathPatternMatchesTests {
+		c.Logf("test %d: %q %q", i, test.pattern, test.path)
+		c.Assert(httprequest.PathPatternMatches(test.pattern, test.path), gc.Equals, test.expectMatch)
+	}
+}
+
+type methodNotAllowedHandlers struct{}
+
+func (methodNotAllowedHandlers) GetItem(*struct {
+	httprequest.Route `httprequest:"GET /item/:id"`
+}) {
+}
+
+func (methodNotAllowedHandlers) PutItem(*struct {
+	httprequest.Route `httprequest:"PUT /item/:id"`
+}) {
+}
+
+func (methodNotAllowedHandlers) DeleteItem(*struct {
+	httprequest.Route `httprequest:"DELETE /item/:id"`
+}) {
+}
+
+func (methodNotAllowedHandlers) ListItems(*struct {
+	httprequest.Route `httprequest:"GET /item"`
+}) {
+}
+
+var methodNotAllowedTests = []struct {
+	about         string
+	method        string
+	path          string
+	expectAllow   string
+	expectMessage string
+}{{
+	about:         "item",
+	method:        "POST",
+	path:          "/item/1",
+	expectAllow:   "DELETE, GET, PUT",
+	expectMessage: "POST not allowed for /item/1",
+}, {
+	about:         "item list",
+	method:        "DELETE",
+	path:          "/item",
+	expectAllow:   "GET",
+	expectMessage: "DELETE not allowed for /item",
+}}
+
+func (*methodNotAllowedSuite) TestMethodNotAllowedHandler(c *gc.C) {
+	srv := httprequest.Server{
+		ErrorMapper: func(ctx context.Context, err error) (int, interface{}) {
+			status := http.StatusInternalServerError
+			if errgo.Cause(err) == httprequest.ErrMethodNotAllowed {
+				status = http.StatusMethodNotAllowed
+			}
+			return status, &httprequest.RemoteError{
+				Message: err.Error(),
+				Code:    "method not allowed",
+			}
+		},
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (methodNotAllowedHandlers, context.Context, error) {
+		return methodNotAllowedHandlers{}, p.Context, nil
+	})
+	router := httprouter.New()
+	httprequest.AddHandlers(router, hs)
+	router.MethodNotAllowed = srv.MethodNotAllowedHandler(hs)
+	for i, test := range methodNotAllowedTests {
+		c.Logf("test %d: %s", i, test.about)
+		req, err := http.NewRequest(test.method, test.path, nil)
+		c.Assert(err, gc.IsNil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Code, gc.Equals, http.StatusMethodNotAllowed)
+		c.Assert(rec.Header().Get("Allow"), gc.Equals, test.expectAllow)
+		c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "application/json")
+		resp := parseErrorResponse(c, rec.Body.Bytes())
+		c.Assert(resp.Message, gc.Equals, test.expectMessage)
+		c.Assert(resp.Code, gc.Equals, "method not allowed")
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gopkg.in/errgo.v1"
+)
+
+// NDJSONWriter writes a stream of newline-delimited JSON values
+// to an HTTP response, so that a client can process a large result
+// incrementally. See http://ndjson.org/ for details of the format.
+type NDJSONWriter struct {
+	w http.ResponseWriter
+}
+
+// NewNDJSONWriter returns an NDJSONWriter that writes values to w.
+// It sets the Content-Type header to "application/x-ndjson" and
+// writes the HTTP status, so any error returned from the handler
+// after NewNDJSONWriter has been called will not be written to the
+// response by HandleErrors.
+func NewNDJSONWriter(w http.ResponseWriter) *NDJSONWriter {
+	h := w.Header()
+	h.Set("Content-Type", "application/x-ndjson")
+	// Ask any intermediate proxies not to buffer the response.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	return &NDJSONWriter{w: w}
+}
+
+// Write writes the JSON encoding of v followed by a newline, then
+// flushes the response.
+func (nw *NDJSONWriter) Write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal value")
+	}
+	data = append(data, '\n')
+	if _, err := nw.w.Write(data); err != nil {
+		return errgo.Notef(err, "cannot write value")
+	}
+	if f, ok := nw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// WriteNDJSON writes each value received from items to p.Response
+// as newline-delimited JSON, flushing after each one, until items is
+// closed. If p.Context is done first, it stops early and returns the
+// context's error.
+//
+// See NewNDJSONWriter for how the response is set up.
+func (p Params) WriteNDJSON(items <-chan interface{}) error {
+	var done <-chan struct{}
+	if p.Context != nil {
+		done = p.Context.Done()
+	}
+	w := NewNDJSONWriter(p.Response)
+	for {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				return nil
+			}
+			if err := w.Write(item); err != nil {
+				return errgo.Mask(err)
+			}
+		case <-done:
+			return errgo.Mask(p.Context.Err(), errgo.Any)
+		}
+	}
+}
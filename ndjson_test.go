@@ -0,0 +1,73 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type ndjsonSuite struct{}
+
+var _ = gc.Suite(&ndjsonSuite{})
+
+func (*ndjsonSuite) TestNDJSONWriter(c *gc.C) {
+	rec := httptest.NewRecorder()
+	w := httprequest.NewNDJSONWriter(rec)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "application/x-ndjson")
+
+	err := w.Write(struct{ N int }{1})
+	c.Assert(err, gc.IsNil)
+	c.Assert(rec.Flushed, gc.Equals, true)
+	err = w.Write("hello")
+	c.Assert(err, gc.IsNil)
+	err = w.Write(make(chan int))
+	c.Assert(err, gc.ErrorMatches, `cannot marshal value: json: unsupported type: chan int`)
+	c.Assert(rec.Body.String(), gc.Equals, "{\"N\":1}\n\"hello\"\n")
+}
+
+func (*ndjsonSuite) TestWriteNDJSON(c *gc.C) {
+	handler := testServer.HandleErrors(func(p httprequest.Params) error {
+		items := make(chan interface{})
+		go func() {
+			defer close(items)
+			for i := 0; i < 3; i++ {
+				items <- i
+			}
+		}()
+		return p.WriteNDJSON(items)
+	})
+	rec := httptest.NewRecorder()
+	handler(rec, new(http.Request), nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "application/x-ndjson")
+	c.Assert(rec.Body.String(), gc.Equals, "0\n1\n2\n")
+}
+
+func (*ndjsonSuite) TestWriteNDJSONCancel(c *gc.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	items := make(chan interface{}, 1)
+	items <- "first"
+	rec := httptest.NewRecorder()
+	p := httprequest.Params{
+		Response: rec,
+		Context:  ctx,
+	}
+	done := make(chan error)
+	go func() {
+		done <- p.WriteNDJSON(items)
+	}()
+	// Wait for the first item to be consumed before cancelling.
+	items <- "second"
+	cancel()
+	err := <-done
+	c.Assert(errgo.Cause(err), gc.Equals, context.Canceled)
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+)
+
+// DefaultPanicMapper is the function used to map a recovered panic
+// to an error response when Server.PanicMapper is nil. It returns a
+// 500 (Internal Server Error) status with a *RemoteError body holding
+// a generic message, so that no details of the panic are revealed to
+// the client.
+func DefaultPanicMapper(ctx context.Context, v interface{}) (httpStatus int, errorBody interface{}) {
+	return http.StatusInternalServerError, &RemoteError{
+		Message: "internal server error",
+	}
+}
+
+// recoverPanics returns a handler that calls h, recovering from any
+// panic as described by Server.RecoverPanics. If RecoverPanics is
+// not set, it returns h unchanged.
+func (srv *Server) recoverPanics(h httprouter.Handle) httprouter.Handle {
+	if !srv.RecoverPanics {
+		return h
+	}
+	mapPanic := srv.PanicMapper
+	if mapPanic == nil {
+		mapPanic = DefaultPanicMapper
+	}
+	logPanic := srv.PanicLogger
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		w1 := &responseWriter{
+			ResponseWriter: w,
+		}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// The handler wants the connection
+				// aborted, so let net/http do that.
+				panic(v)
+			}
+			ctx, cancel := contextFromRequest(req)
+			defer cancel()
+			if logPanic != nil {
+				logPanic(ctx, v, debug.Stack())
+			}
+			if w1.headerWritten {
+				// The handler has already started writing
+				// its response, so writing an error now
+				// would only corrupt it. Abort the
+				// connection instead so that the client
+				// does not take the truncated response
+				// to be complete.
+				panic(http.ErrAbortHandler)
+			}
+			status, body := mapPanic(ctx, v)
+			if err := WriteJSON(w, status, body); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+		h(w1, req, p)
+	}
+}
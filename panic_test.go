@@ -0,0 +1,171 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type panicSuite struct{}
+
+var _ = gc.Suite(&panicSuite{})
+
+var recoverPanicsTests = []struct {
+	about        string
+	panicMapper  func(ctx context.Context, v interface{}) (int, interface{})
+	handle       func(srv *httprequest.Server) httprouter.Handle
+	expectStatus int
+	expectBody   string
+}{{
+	about: "Handle",
+	handle: func(srv *httprequest.Server) httprouter.Handle {
+		return srv.Handle(func(p httprequest.Params, _ *struct{}) (int, error) {
+			panic("oops")
+		}).Handle
+	},
+	expectStatus: http.StatusInternalServerError,
+	expectBody:   `{"Message":"internal server error"}`,
+}, {
+	about: "Handlers",
+	handle: func(srv *httprequest.Server) httprouter.Handle {
+		return srv.Handlers(func(p httprequest.Params) (*panicHandlers, context.Context, error) {
+			return &panicHandlers{}, p.Context, nil
+		})[0].Handle
+	},
+	expectStatus: http.StatusInternalServerError,
+	expectBody:   `{"Message":"internal server error"}`,
+}, {
+	about: "HandleJSON",
+	handle: func(srv *httprequest.Server) httprouter.Handle {
+		return srv.HandleJSON(func(p httprequest.Params) (interface{}, error) {
+			panic("oops")
+		})
+	},
+	expectStatus: http.StatusInternalServerError,
+	expectBody:   `{"Message":"internal server error"}`,
+}, {
+	about: "HandleErrors",
+	handle: func(srv *httprequest.Server) httprouter.Handle {
+		return srv.HandleErrors(func(p httprequest.Params) error {
+			panic("oops")
+		})
+	},
+	expectStatus: http.StatusInternalServerError,
+	expectBody:   `{"Message":"internal server error"}`,
+}, {
+	about: "custom panic mapper",
+	panicMapper: func(ctx context.Context, v interface{}) (int, interface{}) {
+		return http.StatusServiceUnavailable, &httprequest.RemoteError{
+			Message: "panic: " + v.(string),
+			Code:    "panic",
+		}
+	},
+	handle: func(srv *httprequest.Server) httprouter.Handle {
+		return srv.HandleErrors(func(p httprequest.Params) error {
+			panic("oops")
+		})
+	},
+	expectStatus: http.StatusServiceUnavailable,
+	expectBody:   `{"Message":"panic: oops","Code":"panic"}`,
+}}
+
+type panicHandlers struct{}
+
+func (*panicHandlers) Panic(*struct {
+	httprequest.Route `httprequest:"GET /panic"`
+}) error {
+	panic(errgo.New("oops"))
+}
+
+func (*panicSuite) TestRecoverPanics(c *gc.C) {
+	for i, test := range recoverPanicsTests {
+		c.Logf("test %d: %s", i, test.about)
+		var logged []interface{}
+		var stack []byte
+		srv := httprequest.Server{
+			ErrorMapper:   testServer.ErrorMapper,
+			RecoverPanics: true,
+			PanicMapper:   test.panicMapper,
+			PanicLogger: func(ctx context.Context, v interface{}, s []byte) {
+				c.Check(ctx, gc.NotNil)
+				logged = append(logged, v)
+				stack = s
+			},
+		}
+		req, err := http.NewRequest("GET", "/panic", nil)
+		c.Assert(err, gc.IsNil)
+		rec := httptest.NewRecorder()
+		test.handle(&srv)(rec, req, nil)
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		c.Assert(rec.Body.String(), gc.Equals, test.expectBody)
+		c.Assert(logged, gc.HasLen, 1)
+		c.Assert(string(stack), gc.Matches, `(?s).*panic_test\.go.*`)
+	}
+}
+
+func (*panicSuite) TestPanicWithoutRecoverPanics(c *gc.C) {
+	h := testServer.HandleErrors(func(p httprequest.Params) error {
+		panic("oops")
+	})
+	req, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(func() {
+		h(httptest.NewRecorder(), req, nil)
+	}, gc.PanicMatches, "oops")
+}
+
+func (*panicSuite) TestPanicAfterResponseWritten(c *gc.C) {
+	var logged []interface{}
+	srv := httprequest.Server{
+		ErrorMapper:   testServer.ErrorMapper,
+		RecoverPanics: true,
+		PanicLogger: func(ctx context.Context, v interface{}, s []byte) {
+			logged = append(logged, v)
+		},
+	}
+	h := srv.Handle(func(p httprequest.Params, _ *struct{}) {
+		p.Response.WriteHeader(http.StatusAccepted)
+		p.Response.Write([]byte("partial"))
+		panic("oops")
+	}).Handle
+	req, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, gc.IsNil)
+	rec := httptest.NewRecorder()
+	c.Assert(func() {
+		h(rec, req, nil)
+	}, gc.Panics, http.ErrAbortHandler)
+	c.Assert(rec.Code, gc.Equals, http.StatusAccepted)
+	c.Assert(rec.Body.String(), gc.Equals, "partial")
+	c.Assert(logged, gc.DeepEquals, []interface{}{"oops"})
+}
+
+func (*panicSuite) TestPanicWithErrAbortHandler(c *gc.C) {
+	var logged []interface{}
+	srv := httprequest.Server{
+		ErrorMapper:   testServer.ErrorMapper,
+		RecoverPanics: true,
+		PanicLogger: func(ctx context.Context, v interface{}, s []byte) {
+			logged = append(logged, v)
+		},
+	}
+	h := srv.HandleErrors(func(p httprequest.Params) error {
+		panic(http.ErrAbortHandler)
+	})
+	req, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, gc.IsNil)
+	rec := httptest.NewRecorder()
+	c.Assert(func() {
+		h(rec, req, nil)
+	}, gc.Panics, http.ErrAbortHandler)
+	c.Assert(rec.Body.String(), gc.Equals, "")
+	c.Assert(logged, gc.HasLen, 0)
+}
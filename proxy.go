@@ -0,0 +1,45 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+	"gopkg.in/errgo.v1"
+)
+
+// WriteRemoteError writes err to w as a JSON error response with
+// the status held in err.StatusCode, so that an error returned by
+// a remote server can be passed on unchanged, for example by a
+// proxy that uses a Client to call the remote server. If
+// err.StatusCode is zero, a 500 (Internal Server Error) status is
+// used.
+func WriteRemoteError(w http.ResponseWriter, err *RemoteError) error {
+	return WriteJSON(w, remoteErrorStatus(err), err)
+}
+
+// ProxyErrorMapper returns a function suitable for use as
+// Server.ErrorMapper that writes an error whose cause is a
+// *RemoteError, such as an error returned by Client when the
+// remote server responds with an error, as that *RemoteError
+// with the status held in its StatusCode field, in the same way
+// as WriteRemoteError. Any other error is mapped by calling f.
+func ProxyErrorMapper(f func(ctx context.Context, err error) (httpStatus int, errorBody interface{})) func(ctx context.Context, err error) (httpStatus int, errorBody interface{}) {
+	return func(ctx context.Context, err error) (int, interface{}) {
+		if rerr, ok := errgo.Cause(err).(*RemoteError); ok && rerr != nil {
+			return remoteErrorStatus(rerr), rerr
+		}
+		return f(ctx, err)
+	}
+}
+
+// remoteErrorStatus returns the HTTP status to use
+// when writing err.
+func remoteErrorStatus(err *RemoteError) int {
+	if err.StatusCode == 0 {
+		return http.StatusInternalServerError
+	}
+	return err.StatusCode
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/julienschmidt/httprouter"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type proxySuite struct{}
+
+var _ = gc.Suite(&proxySuite{})
+
+var writeRemoteErrorTests = []struct {
+	about        string
+	err          *httprequest.RemoteError
+	expectStatus int
+	expectBody   string
+}{{
+	about: "with status",
+	err: &httprequest.RemoteError{
+		Message:    "not found",
+		Code:       "not found",
+		StatusCode: http.StatusNotFound,
+	},
+	expectStatus: http.StatusNotFound,
+	expectBody:   `{"Message":"not found","Code":"not found"}`,
+}, {
+	about: "without status",
+	err: &httprequest.RemoteError{
+		Message: "something went wrong",
+	},
+	expectStatus: http.StatusInternalServerError,
+	expectBody:   `{"Message":"something went wrong"}`,
+}}
+
+func (*proxySuite) TestWriteRemoteError(c *gc.C) {
+	for i, test := range writeRemoteErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		rec := httptest.NewRecorder()
+		err := httprequest.WriteRemoteError(rec, test.err)
+		c.Assert(err, gc.IsNil)
+		c.Assert(rec.Code, gc.Equals, test.expectStatus)
+		c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "application/json")
+		c.Assert(rec.Body.String(), gc.Equals, test.expectBody)
+	}
+}
+
+func (*proxySuite) TestProxyErrorMapper(c *gc.C) {
+	// Start a backend server that returns an error.
+	router := httprouter.New()
+	router.GET("/backend", testServer.HandleErrors(func(p httprequest.Params) error {
+		return errgo.WithCausef(nil, errUnauth, "no entry")
+	}))
+	backend := httptest.NewServer(router)
+	defer backend.Close()
+
+	client := httprequest.Client{
+		BaseURL: backend.URL,
+	}
+	srv := httprequest.Server{
+		ErrorMapper: httprequest.ProxyErrorMapper(testErrorMapper),
+	}
+	proxy := srv.HandleErrors(func(p httprequest.Params) error {
+		if p.Request.URL.Path == "/local" {
+			return errgo.WithCausef(nil, errBadReq, "local error")
+		}
+		req, err := http.NewRequest("GET", "/backend", nil)
+		c.Assert(err, gc.IsNil)
+		return client.Do(p.Context, req, nil)
+	})
+
+	req, err := http.NewRequest("GET", "/remote", nil)
+	c.Assert(err, gc.IsNil)
+	rec := httptest.NewRecorder()
+	proxy(rec, req, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized)
+	c.Assert(rec.Body.String(), gc.Equals, `{"Message":"no entry","Code":"unauthorized"}`)
+
+	req, err = http.NewRequest("GET", "/local", nil)
+	c.Assert(err, gc.IsNil)
+	rec = httptest.NewRecorder()
+	proxy(rec, req, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusBadRequest)
+	c.Assert(rec.Body.String(), gc.Equals, `{"Message":"local error","Code":"bad request"}`)
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// DefaultRequestIDHeader holds a commonly used name for the header
+// that identifies a request, suitable for use as
+// Server.RequestIDHeader.
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// requestIDHandler returns a handler that calls h after copying the
+// request header named by srv.RequestIDHeader to the response, first
+// setting it in the request to a newly generated ID if it is not
+// present. If RequestIDHeader is empty, it returns h unchanged.
+func (srv *Server) requestIDHandler(h httprouter.Handle) httprouter.Handle {
+	header := srv.RequestIDHeader
+	if header == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		id := req.Header.Get(header)
+		if id == "" {
+			id = newRequestID()
+			if id != "" {
+				req.Header.Set(header, id)
+			}
+		}
+		if id != "" {
+			w.Header().Set(header, id)
+		}
+		h(w, req, p)
+	}
+}
+
+// newRequestID returns a new random (version 4) UUID, or
+// the empty string if no random data is available.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type requestIDSuite struct{}
+
+var _ = gc.Suite(&requestIDSuite{})
+
+type requestIDHandlers struct{}
+
+func (requestIDHandlers) Get(arg *struct {
+	httprequest.Route `httprequest:"GET /item"`
+	ID                string `httprequest:"X-Request-Id,header"`
+}) (string, error) {
+	return arg.ID, nil
+}
+
+func newRequestIDHandlers(p httprequest.Params) (requestIDHandlers, context.Context, error) {
+	return requestIDHandlers{}, p.Context, nil
+}
+
+const uuidPattern = `[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}`
+
+var requestIDTests = []struct {
+	about      string
+	header     string
+	method     string
+	requestID  string
+	expectID   string
+	expectBody string
+}{{
+	about:      "no request ID header configured",
+	method:     "GET",
+	requestID:  "abc",
+	expectBody: `"abc"`,
+}, {
+	about:      "request ID echoed",
+	header:     httprequest.DefaultRequestIDHeader,
+	method:     "GET",
+	requestID:  "abc",
+	expectID:   "abc",
+	expectBody: `"abc"`,
+}, {
+	about:      "request ID generated",
+	header:     httprequest.DefaultRequestIDHeader,
+	method:     "GET",
+	expectID:   uuidPattern,
+	expectBody: `"` + uuidPattern + `"`,
+}, {
+	about:     "request ID on OPTIONS request",
+	header:    httprequest.DefaultRequestIDHeader,
+	method:    "OPTIONS",
+	requestID: "abc",
+	expectID:  "abc",
+}}
+
+func (*requestIDSuite) TestRequestID(c *gc.C) {
+	for i, test := range requestIDTests {
+		c.Logf("test %d: %s", i, test.about)
+		srv := testServer
+		srv.HandleOPTIONS = true
+		srv.RequestIDHeader = test.header
+		router := httprouter.New()
+		httprequest.AddHandlers(router, srv.Handlers(newRequestIDHandlers))
+		req, err := http.NewRequest(test.method, "/item", nil)
+		c.Assert(err, gc.IsNil)
+		if test.requestID != "" {
+			req.Header.Set("X-Request-Id", test.requestID)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if test.expectID == "" {
+			c.Assert(rec.Header()["X-Request-Id"], gc.HasLen, 0)
+		} else {
+			c.Assert(rec.Header().Get("X-Request-Id"), gc.Matches, test.expectID)
+		}
+		if test.expectBody != "" {
+			c.Assert(rec.Body.String(), gc.Matches, test.expectBody)
+		}
+	}
+}
+
+func (*requestIDSuite) TestGeneratedRequestIDMatchesResponse(c *gc.C) {
+	srv := testServer
+	srv.RequestIDHeader = httprequest.DefaultRequestIDHeader
+	router := httprouter.New()
+	httprequest.AddHandlers(router, srv.Handlers(newRequestIDHandlers))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	client := httprequest.Client{
+		BaseURL: server.URL,
+	}
+	req, err := http.NewRequest("GET", "/item", nil)
+	c.Assert(err, gc.IsNil)
+	var id string
+	resp, err := client.DoResponse(context.Background(), req, &id)
+	c.Assert(err, gc.IsNil)
+	defer resp.Body.Close()
+	c.Assert(id, gc.Matches, uuidPattern)
+	c.Assert(resp.Header.Get("X-Request-Id"), gc.Equals, id)
+}
+
+func (*requestIDSuite) TestRequestIDWithHandle(c *gc.C) {
+	srv := testServer
+	srv.RequestIDHeader = httprequest.DefaultRequestIDHeader
+	router := httprouter.New()
+	httprequest.AddHandlers(router, []httprequest.Handler{srv.Handle(func(p httprequest.Params, arg *struct {
+		httprequest.Route `httprequest:"GET /item"`
+		ID                string `httprequest:"X-Request-Id,header"`
+	}) (string, error) {
+		return arg.ID, nil
+	})})
+	router.GET("/json", srv.HandleJSON(func(p httprequest.Params) (interface{}, error) {
+		return p.Request.Header.Get("X-Request-Id"), nil
+	}))
+	router.GET("/errors", srv.HandleErrors(func(p httprequest.Params) error {
+		return httprequest.WriteJSON(p.Response, http.StatusOK, p.Request.Header.Get("X-Request-Id"))
+	}))
+	for _, path := range []string{"/item", "/json", "/errors"} {
+		c.Logf("path %s", path)
+		req, err := http.NewRequest("GET", path, nil)
+		c.Assert(err, gc.IsNil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Code, gc.Equals, http.StatusOK)
+		id := rec.Header().Get("X-Request-Id")
+		c.Assert(id, gc.Matches, uuidPattern)
+		c.Assert(rec.Body.String(), gc.Equals, `"`+id+`"`)
+
+		req.Header.Set("X-Request-Id", "abc")
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Header().Get("X-Request-Id"), gc.Equals, "abc")
+		c.Assert(rec.Body.String(), gc.Equals, `"abc"`)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+var (
+	responseTypeMutex sync.RWMutex
+	responseTypeMap   = make(map[reflect.Type]*responseType)
+)
+
+// responseType holds information derived from a response
+// type, preprocessed so that it's quick to fill out the fields
+// that are taken from the HTTP response rather than its body.
+type responseType struct {
+	fields []responseField
+}
+
+// responseField holds preprocessed information on an individual
+// response field.
+type responseField struct {
+	name string
+
+	// index holds the index slice of the field.
+	index []int
+
+	// unmarshal is used to unmarshal the value from the HTTP
+	// response into the given field. The value passed as its
+	// first argument is not a pointer type, but is addressable.
+	unmarshal responseUnmarshaler
+
+	// makeResult is the resultMaker that will be
+	// passed into the unmarshaler.
+	makeResult resultMaker
+}
+
+// responseUnmarshaler unmarshals some value from an HTTP response
+// into the given value. The value should not be assigned to directly,
+// but passed to makeResult and then updated.
+type responseUnmarshaler func(v reflect.Value, resp *http.Response, makeResult resultMaker) error
+
+// unmarshalResponseFields fills out any fields in x, which should
+// be a pointer to the value that the body of resp has been
+// unmarshaled into, that are taken from resp itself.
+// If x is not a pointer to a struct, it does nothing.
+func unmarshalResponseFields(resp *http.Response, x interface{}) error {
+	xv := reflect.ValueOf(x)
+	rt, err := getResponseType(xv.Type())
+	if err != nil {
+		return errgo.Notef(err, "bad response type %s", xv.Type())
+	}
+	if len(rt.fields) == 0 {
+		return nil
+	}
+	xv = xv.Elem()
+	for _, f := range rt.fields {
+		fv := xv.FieldByIndex(f.index)
+		if err := f.unmarshal(fv, resp, f.makeResult); err != nil {
+			return errgo.Notef(err, "cannot unmarshal response into field %s", f.name)
+		}
+	}
+	return nil
+}
+
+// getResponseType is like parseResponseType except that
+// it returns the cached responseType when possible,
+// adding the type to the cache otherwise.
+func getResponseType(t reflect.Type) (*responseType, error) {
+	responseTypeMutex.RLock()
+	rt := responseTypeMap[t]
+	responseTypeMutex.RUnlock()
+	if rt != nil {
+		return rt, nil
+	}
+	responseTypeMutex.Lock()
+	defer responseTypeMutex.Unlock()
+	if rt = responseTypeMap[t]; rt != nil {
+		return rt, nil
+	}
+	rt, err := parseResponseType(t)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	responseTypeMap[t] = rt
+	return rt, nil
+}
+
+// parseResponseType preprocesses the given type into a form that can
+// be efficiently used by unmarshalResponseFields. Types that are not
+// pointers to structs have no response fields.
+func parseResponseType(t reflect.Type) (*responseType, error) {
+	var rt responseType
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return &rt, nil
+	}
+	for _, f := range fields(t.Elem()) {
+		if f.PkgPath != "" {
+			continue
+		}
+		tag, err := parseTag(f.Tag, f.Name)
+		if err != nil {
+			return nil, errgo.Notef(err, "bad tag %q in field %s", f.Tag, f.Name)
+		}
+		field := responseField{
+			name:       f.Name,
+			index:      f.Index,
+			makeResult: makeValueResult,
+		}
+		if f.Type.Kind() == reflect.Ptr {
+			field.makeResult = makePointerResult
+			f.Type = f.Type.Elem()
+		}
+		switch tag.source {
+		case sourceStatus:
+			field.unmarshal, err = getStatusUnmarshaler(f.Type)
+		case sourceResponseHeader:
+			field.unmarshal = getResponseHeaderUnmarshaler(tag.name, f.Type)
+		default:
+			// Request fields are ignored in response types.
+			continue
+		}
+		if err != nil {
+			return nil, errgo.Notef(err, "bad type for field %s", f.Name)
+		}
+		rt.fields = append(rt.fields, field)
+	}
+	return &rt, nil
+}
+
+// getStatusUnmarshaler returns a responseUnmarshaler that sets
+// a value of the given type to the status code of the response.
+func getStatusUnmarshaler(t reflect.Type) (responseUnmarshaler, error) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+	default:
+		return nil, errgo.Newf("status field must be of integer type, not %s", t)
+	}
+	return func(v reflect.Value, resp *http.Response, makeResult resultMaker) error {
+		makeResult(v).SetInt(int64(resp.StatusCode))
+		return nil
+	}, nil
+}
+
+// getResponseHeaderUnmarshaler returns a responseUnmarshaler that sets
+// a value of the given type from the response header with the given
+// name. As for request fields, a []string value is filled out from all
+// the values of the header, a type that implements
+// encoding.TextUnmarshaler uses UnmarshalText, a value of any other
+// string type is set to all the values joined with ", ", and any other
+// type is parsed from the first value as for a request header field.
+// If the header is not present, the field is left unchanged.
+func getResponseHeaderUnmarshaler(name string, t reflect.Type) responseUnmarshaler {
+	switch {
+	case t == reflect.TypeOf([]string(nil)):
+		return func(v reflect.Value, resp *http.Response, makeResult resultMaker) error {
+			if vals := headerValues(resp.Header, name); len(vals) > 0 {
+				makeResult(v).Set(reflect.ValueOf(vals))
+			}
+			return nil
+		}
+	case implementsTextUnmarshaler(t):
+		return func(v reflect.Value, resp *http.Response, makeResult resultMaker) error {
+			vals := headerValues(resp.Header, name)
+			if len(vals) == 0 {
+				return nil
+			}
+			uv := makeResult(v).Addr().Interface().(encodingTextUnmarshaler)
+			return uv.UnmarshalText([]byte(vals[0]))
+		}
+	case t.Kind() == reflect.String:
+		return func(v reflect.Value, resp *http.Response, makeResult resultMaker) error {
+			if vals := headerValues(resp.Header, name); len(vals) > 0 {
+				makeResult(v).SetString(strings.Join(vals, ", "))
+			}
+			return nil
+		}
+	default:
+		return func(v reflect.Value, resp *http.Response, makeResult resultMaker) error {
+			vals := headerValues(resp.Header, name)
+			if len(vals) == 0 {
+				return nil
+			}
+			if err := parseValue(vals[0], makeResult(v)); err != nil {
+				return errgo.Notef(err, "cannot parse %q into %s", vals[0], t)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"gopkg.in/errgo.v1"
+)
+
+// EventStream writes a stream of Server-Sent Events to an HTTP
+// response. See https://www.w3.org/TR/eventsource/ for details
+// of the protocol.
+//
+// It is typically used from an ErrorHandler that loops
+// sending events until p.Context is done.
+type EventStream struct {
+	w http.ResponseWriter
+}
+
+// NewEventStream returns an EventStream that writes events to w. It
+// sets the response headers appropriately, writes the HTTP status
+// and flushes the response so that the client sees the stream
+// immediately.
+//
+// Because the header has been written, any error returned from
+// the handler after NewEventStream has been called will
+// not be written to the response by HandleErrors.
+func NewEventStream(w http.ResponseWriter) *EventStream {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Ask any intermediate proxies not to buffer the response.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	s := &EventStream{w: w}
+	s.flush()
+	return s
+}
+
+// SendEvent sends an event with the given name and the JSON-encoded
+// data as its payload, then flushes the response. If name is empty,
+// the event has no name and will be delivered to the client as a
+// "message" event.
+func (s *EventStream) SendEvent(name string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal event data")
+	}
+	var buf bytes.Buffer
+	if name != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(name)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("data: ")
+	buf.Write(payload)
+	buf.WriteString("\n\n")
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return errgo.Notef(err, "cannot write event")
+	}
+	s.flush()
+	return nil
+}
+
+func (s *EventStream) flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/httprequest"
+)
+
+type eventStreamSuite struct{}
+
+var _ = gc.Suite(&eventStreamSuite{})
+
+func (*eventStreamSuite) TestSendEvent(c *gc.C) {
+	rec := httptest.NewRecorder()
+	s := httprequest.NewEventStream(rec)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Flushed, gc.Equals, true)
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "text/event-stream")
+	c.Assert(rec.Header().Get("Cache-Control"), gc.Equals, "no-cache")
+
+	err := s.SendEvent("update", struct{ N int }{1})
+	c.Assert(err, gc.IsNil)
+	err = s.SendEvent("", "hello")
+	c.Assert(err, gc.IsNil)
+	c.Assert(rec.Body.String(), gc.Equals, "event: update\ndata: {\"N\":1}\n\ndata: \"hello\"\n\n")
+}
+
+func (*eventStreamSuite) TestSendEventWithUnmarshalableData(c *gc.C) {
+	rec := httptest.NewRecorder()
+	s := httprequest.NewEventStream(rec)
+	err := s.SendEvent("update", make(chan int))
+	c.Assert(err, gc.ErrorMatches, `cannot marshal event data: json: unsupported type: chan int`)
+	c.Assert(rec.Body.String(), gc.Equals, "")
+}
+
+func (*eventStreamSuite) TestHandleErrorsAfterStreaming(c *gc.C) {
+	handler := testServer.HandleErrors(func(p httprequest.Params) error {
+		s := httprequest.NewEventStream(p.Response)
+		if err := s.SendEvent("update", 1); err != nil {
+			return err
+		}
+		return errgo.New("stream finished")
+	})
+	rec := httptest.NewRecorder()
+	handler(rec, new(http.Request), nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "text/event-stream")
+	c.Assert(rec.Body.String(), gc.Equals, "event: update\ndata: 1\n\n")
+}
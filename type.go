@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -39,16 +40,41 @@ type Params struct {
 	Response http.ResponseWriter
 	Request  *http.Request
 	PathVar  httprouter.Params
-	// PathPattern holds the path pattern matched by httprouter.
-	// It is only set where httprequest has the information;
-	// that is where the call was made by Server.Handler
-	// or Server.Handlers.
+	// PathPattern holds the path pattern matched by httprouter,
+	// such as "/thing/:id", including any prefix passed to
+	// Server.HandlersWithPrefix. As it does not vary with the
+	// values of path parameters, it is suitable for labeling
+	// metrics. It is only set where httprequest has the
+	// information; that is where the call was made by
+	// Server.Handle or Server.Handlers.
 	PathPattern string
 	// Context holds a context for the request. In Go 1.7 and later,
 	// this should be used in preference to Request.Context.
 	Context context.Context
 }
 
+// Value returns the value associated with key in p.Context, or nil if
+// there is none or p.Context is nil.
+//
+// A function passed to Server.Handlers can attach request-scoped
+// values, such as an authenticated user, to the context it returns,
+// which becomes the Context of the Params passed to the handler
+// methods. A typed accessor for each key avoids the need for type
+// assertions in the handlers; for example:
+//
+//	type userKey struct{}
+//
+//	func userFromParams(p httprequest.Params) *User {
+//		u, _ := p.Value(userKey{}).(*User)
+//		return u
+//	}
+func (p Params) Value(key interface{}) interface{} {
+	if p.Context == nil {
+		return nil
+	}
+	return p.Context.Value(key)
+}
+
 // resultMaker is provided to the unmarshal functions.
 // When called with the value passed to the unmarshaler,
 // it returns the field value to be assigned to,
@@ -68,9 +94,26 @@ type marshaler func(reflect.Value, *Params) error
 // requestType holds information derived from a request
 // type, preprocessed so that it's quick to marshal or unmarshal.
 type requestType struct {
-	method string
-	path   string
-	fields []field
+	// methods holds all the methods specified by the Route
+	// field; method holds the first of them, which is the
+	// one used when making a request.
+	method  string
+	methods []string
+	path    string
+	fields  []field
+
+	// hasForm and hasRawQuery record whether the type
+	// has any form (or allform) fields and any rawquery
+	// fields respectively. Marshal does not allow both.
+	hasForm     bool
+	hasRawQuery bool
+
+	// hasBody records whether the type has a body field.
+	hasBody bool
+
+	// unmarshalParams records whether the type implements
+	// ParamsUnmarshaler.
+	unmarshalParams bool
 }
 
 // field holds preprocessed information on an individual field
@@ -78,6 +121,9 @@ type requestType struct {
 type field struct {
 	name string
 
+	// source holds where the field is unmarshaled from.
+	source tagSource
+
 	// index holds the index slice of the field.
 	index []int
 
@@ -99,6 +145,22 @@ type field struct {
 	isPointer bool
 }
 
+// PrewarmType parses the request type of x, which should be a pointer
+// to a struct suitable for passing to Marshal or Unmarshal, and caches
+// the result, so that the cost of parsing it is not incurred by the
+// first request that uses it. It returns an error with an
+// ErrBadUnmarshalType cause if the type is not suitable.
+//
+// It is not necessary to call PrewarmType; types are parsed and
+// cached on first use regardless.
+func PrewarmType(x interface{}) error {
+	t := reflect.TypeOf(x)
+	if _, err := getRequestType(t); err != nil {
+		return errgo.WithCausef(err, ErrBadUnmarshalType, "bad type %s", t)
+	}
+	return nil
+}
+
 // getRequestType is like parseRequestType except that
 // it returns the cached requestType when possible,
 // adding the type to the cache otherwise.
@@ -132,8 +194,18 @@ func parseRequestType(t reflect.Type) (*requestType, error) {
 		return nil, fmt.Errorf("type is not pointer to struct")
 	}
 
-	hasBody := false
-	var pt requestType
+	pt := requestType{
+		unmarshalParams: t.Implements(paramsUnmarshalerType),
+	}
+	fieldName := defaultFieldName(t)
+	// matrixParams holds the path parameters that
+	// have matrix parameters in their segment.
+	matrixParams := make(map[string]bool)
+	for _, f := range fields(t.Elem()) {
+		if p := f.Tag.Get("httpmatrix"); p != "" {
+			matrixParams[p] = true
+		}
+	}
 	foundRoute := false
 	// taggedFieldIndex holds the index of most recent anonymous
 	// tagged field - we will skip any fields inside that.
@@ -151,26 +223,46 @@ func parseRequestType(t reflect.Type) (*requestType, error) {
 		taggedFieldIndex = nil
 		if !foundRoute && f.Anonymous && f.Type == reflect.TypeOf(Route{}) {
 			var err error
-			pt.method, pt.path, err = parseRouteTag(f.Tag)
+			pt.methods, pt.path, err = parseRouteTag(f.Tag)
 			if err != nil {
 				return nil, errgo.Notef(err, "bad route tag %q", f.Tag)
 			}
+			pt.method = pt.methods[0]
 			foundRoute = true
 			continue
 		}
-		tag, err := parseTag(f.Tag, f.Name)
+		tag, err := parseTag(f.Tag, fieldName(f.Name))
 		if err != nil {
 			return nil, errgo.Notef(err, "bad tag %q in field %s", f.Tag, f.Name)
 		}
+		if tag.source == sourceStatus || tag.source == sourceResponseHeader {
+			return nil, errgo.Newf("response field %s not allowed in request type", f.Name)
+		}
+		if tag.source == sourcePath && matrixParams[tag.name] {
+			tag.hasMatrix = true
+		}
+		if f.Anonymous && isMarkerTag(tag, f.Type) {
+			// The tag on an embedded struct that can't be
+			// unmarshaled as a single value is just a marker;
+			// its fields are processed as if it were untagged.
+			tag.source = sourceNone
+		}
+		switch tag.source {
+		case sourceForm, sourceAllForm:
+			pt.hasForm = true
+		case sourceRawQuery:
+			pt.hasRawQuery = true
+		}
 		if tag.source == sourceBody {
-			if hasBody {
+			if pt.hasBody {
 				return nil, errgo.New("more than one body field specified")
 			}
-			hasBody = true
+			pt.hasBody = true
 		}
 		field := field{
-			index: f.Index,
-			name:  f.Name,
+			index:  f.Index,
+			name:   f.Name,
+			source: tag.source,
 		}
 		if f.Type.Kind() == reflect.Ptr {
 			// The field is a pointer, so when the value is set,
@@ -184,10 +276,13 @@ func parseRequestType(t reflect.Type) (*requestType, error) {
 			field.isPointer = false
 		}
 
-		field.unmarshal, err = getUnmarshaler(tag, f.Type)
+		field.unmarshal, err = getUnmarshaler(tag, f.Type, field.isPointer)
 		if err != nil {
 			return nil, errgo.Mask(err)
 		}
+		if tag.readOnly {
+			field.unmarshal = unmarshalReadOnly
+		}
 
 		field.marshal, err = getMarshaler(tag, f.Type)
 		if err != nil {
@@ -202,6 +297,22 @@ func parseRequestType(t reflect.Type) (*requestType, error) {
 	return &pt, nil
 }
 
+// isMarkerTag reports whether the given tag on an anonymous field
+// of type t acts only as a marker. This is true of a path, form or
+// header tag on a struct (or pointer to struct) type that cannot be
+// marshaled and unmarshaled as text.
+func isMarkerTag(tag tag, t reflect.Type) bool {
+	switch tag.source {
+	case sourcePath, sourceForm, sourceHeader:
+	default:
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !implementsTextUnmarshaler(t) && !implementsTextMarshaler(t)
+}
+
 // withinIndex reports whether the field with index i0 should be
 // considered to be within the field with index i1.
 func withinIndex(i0, i1 []int) bool {
@@ -219,21 +330,27 @@ func withinIndex(i0, i1 []int) bool {
 	return true
 }
 
-// Note: we deliberately omit HEAD and OPTIONS
-// from this list. HEAD will be routed through GET handlers
-// and OPTIONS is handled separately.
+// Note: we deliberately omit HEAD from this list, as it will be
+// routed through GET handlers. HEAD may however be listed alongside
+// GET in a route with several methods. OPTIONS is usually handled
+// separately (see Server.HandleOPTIONS), but may be specified for
+// a path that needs its own OPTIONS handler, in which case no
+// OPTIONS handler is added for that path.
 var validMethod = map[string]bool{
-	"PUT":    true,
-	"POST":   true,
-	"DELETE": true,
-	"GET":    true,
-	"PATCH":  true,
+	"PUT":     true,
+	"POST":    true,
+	"DELETE":  true,
+	"GET":     true,
+	"PATCH":   true,
+	"OPTIONS": true,
 }
 
-func parseRouteTag(tag reflect.StructTag) (method, path string, err error) {
+// parseRouteTag parses the tag on a Route field. The method may be a
+// comma-separated list of methods, such as "GET,HEAD".
+func parseRouteTag(tag reflect.StructTag) (methods []string, path string, err error) {
 	tagStr := tag.Get("httprequest")
 	if tagStr == "" {
-		return "", "", errgo.New("no httprequest tag")
+		return nil, "", errgo.New("no httprequest tag")
 	}
 	f := strings.Fields(tagStr)
 	switch len(f) {
@@ -241,15 +358,25 @@ func parseRouteTag(tag reflect.StructTag) (method, path string, err error) {
 		path = f[1]
 		fallthrough
 	case 1:
-		method = f[0]
+		methods = strings.Split(f[0], ",")
 	default:
-		return "", "", errgo.New("wrong field count")
+		return nil, "", errgo.New("wrong field count")
+	}
+	found := make(map[string]bool)
+	for _, method := range methods {
+		if !validMethod[method] && (method != "HEAD" || len(methods) == 1) {
+			return nil, "", errgo.Newf("invalid method")
+		}
+		if found[method] {
+			return nil, "", errgo.Newf("duplicate method %s", method)
+		}
+		found[method] = true
 	}
-	if !validMethod[method] {
-		return "", "", errgo.Newf("invalid method")
+	if found["HEAD"] && !found["GET"] {
+		return nil, "", errgo.Newf("HEAD method specified without GET")
 	}
 	// TODO check that path looks valid
-	return method, path, nil
+	return methods, path, nil
 }
 
 func makePointerResult(v reflect.Value) reflect.Value {
@@ -271,27 +398,125 @@ const (
 	sourceForm
 	sourceBody
 	sourceHeader
+	sourceAllForm
+	sourceMethod
+	sourceRawQuery
+	sourceBasicUser
+	sourceBasicPass
+	sourceMatrix
+	sourceHost
+
+	// sourceStatus and sourceResponseHeader are
+	// only valid in response types.
+	sourceStatus
+	sourceResponseHeader
 )
 
 type tag struct {
-	name      string
+	name string
+	// aliases holds any alternative names for the field.
+	// They are tried in order when unmarshaling if no
+	// value is found for name, but are never used
+	// when marshaling.
+	aliases   []string
 	source    tagSource
 	omitempty bool
+
+	// emptyAsAbsent holds whether an empty value
+	// should be treated as if there were no value.
+	emptyAsAbsent bool
+
+	// min and max hold the values of any httpmin
+	// and httpmax tags on the field.
+	min, max string
+
+	// contentType holds the value of any httpcontenttype
+	// tag on the field.
+	contentType string
+
+	// base holds the numeric base specified by any
+	// httpbase tag on the field, or zero if there is none.
+	base int
+
+	// yaml holds whether a body field is encoded as YAML
+	// rather than JSON.
+	yaml bool
+
+	// addSlash holds whether a "/" should be added to
+	// the start of a marshaled path parameter value
+	// that does not already start with one.
+	addSlash bool
+
+	// readOnly holds whether the field is ignored
+	// when unmarshaling.
+	readOnly bool
+
+	// matrixParam holds the value of any httpmatrix tag
+	// on a matrix field: the name of the path parameter
+	// whose segment holds the matrix parameter.
+	matrixParam string
+
+	// hasMatrix holds whether the segment of a path
+	// parameter may hold matrix parameters, which
+	// are removed from its value when unmarshaling.
+	hasMatrix bool
+}
+
+// describe returns a description of the source of the
+// tag's value, suitable for use in error messages.
+func (t tag) describe() string {
+	switch t.source {
+	case sourcePath:
+		return fmt.Sprintf("path parameter %q", t.name)
+	case sourceForm:
+		return fmt.Sprintf("form field %q", t.name)
+	case sourceHeader:
+		return fmt.Sprintf("header %q", t.name)
+	case sourceMatrix:
+		return fmt.Sprintf("matrix parameter %q of path parameter %q", t.name, t.matrixParam)
+	}
+	return fmt.Sprintf("%q", t.name)
+}
+
+// names returns all the names that the tag's value
+// may be found under, in order of preference.
+func (t tag) names() []string {
+	return append([]string{t.name}, t.aliases...)
 }
 
 // parseTag parses the given struct tag attached to the given
 // field name into a tag structure.
 func parseTag(rtag reflect.StructTag, fieldName string) (tag, error) {
 	t := tag{
-		name: fieldName,
+		name:        fieldName,
+		min:         rtag.Get("httpmin"),
+		max:         rtag.Get("httpmax"),
+		contentType: rtag.Get("httpcontenttype"),
+		matrixParam: rtag.Get("httpmatrix"),
+	}
+	if b := rtag.Get("httpbase"); b != "" {
+		base, err := strconv.Atoi(b)
+		if err != nil || base < 2 || base > 36 {
+			return tag{}, fmt.Errorf("invalid httpbase value %q", b)
+		}
+		t.base = base
 	}
 	tagStr := rtag.Get("httprequest")
 	if tagStr == "" {
+		if t.base != 0 {
+			return tag{}, fmt.Errorf("can only use httpbase with path, form or header fields")
+		}
 		return t, nil
 	}
 	fields := strings.Split(tagStr, ",")
 	if fields[0] != "" {
-		t.name = fields[0]
+		names := strings.Split(fields[0], "|")
+		for _, name := range names {
+			if name == "" {
+				return tag{}, fmt.Errorf("empty name in %q", fields[0])
+			}
+		}
+		t.name, t.aliases = names[0], names[1:]
 	}
 	for _, f := range fields[1:] {
 		switch f {
@@ -303,8 +528,34 @@ func parseTag(rtag reflect.StructTag, fieldName string) (tag, error) {
 			t.source = sourceBody
 		case "header":
 			t.source = sourceHeader
+		case "allform":
+			t.source = sourceAllForm
+		case "method":
+			t.source = sourceMethod
+		case "rawquery":
+			t.source = sourceRawQuery
+		case "basicuser":
+			t.source = sourceBasicUser
+		case "basicpass":
+			t.source = sourceBasicPass
+		case "matrix":
+			t.source = sourceMatrix
+		case "host":
+			t.source = sourceHost
+		case "status":
+			t.source = sourceStatus
+		case "responseheader":
+			t.source = sourceResponseHeader
 		case "omitempty":
 			t.omitempty = true
+		case "treatemptyasabsent":
+			t.emptyAsAbsent = true
+		case "yaml":
+			t.yaml = true
+		case "addslash":
+			t.addSlash = true
+		case "readonly":
+			t.readOnly = true
 		default:
 			return tag{}, fmt.Errorf("unknown tag flag %q", f)
 		}
@@ -312,6 +563,33 @@ func parseTag(rtag reflect.StructTag, fieldName string) (tag, error) {
 	if t.omitempty && t.source != sourceForm && t.source != sourceHeader {
 		return tag{}, fmt.Errorf("can only use omitempty with form or header fields")
 	}
+	if t.emptyAsAbsent && t.source != sourceForm && t.source != sourceHeader {
+		return tag{}, fmt.Errorf("can only use treatemptyasabsent with form or header fields")
+	}
+	if t.base != 0 && t.source != sourcePath && t.source != sourceForm && t.source != sourceHeader {
+		return tag{}, fmt.Errorf("can only use httpbase with path, form or header fields")
+	}
+	if t.readOnly && t.source == sourceNone {
+		return tag{}, fmt.Errorf("can only use readonly with a field source")
+	}
+	if t.addSlash && t.source != sourcePath {
+		return tag{}, fmt.Errorf("can only use addslash with path fields")
+	}
+	if t.source == sourceMatrix && t.matrixParam == "" {
+		return tag{}, fmt.Errorf("matrix field requires httpmatrix tag")
+	}
+	if t.matrixParam != "" && t.source != sourceMatrix {
+		return tag{}, fmt.Errorf("can only use httpmatrix with matrix fields")
+	}
+	if t.yaml && t.source != sourceBody {
+		return tag{}, fmt.Errorf("can only use yaml with body fields")
+	}
+	if t.contentType != "" && t.source != sourceBody {
+		return tag{}, fmt.Errorf("can only use httpcontenttype with body fields")
+	}
+	if len(t.aliases) > 0 && t.source != sourcePath && t.source != sourceForm && t.source != sourceHeader {
+		return tag{}, fmt.Errorf("can only use alternative names with path, form or header fields")
+	}
 	return t, nil
 }
 
@@ -1,10 +1,19 @@
 package httprequest
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/errgo.v1"
 )
@@ -14,15 +23,81 @@ var (
 	ErrBadUnmarshalType = errgo.New("httprequest bad unmarshal type")
 )
 
+// The following errors identify more specifically why a request
+// could not be unmarshaled. An unmarshal error still has an
+// ErrUnmarshal cause, but one of these errors can be found by
+// calling UnmarshalErrorCause.
+var (
+	// ErrFormParse is used when the request form
+	// cannot be parsed or is too large.
+	ErrFormParse = errgo.New("httprequest form parse error")
+
+	// ErrBodyDecode is used when the request body
+	// cannot be decoded.
+	ErrBodyDecode = errgo.New("httprequest body decode error")
+
+	// ErrFieldParse is used when a path, form or header
+	// value cannot be parsed into its field.
+	ErrFieldParse = errgo.New("httprequest field parse error")
+)
+
+// UnmarshalErrorCause returns the most specific cause of an error
+// returned when unmarshaling a request. This is ErrFormParse,
+// ErrBodyDecode or ErrFieldParse if the error was produced with one
+// of those causes; otherwise it is the same as errgo.Cause(err).
+//
+// It can be used by a Server.ErrorMapper to distinguish between
+// different kinds of bad request, for example:
+//
+//	switch httprequest.UnmarshalErrorCause(err) {
+//	case httprequest.ErrBodyDecode:
+//		...
+//	case httprequest.ErrUnmarshal:
+//		...
+//	}
+func UnmarshalErrorCause(err error) error {
+	for e := err; e != nil; {
+		if c, ok := e.(errgo.Causer); ok {
+			switch cause := c.Cause(); cause {
+			case ErrFormParse, ErrBodyDecode, ErrFieldParse:
+				return cause
+			}
+		}
+		w, ok := e.(errgo.Wrapper)
+		if !ok {
+			break
+		}
+		e = w.Underlying()
+	}
+	return errgo.Cause(err)
+}
+
+// unmarshalErrorf returns an error with an ErrUnmarshal cause that
+// also records the given more specific cause so that it can be found
+// by UnmarshalErrorCause. The error message is formatted as for
+// errgo.WithCausef.
+func unmarshalErrorf(underlying, cause error, f string, a ...interface{}) error {
+	err := &errgo.Err{
+		Underlying_: errgo.WithCausef(underlying, cause, f, a...),
+		Cause_:      ErrUnmarshal,
+	}
+	err.SetLocation(1)
+	return err
+}
+
 // Unmarshal takes values from given parameters and fills
 // out fields in x, which must be a pointer to a struct.
 //
 // Tags on the struct's fields determine where each field is filled in
 // from. Similar to encoding/json and other encoding packages, the tag
 // holds a comma-separated list. The first item in the list is an
-// alternative name for the field (the field name itself will be used if
-// this is empty). The next item specifies where the field is filled in
-// from. It may be:
+// alternative name for the field (the field name itself will be used
+// if this is empty, or the name chosen by the type's
+// HTTPRequestFieldName method if it implements FieldNamer). For path,
+// form and header fields, this may hold several names separated by
+// "|"; each name is tried in turn and the first one with a value is
+// used. The next item specifies where the field is filled in from. It
+// may be:
 //
 //	"path" - the field is taken from a parameter in p.PathVar
 //		with a matching field name.
@@ -31,29 +106,237 @@ var (
 //		(note that this covers both URL query parameters and
 //		POST form parameters).
 //
+//	"matrix" - the field is taken from the matrix parameter with the
+//		given name in a path segment, such as "version" in
+//		"/thing;version=2/sub". The segment is the one matched by
+//		the path parameter named by an "httpmatrix" tag, which
+//		is required, so a route of "/:thing/sub" would use:
+//
+//			Version int `httprequest:"version,matrix" httpmatrix:"thing"`
+//
+//		Any matrix parameters are removed from the value of a
+//		path field for that parameter. Note that as path
+//		parameters are decoded before matching, neither the value
+//		of the path parameter nor those of its matrix parameters
+//		may contain a semicolon.
+//
 //	"header" - the field is taken from the given name in
-//		p.Request.Header.
+//		p.Request.Header. The name is matched case-insensitively,
+//		so a name such as "X-Request-ID" will match the canonical
+//		"X-Request-Id" header key.
+//
+//	"allform" - the field, which must be of type url.Values, is
+//		set to a copy of all the values in p.Request.Form,
+//		including those that are also used to fill out other
+//		fields.
+//
+//	"method" - the field, which must be of string type, is set
+//		to p.Request.Method. This is mainly useful when the same
+//		type is used for requests with several methods, for
+//		example when routing has been done manually rather than
+//		with a Route field. The field is ignored by Marshal.
+//
+//	"rawquery" - the field, which must be of string type, is set
+//		to the raw, undecoded URL query, p.Request.URL.RawQuery.
+//		This can be useful when the exact query is needed, for
+//		example to verify a signature over it. Other form fields
+//		are filled out as usual.
+//
+//	"basicuser", "basicpass" - the field, which must be of string
+//		type, is set to the user name or password respectively
+//		from the request's HTTP Basic Authentication credentials
+//		(see http.Request.BasicAuth). The field is left unchanged
+//		if the request has no such credentials.
+//
+//	"host" - the field, which must be of string type, is set to
+//		p.Request.Host, the host (and port, if any) that the
+//		request was addressed to. As described in the http.Request
+//		documentation, this is taken from the Host header or, for
+//		a request with an absolute URL, from the URL. This can be
+//		used, for example, to select a tenant by host name.
 //
 //	"body" - the field is filled in by parsing the request body
-//		as JSON.
+//		as JSON. If the field is a pointer and the request
+//		body is empty, the field will be left as nil. If the
+//		field is of interface type, the concrete type to use may
+//		be chosen by a field in the body; see RegisterBodyType.
+//		If a codec has been registered for the field's type
+//		with RegisterBodyCodec, it is used instead of JSON.
+//		If the field is of type *json.Decoder, the body is not
+//		read at all; instead the field is set to a decoder that
+//		reads from it, so that a handler can process a body
+//		too large to hold in memory, such as a stream of
+//		newline-delimited JSON values, one value at a time.
+//		The request must have a JSON content type, or the
+//		content type given by an "httpcontenttype" tag on the
+//		field, if any.
+//
+// A "yaml" attribute on a body field specifies that the body is YAML
+// rather than JSON, for example:
+//
+//	Config Config `httprequest:",body,yaml"`
+//
+// The body is then unmarshaled with gopkg.in/yaml.v2, and the request
+// must have a YAML content type (application/yaml, text/yaml or
+// their "x-yaml" variants) or the content type given by an
+// "httpcontenttype" tag on the field. Note that yaml.v2 uses the
+// "yaml" struct tag and lower-cased field names by default, not the
+// "json" tag, and that codecs registered with RegisterBodyCodec are
+// not used for YAML bodies.
+//
+// A "treatemptyasabsent" attribute on a form or header field specifies
+// that an empty value should be treated as if no value had been
+// provided, leaving the field unchanged. For example, with:
+//
+//	Limit int `httprequest:"limit,form,treatemptyasabsent"`
+//
+// a URL query of "?limit=" will leave Limit as zero rather than
+// causing an error.
+//
+// A form field of bool kind is set to true when its value is present
+// but empty, so that it can be used for a query flag that has no
+// value. For example, with:
+//
+//	Verbose bool `httprequest:"verbose,form"`
+//
+// a URL query of "?verbose" sets Verbose to true, while a query with
+// no "verbose" parameter leaves it false. A field that also has the
+// "treatemptyasabsent" attribute is left unchanged instead.
+//
+// A "readonly" attribute specifies that the field is never filled in
+// by Unmarshal, so that it is left unchanged, even if the request
+// holds a value for it. It is still marshaled by Marshal. This can
+// be used to stop a client setting a field such as an ID or a
+// timestamp that only the server should set. For example:
+//
+//	ID string `httprequest:"id,form,readonly"`
 //
 // For path and form parameters, the field will be filled out from
 // the field in p.PathVar or p.Form using one of the following
 // methods (in descending order of preference):
 //
-// - if the type is string, it will be set from the first value.
+// - if the type is string, it will be set from the first value,
+//    except that a header field is set to all the values of
+//    the header joined with ", ", so that a header that
+//    appears several times, such as Accept, is not truncated.
 //
 // - if the type is []string, it will be filled out using all values for that field
-//    (allowed only for form)
+//    (allowed only for form and header)
+//
+// - if the type implements FormValueParser, its ParseFormValue
+//    method will be called with the first value
 //
 // - if the type implements encoding.TextUnmarshaler, its
 // UnmarshalText method will be used
 //
+// - if the type has a string kind and implements AllowedValuer,
+//    it will be set from the first value, which must be one of
+//    the values returned by its AllowedValues method.
+//
+// - if the type is any other slice type, it will be filled out using all
+//    values for that field, each element being set as for a
+//    non-slice field (allowed only for form and header)
+//
+// - if the type is time.Duration, the value will be parsed
+//    with time.ParseDuration, so it must include a unit,
+//    as in "1m30s"
+//
 // -  otherwise fmt.Sscan will be used to set the value.
 //
+// A time.Duration field is not otherwise treated specially; in
+// particular a timeout specified by the client is not applied to
+// the handler's context, but a handler may do that itself with
+// context.WithTimeout, for example:
+//
+//	ctx, cancel := context.WithTimeout(p.Context, req.Timeout)
+//	defer cancel()
+//
+// A path, form or header field of pointer type is left as nil if
+// there is no value for it, and otherwise is set to point to a newly
+// allocated value that is filled out as described above. This makes
+// it possible to distinguish an absent value from a zero one. Note
+// that while the UnmarshalText method of a non-pointer field is
+// called with an empty value when there is no value, it is not called
+// at all for a pointer field.
+//
+// A numeric path, form or header field filled out with fmt.Sscan or
+// time.ParseDuration may also have "httpmin" and "httpmax" tags
+// specifying the minimum and maximum values allowed for it, which
+// are parsed in the same way as the field's value, for example:
+//
+//	ID int `httprequest:"id,path" httpmin:"1"`
+//	Timeout time.Duration `httprequest:"timeout,form" httpmax:"5m"`
+//
+// A value outside that range causes an unmarshal error that
+// names the parameter.
+//
+// An integer path, form or header field may have an "httpbase" tag
+// specifying the numeric base, between 2 and 36, of its value. The
+// value is then parsed with strconv.ParseInt or strconv.ParseUint
+// rather than fmt.Sscan, and is formatted in the same base by
+// Marshal. For example, a hexadecimal identifier may be declared as:
+//
+//	ID uint64 `httprequest:"id,path" httpbase:"16"`
+//
+// Any httpmin and httpmax tags on such a field are still
+// parsed with fmt.Sscan.
+//
+// Path parameter values are passed to the field exactly as they are
+// found in p.PathVar, after URL path unescaping but with no other
+// processing. A path segment holding a composite key, such as
+// "owner~name", can be unmarshaled into several values by using a
+// type that implements encoding.TextUnmarshaler to split it, and
+// encoding.TextMarshaler to join it again for Marshal:
+//
+//	type RepoID struct {
+//		Owner, Name string
+//	}
+//
+//	func (id *RepoID) UnmarshalText(data []byte) error {
+//		parts := strings.SplitN(string(data), "~", 2)
+//		if len(parts) != 2 {
+//			return errgo.Newf("invalid repository id %q", data)
+//		}
+//		id.Owner, id.Name = parts[0], parts[1]
+//		return nil
+//	}
+//
+//	func (id RepoID) MarshalText() ([]byte, error) {
+//		return []byte(id.Owner + "~" + id.Name), nil
+//	}
+//
+//	type GetRepoReq struct {
+//		httprequest.Route `httprequest:"GET /repos/:id"`
+//		ID RepoID `httprequest:"id,path"`
+//	}
+//
+// The separator should be a character that cannot occur in the
+// components of the key or that is escaped by the type, and should
+// not be "/", because the router splits paths at slashes before
+// unescaping them.
+//
+// Fields in anonymous struct members are filled out as if they were
+// fields of x itself. If an anonymous field has a "path", "form" or
+// "header" tag and its type implements encoding.TextUnmarshaler, it is
+// instead filled out as a single value and the fields within it are
+// ignored. On an anonymous struct that does not implement
+// encoding.TextUnmarshaler or encoding.TextMarshaler, such a tag is
+// just a marker and its fields are filled out as usual, so a
+// struct holding common parameters may be embedded as:
+//
+//	type CommonParams struct {
+//		Limit int `httprequest:"limit,form"`
+//	}
+//
+//	type ListReq struct {
+//		CommonParams `httprequest:",form"`
+//	}
+//
 // When the unmarshaling fails, Unmarshal returns an error with an
-// ErrUnmarshal cause. If the type of x is inappropriate,
-// it returns an error with an ErrBadUnmarshalType cause.
+// ErrUnmarshal cause. It returns as soon as a field cannot be
+// unmarshaled; see UnmarshalAll to report all such fields. If the
+// type of x is inappropriate, it returns an error with an
+// ErrBadUnmarshalType cause.
 func Unmarshal(p Params, x interface{}) error {
 	xv := reflect.ValueOf(x)
 	pt, err := getRequestType(xv.Type())
@@ -66,45 +349,173 @@ func Unmarshal(p Params, x interface{}) error {
 	return nil
 }
 
+// Bind unmarshals the request in p into x as Unmarshal does. It can be
+// used within a handler to unmarshal the request into a struct other
+// than the handler's argument, such as one holding a subset of the
+// request parameters.
+//
+// Note that the request body can only be read once, so x should
+// not have a body field if the body has already been read, for
+// example into a body field of the handler's argument.
+func (p Params) Bind(x interface{}) error {
+	return errgo.Mask(Unmarshal(p, x), errgo.Any)
+}
+
+// ParamsUnmarshaler may be implemented by a type passed to Unmarshal,
+// such as the argument type of a handler, to unmarshal itself from
+// the request parameters. If it is implemented, UnmarshalParams is
+// called instead of filling out the fields as specified by their tags,
+// avoiding the cost of reflection; the tags are still used for routing
+// and by Marshal. This can be used with hand-written or generated code
+// to speed up performance-critical handlers.
+//
+// If UnmarshalParams returns an error that does not have an
+// ErrUnmarshal cause, Unmarshal returns it with that cause.
+type ParamsUnmarshaler interface {
+	UnmarshalParams(p Params) error
+}
+
+var paramsUnmarshalerType = reflect.TypeOf((*ParamsUnmarshaler)(nil)).Elem()
+
+// unmarshalParams unmarshals into xv, which must
+// implement ParamsUnmarshaler.
+func unmarshalParams(p Params, xv reflect.Value) error {
+	err := xv.Interface().(ParamsUnmarshaler).UnmarshalParams(p)
+	if err == nil {
+		return nil
+	}
+	if errgo.Cause(err) == ErrUnmarshal {
+		return errgo.Mask(err, errgo.Is(ErrUnmarshal))
+	}
+	return errgo.WithCausef(err, ErrUnmarshal, "")
+}
+
 // unmarshal is the internal version of Unmarshal.
 func unmarshal(p Params, xv reflect.Value, pt *requestType) error {
+	if pt.unmarshalParams {
+		return unmarshalParams(p, xv)
+	}
 	xv = xv.Elem()
 	for _, f := range pt.fields {
 		fv := xv.FieldByIndex(f.index)
 		if err := f.unmarshal(fv, p, f.makeResult); err != nil {
-			return errgo.WithCausef(err, ErrUnmarshal, "cannot unmarshal into field %s", f.name)
+			return unmarshalErrorf(err, fieldErrorCause(f), "cannot unmarshal into field %s", f.name)
 		}
 	}
 	return nil
 }
 
+// fieldErrorCause returns the specific cause
+// of an error unmarshaling the given field.
+func fieldErrorCause(f field) error {
+	if f.source == sourceBody {
+		return ErrBodyDecode
+	}
+	return ErrFieldParse
+}
+
 // getUnmarshaler returns an unmarshaler function
 // suitable for unmarshaling a field with the given tag
-// into a value of the given type.
-func getUnmarshaler(tag tag, t reflect.Type) (unmarshaler, error) {
+// into a value of the given type. If isPointer is true,
+// the field holds a pointer to a value of that type.
+func getUnmarshaler(tag tag, t reflect.Type, isPointer bool) (unmarshaler, error) {
+	if tag.base != 0 || tag.min != "" || tag.max != "" {
+		var check func(reflect.Value) error
+		if tag.min != "" || tag.max != "" {
+			var err error
+			check, err = rangeChecker(tag, t)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+		if tag.base != 0 {
+			if !isIntegerKind(t.Kind()) {
+				return nil, errgo.Newf("httpbase cannot be used on type %s", t)
+			}
+			return unmarshalWithBase(t, tag, check), nil
+		}
+		return unmarshalWithScan(t, tag, check), nil
+	}
 	switch {
 	case tag.source == sourceNone:
 		return unmarshalNop, nil
+	case tag.source == sourceBody && t == jsonDecoderType:
+		if !isPointer || tag.yaml {
+			return nil, errgo.Newf("streaming body field must be of type *json.Decoder")
+		}
+		return unmarshalStreamingBody(tag.contentType), nil
+	case tag.source == sourceBody && tag.yaml:
+		if t.Kind() == reflect.Interface {
+			return nil, errgo.Newf("yaml cannot be used with interface type %s", t)
+		}
+		return unmarshalYAMLBody(tag.contentType, isPointer), nil
+	case tag.source == sourceBody && isPointer:
+		return unmarshalOptionalBody(tag.contentType), nil
+	case tag.source == sourceBody && t.Kind() == reflect.Interface:
+		return unmarshalInterfaceBody(t, tag.contentType), nil
 	case tag.source == sourceBody:
-		return unmarshalBody, nil
+		return unmarshalBody(tag.contentType), nil
+	case tag.source == sourceAllForm:
+		if t != reflect.TypeOf(url.Values(nil)) {
+			return nil, errgo.Newf("invalid target type %s for allform field; need url.Values", t)
+		}
+		return unmarshalAllForm, nil
+	case tag.source == sourceMethod:
+		if t.Kind() != reflect.String {
+			return nil, errgo.Newf("invalid target type %s for method field; need string", t)
+		}
+		return unmarshalMethod, nil
+	case tag.source == sourceRawQuery:
+		if t.Kind() != reflect.String {
+			return nil, errgo.Newf("invalid target type %s for rawquery field; need string", t)
+		}
+		return unmarshalRawQuery, nil
+	case tag.source == sourceHost:
+		if t.Kind() != reflect.String {
+			return nil, errgo.Newf("invalid target type %s for host field; need string", t)
+		}
+		return unmarshalHost, nil
+	case tag.source == sourceBasicUser, tag.source == sourceBasicPass:
+		if t.Kind() != reflect.String {
+			return nil, errgo.Newf("invalid target type %s for basic auth field; need string", t)
+		}
+		return unmarshalBasicAuth(tag.source == sourceBasicUser), nil
 	case t == reflect.TypeOf([]string(nil)):
 		switch tag.source {
 		default:
 			return nil, errgo.New("invalid target type []string for path parameter")
 		case sourceForm:
-			return unmarshalAllField(tag.name), nil
+			return unmarshalAllField(tag.names()), nil
 		case sourceHeader:
-			return unmarshalAllHeader(tag.name), nil
+			return unmarshalAllHeader(tag.names()), nil
 		}
 	case t == reflect.TypeOf(""):
 		return unmarshalString(tag), nil
+	case implementsFormValueParser(t):
+		return unmarshalWithParseFormValue(t, tag), nil
 	case implementsTextUnmarshaler(t):
-		return unmarshalWithUnmarshalText(t, tag), nil
+		return unmarshalWithUnmarshalText(t, tag, isPointer), nil
+	case t.Kind() == reflect.String && t.Implements(allowedValuerType):
+		return unmarshalAllowedValue(t, tag), nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+		if tag.source != sourceForm && tag.source != sourceHeader {
+			return nil, errgo.Newf("invalid target type %s for path parameter", t)
+		}
+		return unmarshalSlice(t, tag), nil
+	case t.Kind() == reflect.Bool && tag.source == sourceForm:
+		return unmarshalFormBool(t, tag), nil
 	default:
-		return unmarshalWithScan(tag), nil
+		return unmarshalWithScan(t, tag, nil), nil
 	}
 }
 
+// unmarshalReadOnly is used for a field with the readonly
+// attribute. Unlike unmarshalNop, it does not create the
+// result value, so a pointer field is left nil.
+func unmarshalReadOnly(v reflect.Value, p Params, makeResult resultMaker) error {
+	return nil
+}
+
 // unmarshalNop just creates the result value but does not
 // fill it out with anything. This is used to create pointers
 // to new anonymous field members.
@@ -113,38 +524,161 @@ func unmarshalNop(v reflect.Value, p Params, makeResult resultMaker) error {
 	return nil
 }
 
+// unmarshalMethod unmarshals the request method
+// into a string field.
+func unmarshalMethod(v reflect.Value, p Params, makeResult resultMaker) error {
+	makeResult(v).SetString(p.Request.Method)
+	return nil
+}
+
+// unmarshalRawQuery unmarshals the raw URL query
+// of the request into a string field.
+func unmarshalRawQuery(v reflect.Value, p Params, makeResult resultMaker) error {
+	if p.Request.URL != nil {
+		makeResult(v).SetString(p.Request.URL.RawQuery)
+	}
+	return nil
+}
+
+// unmarshalHost unmarshals the host that the
+// request was addressed to into a string field.
+func unmarshalHost(v reflect.Value, p Params, makeResult resultMaker) error {
+	makeResult(v).SetString(p.Request.Host)
+	return nil
+}
+
+// unmarshalBasicAuth returns an unmarshaler that unmarshals the
+// user name (if user is true) or password from the request's basic
+// authentication credentials into a string field. The field is left
+// unchanged if the request has no such credentials.
+func unmarshalBasicAuth(user bool) unmarshaler {
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		username, password, ok := p.Request.BasicAuth()
+		if !ok {
+			return nil
+		}
+		if user {
+			makeResult(v).SetString(username)
+		} else {
+			makeResult(v).SetString(password)
+		}
+		return nil
+	}
+}
+
+// unmarshalAllForm unmarshals a copy of all the form
+// values in the request into a url.Values field.
+func unmarshalAllForm(v reflect.Value, p Params, makeResult resultMaker) error {
+	if len(p.Request.Form) == 0 {
+		return nil
+	}
+	form := make(url.Values, len(p.Request.Form))
+	for k, vs := range p.Request.Form {
+		form[k] = append([]string(nil), vs...)
+	}
+	makeResult(v).Set(reflect.ValueOf(form))
+	return nil
+}
+
 // unmarshalAllField unmarshals all the form fields for a given
-// attribute into a []string slice.
-func unmarshalAllField(name string) unmarshaler {
+// attribute into a []string slice. The values are taken
+// from the first of the given names that has any values.
+func unmarshalAllField(names []string) unmarshaler {
 	return func(v reflect.Value, p Params, makeResult resultMaker) error {
-		vals := p.Request.Form[name]
-		if len(vals) > 0 {
-			makeResult(v).Set(reflect.ValueOf(vals))
+		for _, name := range names {
+			if vals := p.Request.Form[name]; len(vals) > 0 {
+				makeResult(v).Set(reflect.ValueOf(vals))
+				return nil
+			}
 		}
 		return nil
 	}
 }
 
 // unmarshalAllHeader unmarshals all the header fields for a given
-// attribute into a []string slice.
-func unmarshalAllHeader(name string) unmarshaler {
+// attribute into a []string slice. The values are taken
+// from the first of the given names that has any values.
+func unmarshalAllHeader(names []string) unmarshaler {
 	return func(v reflect.Value, p Params, makeResult resultMaker) error {
-		vals := p.Request.Header[name]
-		if len(vals) > 0 {
-			makeResult(v).Set(reflect.ValueOf(vals))
+		for _, name := range names {
+			if vals := headerValues(p.Request.Header, name); len(vals) > 0 {
+				makeResult(v).Set(reflect.ValueOf(vals))
+				return nil
+			}
 		}
 		return nil
 	}
 }
 
+// unmarshalSlice unmarshals all the form or header values for the
+// given tag into a slice, unmarshaling each value into an element
+// in the same way that a non-slice field would be unmarshaled.
+// If the elements are pointers, a new value is allocated for each one.
+func unmarshalSlice(t reflect.Type, tag tag) unmarshaler {
+	names := tag.names()
+	getVals := func(p Params) []string {
+		for _, name := range names {
+			var vals []string
+			if tag.source == sourceHeader {
+				vals = headerValues(p.Request.Header, name)
+			} else {
+				vals = p.Request.Form[name]
+			}
+			if len(vals) > 0 {
+				return vals
+			}
+		}
+		return nil
+	}
+	elemType := t.Elem()
+	elemIsPointer := elemType.Kind() == reflect.Ptr
+	if elemIsPointer {
+		elemType = elemType.Elem()
+	}
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		vals := getVals(p)
+		if len(vals) == 0 {
+			return nil
+		}
+		sv := reflect.MakeSlice(t, len(vals), len(vals))
+		for i, val := range vals {
+			ev := sv.Index(i)
+			if elemIsPointer {
+				ev.Set(reflect.New(elemType))
+				ev = ev.Elem()
+			}
+			switch {
+			case implementsFormValueParser(elemType):
+				if err := ev.Addr().Interface().(FormValueParser).ParseFormValue(val); err != nil {
+					return errgo.Notef(err, "cannot parse %s value %q into %s", tag.describe(), val, elemType)
+				}
+			case implementsTextUnmarshaler(elemType):
+				if err := ev.Addr().Interface().(encodingTextUnmarshaler).UnmarshalText([]byte(val)); err != nil {
+					return errgo.Mask(err)
+				}
+			case elemType.Kind() == reflect.String:
+				ev.SetString(val)
+			default:
+				if err := parseValue(val, ev); err != nil {
+					return errgo.Notef(err, "cannot parse %s value %q into %s", tag.describe(), val, elemType)
+				}
+			}
+		}
+		makeResult(v).Set(sv)
+		return nil
+	}
+}
+
 // unmarshalString unmarshals into a string field.
+// A header field is set to all the values of the header
+// joined with commas.
 func unmarshalString(tag tag) unmarshaler {
-	getVal := formGetters[tag.source]
-	if getVal == nil {
-		panic("unexpected source")
+	getVal := formGetter(tag)
+	if tag.source == sourceHeader {
+		getVal = namesGetter(tag, joinedHeaderValue)
 	}
 	return func(v reflect.Value, p Params, makeResult resultMaker) error {
-		val, ok := getVal(tag.name, p)
+		val, ok := getVal(p)
 		if ok {
 			makeResult(v).SetString(val)
 		}
@@ -152,26 +686,145 @@ func unmarshalString(tag tag) unmarshaler {
 	}
 }
 
-// unmarshalBody unmarshals the http request body
-// into the given value.
-func unmarshalBody(v reflect.Value, p Params, makeResult resultMaker) error {
-	if !isJSONMediaType(p.Request.Header) {
-		fancyErr := newFancyDecodeError(p.Request.Header, p.Request.Body)
+// unmarshalBody returns an unmarshaler that unmarshals the http
+// request body into the given value. If contentType is non-empty,
+// it is accepted as the request content type as well as
+// application/json.
+func unmarshalBody(contentType string) unmarshaler {
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		if !isBodyMediaType(p.Request.Header, contentType) {
+			fancyErr := newFancyDecodeError(p.Request.Header, p.Request.Body)
 
-		return newDecodeRequestError(p.Request, fancyErr.body, fancyErr)
+			return newDecodeRequestError(p.Request, fancyErr.body, fancyErr)
+		}
+		data, err := ioutil.ReadAll(p.Request.Body)
+		if err != nil {
+			return errgo.Notef(err, "cannot read request body")
+		}
+		return unmarshalBodyData(data, makeResult(v))
 	}
-	data, err := ioutil.ReadAll(p.Request.Body)
-	if err != nil {
-		return errgo.Notef(err, "cannot read request body")
+}
+
+var jsonDecoderType = reflect.TypeOf(json.Decoder{})
+
+// unmarshalStreamingBody returns an unmarshaler that sets a
+// *json.Decoder field to a decoder that reads from the request body,
+// without reading any of the body itself. The contentType argument is
+// as for unmarshalBody.
+func unmarshalStreamingBody(contentType string) unmarshaler {
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		if !isBodyMediaType(p.Request.Header, contentType) {
+			fancyErr := newFancyDecodeError(p.Request.Header, p.Request.Body)
+			return newDecodeRequestError(p.Request, fancyErr.body, fancyErr)
+		}
+		var body io.Reader = p.Request.Body
+		if p.Request.Body == nil {
+			body = emptyReader
+		}
+		// The field is always a pointer, so set it directly
+		// rather than copying the decoder into a new one.
+		v.Set(reflect.ValueOf(json.NewDecoder(body)))
+		return nil
+	}
+}
+
+// unmarshalOptionalBody is like unmarshalBody except that
+// when the request body is empty the value is left
+// untouched, so a pointer field will remain nil.
+func unmarshalOptionalBody(contentType string) unmarshaler {
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		if p.Request.Body == nil {
+			return nil
+		}
+		data, err := ioutil.ReadAll(p.Request.Body)
+		if err != nil {
+			return errgo.Notef(err, "cannot read request body")
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		if !isBodyMediaType(p.Request.Header, contentType) {
+			fancyErr := newFancyDecodeError(p.Request.Header, bytes.NewReader(data))
+			return newDecodeRequestError(p.Request, fancyErr.body, fancyErr)
+		}
+		return unmarshalBodyData(data, makeResult(v))
+	}
+}
+
+// isBodyMediaType reports whether the content type of the given header
+// is acceptable for a JSON request body. If contentType is non-empty,
+// that media type is accepted in addition to the JSON media types.
+func isBodyMediaType(h http.Header, contentType string) bool {
+	if isJSONMediaType(h) {
+		return true
+	}
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == contentType
+}
+
+// unmarshalBodyData unmarshals the given request body
+// data into the given result value, using any codec
+// registered for its type with RegisterBodyCodec.
+func unmarshalBodyData(data []byte, result reflect.Value) error {
+	if codec := getBodyCodec(result.Type()); codec != nil {
+		if err := codec.UnmarshalBody(data, result.Addr().Interface()); err != nil {
+			return errgo.Notef(err, "cannot unmarshal request body")
+		}
+		return nil
 	}
-	// TODO allow body types that aren't necessarily JSON.
-	result := makeResult(v)
 	if err := json.Unmarshal(data, result.Addr().Interface()); err != nil {
 		return errgo.Notef(err, "cannot unmarshal request body")
 	}
 	return nil
 }
 
+// formGetter returns a function that gets the value
+// for the given tag, trying each of the tag's names
+// in turn, and reports whether the value was found.
+// If the tag specifies treatemptyasabsent, empty values
+// are treated as not found.
+func formGetter(t tag) func(p Params) (string, bool) {
+	var getVal func(name string, p Params) (string, bool)
+	switch {
+	case t.source == sourceMatrix:
+		getVal = matrixGetter(t.matrixParam)
+	case t.hasMatrix:
+		getVal = func(name string, p Params) (string, bool) {
+			val, ok := formGetters[sourcePath](name, p)
+			val, _ = splitMatrix(val)
+			return val, ok
+		}
+	case int(t.source) < len(formGetters):
+		getVal = formGetters[t.source]
+	}
+	if getVal == nil {
+		panic("unexpected source")
+	}
+	return namesGetter(t, getVal)
+}
+
+// namesGetter is like formGetter except that getVal is
+// used to get the value for each name.
+func namesGetter(t tag, getVal func(name string, p Params) (string, bool)) func(p Params) (string, bool) {
+	if len(t.aliases) == 0 && !t.emptyAsAbsent {
+		return func(p Params) (string, bool) {
+			return getVal(t.name, p)
+		}
+	}
+	names := t.names()
+	return func(p Params) (string, bool) {
+		for _, name := range names {
+			if val, ok := getVal(name, p); ok && (val != "" || !t.emptyAsAbsent) {
+				return val, true
+			}
+		}
+		return "", false
+	}
+}
+
 // formGetters maps from source to a function that
 // returns the value for a given key and reports
 // whether the value was found.
@@ -193,7 +846,7 @@ var formGetters = []func(name string, p Params) (string, bool){
 	},
 	sourceBody: nil,
 	sourceHeader: func(name string, p Params) (string, bool) {
-		vs := p.Request.Header[name]
+		vs := headerValues(p.Request.Header, name)
 		if len(vs) == 0 {
 			return "", false
 		}
@@ -201,6 +854,56 @@ var formGetters = []func(name string, p Params) (string, bool){
 	},
 }
 
+// matrixGetter returns a function that returns the value of
+// the matrix parameter with a given name in the segment of the
+// given path parameter and reports whether it was found. A
+// matrix parameter with no "=" has an empty value.
+func matrixGetter(pathParam string) func(name string, p Params) (string, bool) {
+	return func(name string, p Params) (string, bool) {
+		_, params := splitMatrix(p.PathVar.ByName(pathParam))
+		for _, param := range params {
+			key, val := param, ""
+			if i := strings.IndexByte(param, '='); i >= 0 {
+				key, val = param[:i], param[i+1:]
+			}
+			if key == name {
+				return val, true
+			}
+		}
+		return "", false
+	}
+}
+
+// splitMatrix splits a path segment such as "thing;a=1;b=2" into
+// its value ("thing") and its matrix parameters ("a=1" and "b=2").
+func splitMatrix(s string) (string, []string) {
+	parts := strings.Split(s, ";")
+	return parts[0], parts[1:]
+}
+
+// joinedHeaderValue returns all the values of the header with the
+// given name joined with commas, which RFC 7230 specifies as
+// equivalent to the separate values, and reports whether any
+// value was found.
+func joinedHeaderValue(name string, p Params) (string, bool) {
+	vs := headerValues(p.Request.Header, name)
+	if len(vs) == 0 {
+		return "", false
+	}
+	return strings.Join(vs, ", "), true
+}
+
+// headerValues returns all the values in h for the header with the
+// given name. If there is no entry with exactly the given name, the
+// canonical form of the name is tried, so that header names given
+// in tags match case-insensitively.
+func headerValues(h http.Header, name string) []string {
+	if vs := h[name]; len(vs) > 0 {
+		return vs
+	}
+	return h[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
 // encodingTextUnmarshaler is the same as encoding.TextUnmarshaler
 // but avoids us importing the encoding package, which some
 // broken gccgo installations do not allow.
@@ -220,36 +923,298 @@ func implementsTextUnmarshaler(t reflect.Type) bool {
 
 // unmarshalWithUnmarshalText returns an unmarshaler
 // that unmarshals the given type from the given tag
-// using its UnmarshalText method.
-func unmarshalWithUnmarshalText(t reflect.Type, tag tag) unmarshaler {
-	getVal := formGetters[tag.source]
-	if getVal == nil {
-		panic("unexpected source")
-	}
+// using its UnmarshalText method. If isPointer is true
+// and there is no value, the field is left as nil; otherwise
+// UnmarshalText is called even when there is no value.
+func unmarshalWithUnmarshalText(t reflect.Type, tag tag, isPointer bool) unmarshaler {
+	getVal := formGetter(tag)
 	return func(v reflect.Value, p Params, makeResult resultMaker) error {
-		val, _ := getVal(tag.name, p)
+		val, ok := getVal(p)
+		if !ok && isPointer {
+			return nil
+		}
 		uv := makeResult(v).Addr().Interface().(encodingTextUnmarshaler)
 		return uv.UnmarshalText([]byte(val))
 	}
 }
 
-// unmarshalWithScan returns an unmarshaler
-// that unmarshals the given tag using fmt.Scan.
-func unmarshalWithScan(tag tag) unmarshaler {
-	formGet := formGetters[tag.source]
-	if formGet == nil {
-		panic("unexpected source")
+// FormValueParser is implemented by types that parse their own path,
+// form or header values. When such a type is used for a path, form or
+// header field, or as the element type of a form or header slice field,
+// Unmarshal calls ParseFormValue with the value in preference to
+// UnmarshalText or fmt.Sscan. Unlike UnmarshalText, it is not called
+// when there is no value.
+//
+// As with UnmarshalText, the method will usually be defined on
+// the pointer type. Marshal does not use it; a type that needs
+// to be marshaled should also implement encoding.TextMarshaler.
+type FormValueParser interface {
+	ParseFormValue(s string) error
+}
+
+var formValueParserType = reflect.TypeOf((*FormValueParser)(nil)).Elem()
+
+func implementsFormValueParser(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(formValueParserType)
+}
+
+// unmarshalWithParseFormValue returns an unmarshaler
+// that unmarshals the given type from the given tag
+// using its ParseFormValue method.
+func unmarshalWithParseFormValue(t reflect.Type, tag tag) unmarshaler {
+	getVal := formGetter(tag)
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		val, ok := getVal(p)
+		if !ok {
+			return nil
+		}
+		pv := makeResult(v).Addr().Interface().(FormValueParser)
+		if err := pv.ParseFormValue(val); err != nil {
+			return errgo.Notef(err, "cannot parse %s value %q into %s", tag.describe(), val, t)
+		}
+		return nil
+	}
+}
+
+// AllowedValuer is implemented by string types that may
+// only hold one of a fixed set of values. When such a type
+// is used for a path, form or header field, Unmarshal
+// will return an error if the value is not one of those
+// returned by AllowedValues.
+type AllowedValuer interface {
+	AllowedValues() []string
+}
+
+var allowedValuerType = reflect.TypeOf((*AllowedValuer)(nil)).Elem()
+
+// unmarshalAllowedValue returns an unmarshaler that
+// unmarshals the given tag into a string-kinded value of type t
+// that implements AllowedValuer, checking that
+// the value is one of the allowed values.
+func unmarshalAllowedValue(t reflect.Type, tag tag) unmarshaler {
+	getVal := formGetter(tag)
+	allowed := reflect.Zero(t).Interface().(AllowedValuer).AllowedValues()
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		val, ok := getVal(p)
+		if !ok {
+			return nil
+		}
+		for _, a := range allowed {
+			if val == a {
+				makeResult(v).SetString(val)
+				return nil
+			}
+		}
+		return errgo.Newf("invalid %s value %q (allowed values %q)", tag.describe(), val, allowed)
 	}
+}
+
+// unmarshalWithScan returns an unmarshaler
+// that unmarshals the given tag into a value of type t
+// using parseValue. If check is non-nil, it is called
+// to check the unmarshaled value.
+func unmarshalWithScan(t reflect.Type, tag tag, check func(reflect.Value) error) unmarshaler {
+	formGet := formGetter(tag)
 	return func(v reflect.Value, p Params, makeResult resultMaker) error {
-		val, ok := formGet(tag.name, p)
+		val, ok := formGet(p)
 		if !ok {
 			// TODO allow specifying that a field is mandatory?
 			return nil
 		}
-		_, err := fmt.Sscan(val, makeResult(v).Addr().Interface())
+		rv := makeResult(v)
+		if err := parseValue(val, rv); err != nil {
+			return errgo.Notef(err, "cannot parse %s value %q into %s", tag.describe(), val, t)
+		}
+		if check != nil {
+			return check(rv)
+		}
+		return nil
+	}
+}
+
+var (
+	scannerType  = reflect.TypeOf((*fmt.Scanner)(nil)).Elem()
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// parseValue parses val into v, which must be addressable. Values of
+// unsigned integer and floating point kinds are parsed with the
+// strconv package so that, for example, a negative value for an
+// unsigned field gives a clear error, and time.Duration values are
+// parsed with time.ParseDuration; other values, and values of
+// types that implement fmt.Scanner, are parsed with fmt.Sscan.
+func parseValue(val string, v reflect.Value) error {
+	if reflect.PtrTo(v.Type()).Implements(scannerType) {
+		_, err := fmt.Sscan(val, v.Addr().Interface())
+		return err
+	}
+	s := strings.TrimSpace(val)
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
 		if err != nil {
-			return errgo.Notef(err, "cannot parse %q into %s", val, v.Type())
+			return err
 		}
+		v.SetInt(int64(d))
 		return nil
 	}
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if strings.HasPrefix(s, "-") {
+			return errgo.New("value must not be negative")
+		}
+		// Use base 0 so that, as with fmt.Sscan,
+		// prefixes such as "0x" are allowed.
+		n, err := strconv.ParseUint(strings.TrimPrefix(s, "+"), 0, v.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		v.SetFloat(f)
+	default:
+		_, err := fmt.Sscan(val, v.Addr().Interface())
+		return err
+	}
+	return nil
+}
+
+// numError returns the reason for an error returned by a strconv
+// parsing function, without the function name and value, which
+// are included elsewhere in our error messages.
+func numError(err error) error {
+	if nerr, ok := err.(*strconv.NumError); ok {
+		return nerr.Err
+	}
+	return err
+}
+
+// unmarshalFormBool returns an unmarshaler that unmarshals a form
+// value into a field of type t, which must have bool kind. A value
+// that is present but empty, as for a query flag such as "verbose"
+// in "?verbose&limit=10", sets the field to true. Any other value
+// is parsed with fmt.Sscan.
+func unmarshalFormBool(t reflect.Type, tag tag) unmarshaler {
+	formGet := formGetter(tag)
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		val, ok := formGet(p)
+		if !ok {
+			return nil
+		}
+		rv := makeResult(v)
+		if val == "" {
+			rv.SetBool(true)
+			return nil
+		}
+		if _, err := fmt.Sscan(val, rv.Addr().Interface()); err != nil {
+			return errgo.Notef(err, "cannot parse %s value %q into %s", tag.describe(), val, t)
+		}
+		return nil
+	}
+}
+
+// unmarshalWithBase returns an unmarshaler that unmarshals
+// the given tag into an integer of type t, parsing it in the
+// numeric base specified by the tag. If check is non-nil,
+// it is called to check the resulting value.
+func unmarshalWithBase(t reflect.Type, tag tag, check func(reflect.Value) error) unmarshaler {
+	formGet := formGetter(tag)
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		val, ok := formGet(p)
+		if !ok {
+			return nil
+		}
+		rv := makeResult(v)
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(val, tag.base, t.Bits())
+			if err != nil {
+				return errgo.Notef(err, "cannot parse %s value %q into %s", tag.describe(), val, t)
+			}
+			rv.SetInt(n)
+		default:
+			n, err := strconv.ParseUint(val, tag.base, t.Bits())
+			if err != nil {
+				return errgo.Notef(err, "cannot parse %s value %q into %s", tag.describe(), val, t)
+			}
+			rv.SetUint(n)
+		}
+		if check != nil {
+			return check(rv)
+		}
+		return nil
+	}
+}
+
+// isIntegerKind reports whether k is
+// a signed or unsigned integer kind.
+func isIntegerKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// rangeChecker returns a function that checks that a value of type t
+// is within the bounds specified by the httpmin and httpmax
+// tags in the given tag.
+func rangeChecker(tag tag, t reflect.Type) (func(reflect.Value) error, error) {
+	if tag.source != sourcePath && tag.source != sourceForm && tag.source != sourceHeader {
+		return nil, errgo.New("httpmin and httpmax can only be used on path, form or header fields")
+	}
+	var parse func(s string) (reflect.Value, error)
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		parse = func(s string) (reflect.Value, error) {
+			v := reflect.New(t).Elem()
+			if err := parseValue(s, v); err != nil {
+				return reflect.Value{}, err
+			}
+			return v, nil
+		}
+	default:
+		return nil, errgo.Newf("httpmin and httpmax cannot be used on type %s", t)
+	}
+	var min, max reflect.Value
+	if tag.min != "" {
+		var err error
+		if min, err = parse(tag.min); err != nil {
+			return nil, errgo.Notef(err, "invalid httpmin value %q", tag.min)
+		}
+	}
+	if tag.max != "" {
+		var err error
+		if max, err = parse(tag.max); err != nil {
+			return nil, errgo.Notef(err, "invalid httpmax value %q", tag.max)
+		}
+	}
+	return func(v reflect.Value) error {
+		if min.IsValid() && lessNumber(v, min) {
+			return errgo.Newf("%s value %v is less than minimum %v", tag.describe(), v.Interface(), min.Interface())
+		}
+		if max.IsValid() && lessNumber(max, v) {
+			return errgo.Newf("%s value %v is greater than maximum %v", tag.describe(), v.Interface(), max.Interface())
+		}
+		return nil
+	}, nil
+}
+
+// lessNumber reports whether v0 is less than v1. Both
+// values must be of the same numeric type.
+func lessNumber(v0, v1 reflect.Value) bool {
+	switch v0.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v0.Int() < v1.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v0.Uint() < v1.Uint()
+	default:
+		return v0.Float() < v1.Float()
+	}
 }
@@ -4,17 +4,21 @@
 package httprequest_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	jc "github.com/juju/testing/checkers"
 	"github.com/julienschmidt/httprouter"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
 
 	"github.com/juju/httprequest"
 )
@@ -228,7 +232,7 @@ var unmarshalTests = []struct {
 	params: httprequest.Params{
 		Request: &http.Request{},
 	},
-	expectError: "cannot unmarshal into field: empty string!",
+	expectError: "cannot unmarshal into field F: empty string!",
 }, {
 	about: "all field form values",
 	val: struct {
@@ -263,7 +267,7 @@ var unmarshalTests = []struct {
 			},
 		},
 	},
-	expectError: `cannot unmarshal into field: cannot parse "not an int" into int: expected integer`,
+	expectError: `cannot unmarshal into field A: cannot parse form field "A" value "not an int" into int: expected integer`,
 }, {
 	about: "scan field not present",
 	val: struct {
@@ -283,7 +287,7 @@ var unmarshalTests = []struct {
 			Body:   body("invalid JSON"),
 		},
 	},
-	expectError: "cannot unmarshal into field: cannot unmarshal request body: invalid character 'i' looking for beginning of value",
+	expectError: "cannot unmarshal into field A: cannot unmarshal request body: invalid character 'i' looking for beginning of value",
 }, {
 	about: "body with read error",
 	val: struct {
@@ -295,7 +299,7 @@ var unmarshalTests = []struct {
 			Body:   errorReader("some error"),
 		},
 	},
-	expectError: "cannot unmarshal into field: cannot read request body: some error",
+	expectError: "cannot unmarshal into field A: cannot read request body: some error",
 }, {
 	about: "[]string not allowed for URL source",
 	val: struct {
@@ -343,7 +347,7 @@ var unmarshalTests = []struct {
 			Body:   body("invalid JSON"),
 		},
 	},
-	expectError: `cannot unmarshal into field: unexpected content type text/html; want application/json; content: invalid JSON`,
+	expectError: `cannot unmarshal into field A: unexpected content type text/html; want application/json; content: invalid JSON`,
 }, {
 	about: "struct with header fields",
 	val: struct {
@@ -361,59 +365,1180 @@ var unmarshalTests = []struct {
 			},
 		},
 	},
+}, {
+	about: "header fields with non-canonical names",
+	val: struct {
+		F1 string   `httprequest:"X-Request-ID,header"`
+		F2 []string `httprequest:"x-multi-value,header"`
+	}{
+		F1: "1234",
+		F2: []string{"A", "B"},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{
+				"X-Request-Id":  {"1234"},
+				"X-Multi-Value": {"A", "B"},
+			},
+		},
+	},
+}, {
+	about: "repeated header values joined into string field",
+	val: struct {
+		Accept string   `httprequest:"Accept,header"`
+		P      *string  `httprequest:"X-P,header"`
+		Raw    []string `httprequest:"Accept,header"`
+		Alias  string   `httprequest:"X-New|X-Old,header"`
+	}{
+		Accept: "text/html, application/json;q=0.9, */*;q=0.1",
+		P:      newString("a, b"),
+		Raw:    []string{"text/html", "application/json;q=0.9, */*;q=0.1"},
+		Alias:  "x, y",
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{
+				"Accept": {"text/html", "application/json;q=0.9, */*;q=0.1"},
+				"X-P":    {"a", "b"},
+				"X-Old":  {"x", "y"},
+			},
+		},
+	},
 }, {
 	about: "all field header values",
 	val: struct {
-		A []string  `httprequest:",header"`
-		B *[]string `httprequest:",header"`
-		C []string  `httprequest:",header"`
-		D *[]string `httprequest:",header"`
+		A []string  `httprequest:",header"`
+		B *[]string `httprequest:",header"`
+		C []string  `httprequest:",header"`
+		D *[]string `httprequest:",header"`
+	}{
+		A: []string{"a1", "a2"},
+		B: func() *[]string {
+			x := []string{"b1", "b2", "b3"}
+			return &x
+		}(),
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{
+				"A": {"a1", "a2"},
+				"B": {"b1", "b2", "b3"},
+			},
+		},
+	},
+}, {
+	about: "pointer body field with empty body",
+	val: struct {
+		B *sFG `httprequest:",body"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Body: body(""),
+		},
+	},
+}, {
+	about: "pointer body field with no body",
+	val: struct {
+		B *sFG `httprequest:",body"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{},
+	},
+}, {
+	about: "pointer body field with empty JSON object",
+	val: struct {
+		B *sFG `httprequest:",body"`
+	}{
+		B: &sFG{},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   body("{}"),
+		},
+	},
+}, {
+	about: "pointer body field with body",
+	val: struct {
+		B *sFG `httprequest:",body"`
+	}{
+		B: &sFG{
+			F: 99,
+			G: 100,
+		},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   body(`{"F": 99, "G": 100}`),
+		},
+	},
+}, {
+	about: "pointer body field with wrong content type",
+	val: struct {
+		B *sFG `httprequest:",body"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"text/plain"}},
+			Body:   body("something"),
+		},
+	},
+	expectError: `cannot unmarshal into field B: unexpected content type text/plain; want application/json; content: something`,
+}, {
+	about: "non-pointer body field with empty body",
+	val: struct {
+		B sFG `httprequest:",body"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   body(""),
+		},
+	},
+	expectError: `cannot unmarshal into field B: cannot unmarshal request body: unexpected end of JSON input`,
+}, {
+	about: "anonymous body field with pointer field",
+	val: struct {
+		BodyWithPointer `httprequest:",body"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   body("{}"),
+		},
+	},
+}, {
+	about: "anonymous field with tag",
+	val: struct {
+		StructTextUnmarshaler `httprequest:"x,form"`
+	}{
+		StructTextUnmarshaler{"something"},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"x": {"something"},
+			},
+			Body: body(`"h val"`),
+		},
+		PathVar: httprouter.Params{{
+			// Note that this is the tagged field in the StructTextUnmarshaler type.
+			Key:   "foo",
+			Value: "ignored",
+		}},
+	},
+}, {
+	about: "anonymous struct field with marker tag",
+	val: struct {
+		CommonParams     `httprequest:",form"`
+		*PtrCommonParams `httprequest:",path"`
+		F                int `httprequest:",form"`
+	}{
+		CommonParams: CommonParams{
+			Limit: 10,
+			Token: "tok",
+		},
+		PtrCommonParams: &PtrCommonParams{
+			ID: "id1",
+		},
+		F: 5,
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Token": {"tok"}},
+			Form: url.Values{
+				"limit": {"10"},
+				"F":     {"5"},
+			},
+		},
+		PathVar: httprouter.Params{{
+			Key:   "id",
+			Value: "id1",
+		}},
+	},
+}, {
+	about: "fields with alternative names",
+	val: struct {
+		F1 int      `httprequest:"limit|per_page,form"`
+		F2 string   `httprequest:"new|old,form"`
+		F3 []string `httprequest:"x|y,form"`
+		G  string   `httprequest:"id|name,path"`
+		H  string   `httprequest:"X-New|X-Old,header"`
+	}{
+		F1: 20,
+		F2: "new val",
+		F3: []string{"y1", "y2"},
+		G:  "g val",
+		H:  "h val",
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"X-Old": {"h val"}},
+			Form: url.Values{
+				"per_page": {"20"},
+				"new":      {"new val"},
+				"old":      {"old val"},
+				"y":        {"y1", "y2"},
+			},
+		},
+		PathVar: httprouter.Params{{
+			Key:   "name",
+			Value: "g val",
+		}},
+	},
+}, {
+	about: "non-string slice fields",
+	val: struct {
+		F []int                   `httprequest:",form"`
+		H []int                   `httprequest:",header"`
+		P *[]float64              `httprequest:",form"`
+		E []int                   `httprequest:",form"`
+		X *exclamationUnmarshaler `httprequest:",form"`
+	}{
+		F: []int{1, 2, 3},
+		H: []int{4},
+		P: &[]float64{0.5},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"H": {"4"}},
+			Form: url.Values{
+				"F": {"1", "2", "3"},
+				"P": {"0.5"},
+			},
+		},
+	},
+}, {
+	about: "non-string slice field with bad value",
+	val: struct {
+		F []int `httprequest:",form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"F": {"1", "x"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field F: cannot parse form field "F" value "x" into int: expected integer`,
+}, {
+	about: "non-string slice path field",
+	val: struct {
+		P []int `httprequest:",path"`
+	}{},
+	expectError: `bad type .*: invalid target type \[\]int for path parameter`,
+}, {
+	about: "fields with range constraints",
+	val: struct {
+		P int     `httprequest:"id,path" httpmin:"1"`
+		F uint8   `httprequest:",form" httpmin:"1" httpmax:"10"`
+		G float64 `httprequest:",form" httpmax:"0.5"`
+		H *int64  `httprequest:",header" httpmin:"-5" httpmax:"5"`
+		N int     `httprequest:",form" httpmin:"1"`
+	}{
+		P: 1,
+		F: 10,
+		G: -2.5,
+		H: newInt64(-5),
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"H": {"-5"}},
+			Form: url.Values{
+				"F": {"10"},
+				"G": {"-2.5"},
+			},
+		},
+		PathVar: httprouter.Params{{
+			Key:   "id",
+			Value: "1",
+		}},
+	},
+}, {
+	about: "path parameter less than minimum",
+	val: struct {
+		P int `httprequest:"id,path" httpmin:"1"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{},
+		PathVar: httprouter.Params{{
+			Key:   "id",
+			Value: "0",
+		}},
+	},
+	expectError: `cannot unmarshal into field P: path parameter "id" value 0 is less than minimum 1`,
+}, {
+	about: "form value greater than maximum",
+	val: struct {
+		F float64 `httprequest:"f,form" httpmax:"0.5"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"f": {"0.75"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field F: form field "f" value 0.75 is greater than maximum 0.5`,
+}, {
+	about: "range constraint on non-numeric field",
+	val: struct {
+		F string `httprequest:",form" httpmin:"1"`
+	}{},
+	expectError: `bad type .*: httpmin and httpmax cannot be used on type string`,
+}, {
+	about: "range constraint on body field",
+	val: struct {
+		B int `httprequest:",body" httpmax:"1"`
+	}{},
+	expectError: `bad type .*: httpmin and httpmax can only be used on path, form or header fields`,
+}, {
+	about: "invalid range constraint",
+	val: struct {
+		F int8 `httprequest:",form" httpmax:"1000"`
+	}{},
+	expectError: `bad type .*: invalid httpmax value "1000": .*`,
+}, {
+	about: "fields with numeric base",
+	val: struct {
+		P uint64 `httprequest:"id,path" httpbase:"16"`
+		F int    `httprequest:",form" httpbase:"2"`
+		G int16  `httprequest:",form" httpbase:"16"`
+		H *uint8 `httprequest:",header" httpbase:"8"`
+		M int    `httprequest:",form" httpbase:"16" httpmax:"255"`
+		N int    `httprequest:",form" httpbase:"16"`
+	}{
+		P: 0xdeadbeef01,
+		F: 5,
+		G: -0x7f,
+		H: newUint8(0355),
+		M: 255,
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"H": {"355"}},
+			Form: url.Values{
+				"F": {"101"},
+				"G": {"-7F"},
+				"M": {"ff"},
+			},
+		},
+		PathVar: httprouter.Params{{
+			Key:   "id",
+			Value: "deadbeef01",
+		}},
+	},
+}, {
+	about: "invalid value with numeric base",
+	val: struct {
+		P int `httprequest:"id,path" httpbase:"16"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{},
+		PathVar: httprouter.Params{{
+			Key:   "id",
+			Value: "0x10",
+		}},
+	},
+	expectError: `cannot unmarshal into field P: cannot parse path parameter "id" value "0x10" into int: strconv.ParseInt: parsing "0x10": invalid syntax`,
+}, {
+	about: "value out of range with numeric base",
+	val: struct {
+		F int8 `httprequest:"f,form" httpbase:"16"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"f": {"80"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field F: cannot parse form field "f" value "80" into int8: strconv.ParseInt: parsing "80": value out of range`,
+}, {
+	about: "value with numeric base greater than maximum",
+	val: struct {
+		F int `httprequest:"f,form" httpbase:"16" httpmax:"255"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"f": {"100"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field F: form field "f" value 256 is greater than maximum 255`,
+}, {
+	about: "numeric base on non-integer field",
+	val: struct {
+		F float64 `httprequest:",form" httpbase:"16"`
+	}{},
+	expectError: `bad type .*: httpbase cannot be used on type float64`,
+}, {
+	about: "numeric base on body field",
+	val: struct {
+		B int `httprequest:",body" httpbase:"16"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field B: can only use httpbase with path, form or header fields`,
+}, {
+	about: "invalid numeric base",
+	val: struct {
+		F int `httprequest:",form" httpbase:"37"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field F: invalid httpbase value "37"`,
+}, {
+	about: "allform field",
+	val: struct {
+		A   int         `httprequest:"a,form"`
+		All url.Values  `httprequest:",allform"`
+		P   *url.Values `httprequest:",allform"`
+	}{
+		A: 1,
+		All: url.Values{
+			"a": {"1"},
+			"b": {"2", "3"},
+		},
+		P: &url.Values{
+			"a": {"1"},
+			"b": {"2", "3"},
+		},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"a": {"1"},
+				"b": {"2", "3"},
+			},
+		},
+	},
+}, {
+	about: "allform field with empty form",
+	val: struct {
+		All url.Values `httprequest:",allform"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{},
+	},
+}, {
+	about: "allform field with wrong type",
+	val: struct {
+		All map[string]string `httprequest:",allform"`
+	}{},
+	expectError: `bad type .*: invalid target type map\[string\]string for allform field; need url.Values`,
+}, {
+	about: "method field",
+	val: struct {
+		M string  `httprequest:",method"`
+		P *string `httprequest:",method"`
+		A int     `httprequest:"a,form"`
+	}{
+		M: "PUT",
+		P: newString("PUT"),
+		A: 1,
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Method: "PUT",
+			Form: url.Values{
+				"a": {"1"},
+			},
+		},
+	},
+}, {
+	about: "method field with wrong type",
+	val: struct {
+		M int `httprequest:",method"`
+	}{},
+	expectError: `bad type .*: invalid target type int for method field; need string`,
+}, {
+	about: "rawquery field",
+	val: struct {
+		Q string  `httprequest:",rawquery"`
+		P *string `httprequest:",rawquery"`
+		A string  `httprequest:"a,form"`
+	}{
+		Q: "b=2&a=%2f+",
+		P: newString("b=2&a=%2f+"),
+		A: "/ ",
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			URL: &url.URL{
+				RawQuery: "b=2&a=%2f+",
+			},
+			Form: url.Values{
+				"a": {"/ "},
+				"b": {"2"},
+			},
+		},
+	},
+}, {
+	about: "basic auth fields",
+	val: struct {
+		User     string  `httprequest:",basicuser"`
+		Password *string `httprequest:",basicpass"`
+	}{
+		User:     "bob",
+		Password: newString("secret"),
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Authorization": {"Basic Ym9iOnNlY3JldA=="}},
+		},
+	},
+}, {
+	about: "basic auth fields without credentials",
+	val: struct {
+		User     string  `httprequest:",basicuser"`
+		Password *string `httprequest:",basicpass"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Authorization": {"Bearer sometoken"}},
+		},
+	},
+}, {
+	about: "basic auth field with wrong type",
+	val: struct {
+		Password []byte `httprequest:",basicpass"`
+	}{},
+	expectError: `bad type .*: invalid target type \[\]uint8 for basic auth field; need string`,
+}, {
+	about: "rawquery field with wrong type",
+	val: struct {
+		Q int `httprequest:",rawquery"`
+	}{},
+	expectError: `bad type .*: invalid target type int for rawquery field; need string`,
+}, {
+	about: "host field",
+	val: struct {
+		H string  `httprequest:",host"`
+		P *string `httprequest:",host"`
+	}{
+		H: "tenant1.example.com:8080",
+		P: newString("tenant1.example.com:8080"),
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Host: "tenant1.example.com:8080",
+		},
+	},
+}, {
+	about: "host field with wrong type",
+	val: struct {
+		H int `httprequest:",host"`
+	}{},
+	expectError: `bad type .*: invalid target type int for host field; need string`,
+}, {
+	about: "body with custom content type",
+	val: struct {
+		B sFG `httprequest:",body" httpcontenttype:"application/vnd.foo+json"`
+	}{
+		B: sFG{F: 1},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/vnd.foo+json; charset=utf-8"}},
+			Body:   body(`{"F": 1}`),
+		},
+	},
+}, {
+	about: "body with custom content type and JSON content",
+	val: struct {
+		B *sFG `httprequest:",body" httpcontenttype:"application/vnd.foo+json"`
+	}{
+		B: &sFG{F: 1},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   body(`{"F": 1}`),
+		},
+	},
+}, {
+	about: "body with custom content type and wrong content",
+	val: struct {
+		B *sFG `httprequest:",body" httpcontenttype:"application/vnd.foo+json"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/vnd.bar+json"}},
+			Body:   body(`{"F": 1}`),
+		},
+	},
+	expectError: `cannot unmarshal into field B: unexpected content type application/vnd.bar\+json; want application/json; content: .*`,
+}, {
+	about: "custom content type on non-body field",
+	val: struct {
+		F int `httprequest:",form" httpcontenttype:"application/vnd.foo+json"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field F: can only use httpcontenttype with body fields`,
+}, {
+	about: "yaml body",
+	val: struct {
+		B yamlConfig `httprequest:",body,yaml"`
+	}{
+		B: yamlConfig{Name: "test", Ports: []int{80, 443}},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/yaml"}},
+			Body:   body("name: test\nports: [80, 443]\n"),
+		},
+	},
+}, {
+	about: "yaml body with text/yaml content type",
+	val: struct {
+		B yamlConfig `httprequest:",body,yaml"`
+	}{
+		B: yamlConfig{Name: "test"},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"text/yaml; charset=utf-8"}},
+			Body:   body("name: test\n"),
+		},
+	},
+}, {
+	about: "yaml body with custom content type",
+	val: struct {
+		B yamlConfig `httprequest:",body,yaml" httpcontenttype:"application/vnd.foo+yaml"`
+	}{
+		B: yamlConfig{Name: "test"},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/vnd.foo+yaml"}},
+			Body:   body("name: test\n"),
+		},
+	},
+}, {
+	about: "yaml body with JSON content type",
+	val: struct {
+		B yamlConfig `httprequest:",body,yaml"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   body(`{"name": "test"}`),
+		},
+	},
+	expectError: `cannot unmarshal into field B: unexpected content type "application/json"; want application/yaml`,
+}, {
+	about: "invalid yaml body",
+	val: struct {
+		B yamlConfig `httprequest:",body,yaml"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/yaml"}},
+			Body:   body("ports: notalist\n"),
+		},
+	},
+	expectError: `cannot unmarshal into field B: cannot unmarshal request body: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!str .* into \[\]int`,
+}, {
+	about: "empty optional yaml body",
+	val: struct {
+		B *yamlConfig `httprequest:",body,yaml"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Body: body(""),
+		},
+	},
+}, {
+	about: "optional yaml body",
+	val: struct {
+		B *yamlConfig `httprequest:",body,yaml"`
+	}{
+		B: &yamlConfig{Name: "test"},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"Content-Type": {"application/x-yaml"}},
+			Body:   body("name: test\n"),
+		},
+	},
+}, {
+	about: "yaml on non-body field",
+	val: struct {
+		F int `httprequest:",form,yaml"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field F: can only use yaml with body fields`,
+}, {
+	about: "addslash on non-path field",
+	val: struct {
+		F string `httprequest:",form,addslash"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field F: can only use addslash with path fields`,
+}, {
+	about: "unsigned and floating point fields",
+	val: struct {
+		U    uint      `httprequest:"u,path"`
+		U8   uint8     `httprequest:"u8,form"`
+		U64  uint64    `httprequest:"u64,form"`
+		Hex  uint16    `httprequest:"hex,form"`
+		F32  float32   `httprequest:"f32,form"`
+		F64  float64   `httprequest:"X-F64,header"`
+		Fs   []float64 `httprequest:"fs,form"`
+		PU64 *uint64   `httprequest:"pu64,form"`
+	}{
+		U:    7,
+		U8:   255,
+		U64:  18446744073709551615,
+		Hex:  0xff,
+		F32:  1.5,
+		F64:  1e21,
+		Fs:   []float64{0.25, -3},
+		PU64: newUint64(42),
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{
+				"X-F64": {"1e21"},
+			},
+			Form: url.Values{
+				"u8":   {"255"},
+				"u64":  {"18446744073709551615"},
+				"hex":  {"0xff"},
+				"f32":  {"1.5"},
+				"fs":   {"0.25", "-3"},
+				"pu64": {"+42"},
+			},
+		},
+		PathVar: httprouter.Params{{
+			Key:   "u",
+			Value: "7",
+		}},
+	},
+}, {
+	about: "negative value for unsigned field",
+	val: struct {
+		U uint `httprequest:"u,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"u": {"-1"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field U: cannot parse form field "u" value "-1" into uint: value must not be negative`,
+}, {
+	about: "unsigned field out of range",
+	val: struct {
+		U uint8 `httprequest:"u,path"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{},
+		PathVar: httprouter.Params{{
+			Key:   "u",
+			Value: "256",
+		}},
+	},
+	expectError: `cannot unmarshal into field U: cannot parse path parameter "u" value "256" into uint8: value out of range`,
+}, {
+	about: "bad unsigned value",
+	val: struct {
+		U []uint32 `httprequest:"u,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"u": {"1", "x"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field U: cannot parse form field "u" value "x" into uint32: invalid syntax`,
+}, {
+	about: "bad floating point value",
+	val: struct {
+		F float64 `httprequest:"f,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"f": {"1.5.2"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field F: cannot parse form field "f" value "1.5.2" into float64: invalid syntax`,
+}, {
+	about: "duration fields",
+	val: struct {
+		Timeout time.Duration   `httprequest:"timeout,form" httpmin:"1s" httpmax:"5m"`
+		Delay   *time.Duration  `httprequest:"X-Delay,header"`
+		Ds      []time.Duration `httprequest:"ds,form"`
+		Missing *time.Duration  `httprequest:"missing,form"`
+		N       time.Duration   `httprequest:"n,path"`
+	}{
+		Timeout: 90 * time.Second,
+		Delay:   newDuration(250 * time.Millisecond),
+		Ds:      []time.Duration{time.Hour, -time.Minute},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{
+				"X-Delay": {"250ms"},
+			},
+			Form: url.Values{
+				"timeout": {"1m30s"},
+				"ds":      {"1h", "-1m"},
+			},
+		},
+		PathVar: httprouter.Params{{
+			Key:   "n",
+			Value: "0",
+		}},
+	},
+}, {
+	about: "duration without unit",
+	val: struct {
+		Timeout time.Duration `httprequest:"timeout,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"timeout": {"30"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field Timeout: cannot parse form field "timeout" value "30" into time.Duration: time: missing unit in duration "?30"?`,
+}, {
+	about: "duration greater than maximum",
+	val: struct {
+		Timeout time.Duration `httprequest:"timeout,form" httpmax:"5m"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"timeout": {"1h"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field Timeout: form field "timeout" value 1h0m0s is greater than maximum 5m0s`,
+}, {
+	about: "floating point value out of range",
+	val: struct {
+		F float32 `httprequest:"f,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"f": {"1e40"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field F: cannot parse form field "f" value "1e40" into float32: value out of range`,
+}, {
+	about: "readonly fields",
+	val: struct {
+		ID      string  `httprequest:"id,path,readonly"`
+		Created int     `httprequest:"created,form,readonly"`
+		Owner   *string `httprequest:"X-Owner,header,readonly"`
+		Body    *struct {
+			Name string
+		} `httprequest:",body,readonly"`
+		Name string `httprequest:"name,form"`
 	}{
-		A: []string{"a1", "a2"},
-		B: func() *[]string {
-			x := []string{"b1", "b2", "b3"}
-			return &x
-		}(),
+		Name: "bob",
 	},
 	params: httprequest.Params{
 		Request: &http.Request{
 			Header: http.Header{
-				"A": {"a1", "a2"},
-				"B": {"b1", "b2", "b3"},
+				"X-Owner":      {"alice"},
+				"Content-Type": {"application/json"},
+			},
+			Form: url.Values{
+				"created": {"1234"},
+				"name":    {"bob"},
 			},
+			Body: body(`{"Name": "eve"}`),
 		},
+		PathVar: httprouter.Params{{
+			Key:   "id",
+			Value: "99",
+		}},
 	},
 }, {
-	about: "anonymous body field with pointer field",
+	about: "readonly without source",
 	val: struct {
-		BodyWithPointer `httprequest:",body"`
+		F string `httprequest:",readonly"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field F: can only use readonly with a field source`,
+}, {
+	about: "matrix parameters",
+	val: struct {
+		Thing   string  `httprequest:"thing,path"`
+		Version int     `httprequest:"version,matrix" httpmatrix:"thing"`
+		Lang    string  `httprequest:"lang,matrix" httpmatrix:"thing"`
+		Flag    *string `httprequest:"flag,matrix" httpmatrix:"thing"`
+		Missing string  `httprequest:"missing,matrix" httpmatrix:"thing"`
+		Other   string  `httprequest:"other,path"`
+	}{
+		Thing:   "x",
+		Version: 2,
+		Lang:    "en=GB",
+		Flag:    newString(""),
+		Other:   "y;z=1",
+	},
+	params: httprequest.Params{
+		Request: &http.Request{},
+		PathVar: httprouter.Params{{
+			Key:   "thing",
+			Value: "x;version=2;flag;lang=en=GB",
+		}, {
+			Key:   "other",
+			Value: "y;z=1",
+		}},
+	},
+}, {
+	about: "bad matrix parameter value",
+	val: struct {
+		Version int `httprequest:"version,matrix" httpmatrix:"thing"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{},
+		PathVar: httprouter.Params{{
+			Key:   "thing",
+			Value: "x;version=two",
+		}},
+	},
+	expectError: `cannot unmarshal into field Version: cannot parse matrix parameter "version" of path parameter "thing" value "two" into int: expected integer`,
+}, {
+	about: "matrix field without httpmatrix",
+	val: struct {
+		F string `httprequest:",matrix"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field F: matrix field requires httpmatrix tag`,
+}, {
+	about: "httpmatrix on non-matrix field",
+	val: struct {
+		F string `httprequest:",form" httpmatrix:"thing"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field F: can only use httpmatrix with matrix fields`,
+}, {
+	about: "yaml on interface body field",
+	val: struct {
+		B interface{} `httprequest:",body,yaml"`
+	}{},
+	expectError: `bad type .*: yaml cannot be used with interface type interface {}`,
+}, {
+	about: "streaming body with non-pointer type",
+	val: struct {
+		B json.Decoder `httprequest:",body"`
 	}{},
+	expectError: `bad type .*: streaming body field must be of type \*json.Decoder`,
+}, {
+	about: "raw JSON body",
+	val: struct {
+		B json.RawMessage `httprequest:",body"`
+	}{
+		B: json.RawMessage(`{"a": [1, 2]}`),
+	},
 	params: httprequest.Params{
 		Request: &http.Request{
 			Header: http.Header{"Content-Type": {"application/json"}},
-			Body:   body("{}"),
+			Body:   body(`{"a": [1, 2]}`),
 		},
 	},
 }, {
-	about: "anonymous field with tag",
+	about: "allowed values",
 	val: struct {
-		StructTextUnmarshaler `httprequest:"x,form"`
+		A colour  `httprequest:"a,path"`
+		B colour  `httprequest:"b,form"`
+		C *colour `httprequest:"c,header"`
+		D colour  `httprequest:"d,form"`
 	}{
-		StructTextUnmarshaler{"something"},
+		A: "red",
+		B: "green",
+		C: newColour("blue"),
 	},
 	params: httprequest.Params{
 		Request: &http.Request{
+			Header: http.Header{"C": {"blue"}},
 			Form: url.Values{
-				"x": {"something"},
+				"b": {"green"},
 			},
-			Body: body(`"h val"`),
 		},
 		PathVar: httprouter.Params{{
-			// Note that this is the tagged field in the StructTextUnmarshaler type.
-			Key:   "foo",
-			Value: "ignored",
+			Key:   "a",
+			Value: "red",
+		}},
+	},
+}, {
+	about: "value not in allowed values",
+	val: struct {
+		A colour `httprequest:"a,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"a": {"purple"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field A: invalid form field "a" value "purple" \(allowed values \["red" "green" "blue"\]\)`,
+}, {
+	about: "form value parsers",
+	val: struct {
+		A groupedInt   `httprequest:"a,path"`
+		B groupedInt   `httprequest:"b,form"`
+		C *groupedInt  `httprequest:"c,header"`
+		D []groupedInt `httprequest:"d,form"`
+		E groupedInt   `httprequest:"e,form"`
+		F *groupedInt  `httprequest:"f,form"`
+		T parsedText   `httprequest:"t,form"`
+	}{
+		A: 1000,
+		B: 2500000,
+		C: newGroupedInt(-3000),
+		D: []groupedInt{1, 20000},
+		T: "parsed: x y",
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"C": {"-3 000"}},
+			Form: url.Values{
+				"b": {"2 500 000"},
+				"d": {"1", "20 000"},
+				"t": {"x y"},
+			},
+		},
+		PathVar: httprouter.Params{{
+			Key:   "a",
+			Value: "1 000",
 		}},
 	},
+}, {
+	about: "form value parser error",
+	val: struct {
+		A groupedInt `httprequest:"a,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"a": {"1 0x0"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field A: cannot parse form field "a" value "1 0x0" into httprequest_test.groupedInt: strconv.(ParseInt|Atoi): parsing "10x0": invalid syntax`,
+}, {
+	about: "form value parser error in slice",
+	val: struct {
+		A []groupedInt `httprequest:"a,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"a": {"1", "x"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field A: cannot parse form field "a" value "x" into httprequest_test.groupedInt: strconv.(ParseInt|Atoi): parsing "x": invalid syntax`,
+}, {
+	about: "empty values treated as absent",
+	val: struct {
+		A int            `httprequest:"a,form,treatemptyasabsent"`
+		B *string        `httprequest:"b,form,treatemptyasabsent"`
+		C *textPairValue `httprequest:"c,form,treatemptyasabsent"`
+		D int            `httprequest:"d|e,form,treatemptyasabsent"`
+		H *int           `httprequest:"h,header,treatemptyasabsent"`
+		S *string        `httprequest:"s,form"`
+	}{
+		D: 5,
+		S: newString(""),
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Header: http.Header{"H": {""}},
+			Form: url.Values{
+				"a": {""},
+				"b": {""},
+				"c": {""},
+				"d": {""},
+				"e": {"5"},
+				"s": {""},
+			},
+		},
+	},
+}, {
+	about: "empty value not treated as absent",
+	val: struct {
+		A int `httprequest:"a,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"a": {""},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field A: cannot parse form field "a" value "" into int: .*`,
+}, {
+	about: "valueless form flags",
+	val: struct {
+		Verbose bool      `httprequest:"verbose,form"`
+		Quiet   bool      `httprequest:"quiet,form"`
+		Off     bool      `httprequest:"off,form"`
+		On      bool      `httprequest:"on,form"`
+		P       *bool     `httprequest:"p,form"`
+		Absent  *bool     `httprequest:"absent,form"`
+		Named   namedBool `httprequest:"named,form"`
+		Ignored bool      `httprequest:"ignored,form,treatemptyasabsent"`
+		Limit   int       `httprequest:"limit,form"`
+	}{
+		Verbose: true,
+		Off:     false,
+		On:      true,
+		P:       newBool(true),
+		Named:   true,
+		Limit:   10,
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"verbose": {""},
+				"off":     {"false"},
+				"on":      {"1"},
+				"p":       {""},
+				"named":   {""},
+				"ignored": {""},
+				"limit":   {"10"},
+			},
+		},
+	},
+}, {
+	about: "bad bool form value",
+	val: struct {
+		B bool `httprequest:"b,form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"b": {"tru"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field B: cannot parse form field "b" value "tru" into bool: .*`,
+}, {
+	about: "treatemptyasabsent on path field",
+	val: struct {
+		A int `httprequest:"a,path,treatemptyasabsent"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field A: can only use treatemptyasabsent with form or header fields`,
+}, {
+	about: "empty alternative name",
+	val: struct {
+		F int `httprequest:"limit|,form"`
+	}{},
+	expectError: `bad type .*: bad tag "httprequest:\\"limit\|,form\\"" in field F: empty name in "limit\|"`,
+}, {
+	about: "alternative names on body field",
+	val: struct {
+		B string `httprequest:"a|b,body"`
+	}{},
+	expectError: `bad type .*: bad tag "httprequest:\\"a\|b,body\\"" in field B: can only use alternative names with path, form or header fields`,
 }}
 
 // User represents a user in the system.
@@ -426,13 +1551,18 @@ type SFG struct {
 	G int `httprequest:",form"`
 }
 
+type yamlConfig struct {
+	Name  string `yaml:"name"`
+	Ports []int  `yaml:"ports,omitempty"`
+}
+
 type sFG struct {
 	F int `httprequest:",form"`
 	G int `httprequest:",form"`
 }
 
 func (*unmarshalSuite) TestUnmarshal(c *gc.C) {
-	for i, test := range unmarshalTests[len(unmarshalTests)-1:] {
+	for i, test := range unmarshalTests {
 		c.Logf("%d: %s", i, test.about)
 		t := reflect.TypeOf(test.val)
 		fillv := reflect.New(t)
@@ -446,6 +1576,183 @@ func (*unmarshalSuite) TestUnmarshal(c *gc.C) {
 	}
 }
 
+// The pointerFields types hold pointer fields of all the kinds that
+// can be unmarshaled from a single path, form or header value.
+type pathPointerFields struct {
+	S *string        `httprequest:"s,path"`
+	I *int           `httprequest:"i,path"`
+	T *textPairValue `httprequest:"t,path"`
+	P *groupedInt    `httprequest:"p,path"`
+	C *colour        `httprequest:"c,path"`
+	B *int           `httprequest:"b,path" httpbase:"16"`
+	R *int           `httprequest:"r,path" httpmin:"1"`
+}
+
+type formPointerFields struct {
+	S *string        `httprequest:"s,form"`
+	I *int           `httprequest:"i,form"`
+	T *textPairValue `httprequest:"t,form"`
+	P *groupedInt    `httprequest:"p,form"`
+	C *colour        `httprequest:"c,form"`
+	B *int           `httprequest:"b,form" httpbase:"16"`
+	R *int           `httprequest:"r,form" httpmin:"1"`
+}
+
+type headerPointerFields struct {
+	S *string        `httprequest:"s,header"`
+	I *int           `httprequest:"i,header"`
+	T *textPairValue `httprequest:"t,header"`
+	P *groupedInt    `httprequest:"p,header"`
+	C *colour        `httprequest:"c,header"`
+	B *int           `httprequest:"b,header" httpbase:"16"`
+	R *int           `httprequest:"r,header" httpmin:"1"`
+}
+
+// pointerFieldValues holds the values used to
+// fill out the pointerFields types.
+var pointerFieldValues = map[string]string{
+	"s": "",
+	"i": "0",
+	"t": "a-b",
+	"p": "1 000",
+	"c": "red",
+	"b": "ff",
+	"r": "5",
+}
+
+func (*unmarshalSuite) TestUnmarshalPointerFields(c *gc.C) {
+	groupedThousand := groupedInt(1000)
+	present := []interface{}{
+		newString(""),
+		newInt(0),
+		&textPairValue{A: "a", B: "b"},
+		&groupedThousand,
+		newColour("red"),
+		newInt(255),
+		newInt(5),
+	}
+	var pathVars httprouter.Params
+	form := make(url.Values)
+	header := make(http.Header)
+	for k, v := range pointerFieldValues {
+		pathVars = append(pathVars, httprouter.Param{Key: k, Value: v})
+		form.Set(k, v)
+		header.Set(k, v)
+	}
+	for _, val := range []interface{}{
+		&pathPointerFields{},
+		&formPointerFields{},
+		&headerPointerFields{},
+	} {
+		t := reflect.TypeOf(val).Elem()
+		c.Logf("%s", t)
+
+		// When there are no values, all the fields remain nil.
+		v := reflect.New(t)
+		err := httprequest.Unmarshal(httprequest.Params{
+			Request: &http.Request{
+				Header: make(http.Header),
+				Form:   make(url.Values),
+			},
+		}, v.Interface())
+		c.Assert(err, gc.IsNil)
+		c.Assert(v.Elem().Interface(), jc.DeepEquals, reflect.Zero(t).Interface())
+
+		// When there are values, all the fields are allocated
+		// and set, even when the value is the zero value.
+		v = reflect.New(t)
+		err = httprequest.Unmarshal(httprequest.Params{
+			Request: &http.Request{
+				Header: header,
+				Form:   form,
+			},
+			PathVar: pathVars,
+		}, v.Interface())
+		c.Assert(err, gc.IsNil)
+		for i, expect := range present {
+			c.Assert(v.Elem().Field(i).Interface(), jc.DeepEquals, expect, gc.Commentf("field %s", t.Field(i).Name))
+		}
+	}
+}
+
+// paramsUnmarshalerRequest implements httprequest.ParamsUnmarshaler
+// by reading its fields directly.
+type paramsUnmarshalerRequest struct {
+	httprequest.Route `httprequest:"GET /items/:id"`
+	ID                string `httprequest:"id,path"`
+	Limit             int    `httprequest:"limit,form"`
+}
+
+func (r *paramsUnmarshalerRequest) UnmarshalParams(p httprequest.Params) error {
+	r.ID = p.PathVar.ByName("id")
+	if s := p.Request.Form.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil {
+			return errgo.Newf("bad limit %q", s)
+		}
+		r.Limit = limit
+	}
+	return nil
+}
+
+func (*unmarshalSuite) TestUnmarshalWithParamsUnmarshaler(c *gc.C) {
+	p := httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"limit": {"20"},
+			},
+		},
+		PathVar: httprouter.Params{{
+			Key:   "id",
+			Value: "x",
+		}},
+	}
+	var r paramsUnmarshalerRequest
+	err := httprequest.Unmarshal(p, &r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(r, jc.DeepEquals, paramsUnmarshalerRequest{
+		ID:    "x",
+		Limit: 20,
+	})
+
+	p.Request.Form.Set("limit", "many")
+	err = httprequest.Unmarshal(p, &r)
+	c.Assert(err, gc.ErrorMatches, `bad limit "many"`)
+	c.Assert(errgo.Cause(err), gc.Equals, httprequest.ErrUnmarshal)
+
+	err = httprequest.UnmarshalAll(p, &r)
+	c.Assert(err, gc.ErrorMatches, `bad limit "many"`)
+	c.Assert(errgo.Cause(err), gc.Equals, httprequest.ErrUnmarshal)
+}
+
+func (*unmarshalSuite) TestPrewarmType(c *gc.C) {
+	httprequest.ResetTypeCache()
+	c.Assert(httprequest.TypeCacheLen(), gc.Equals, 0)
+
+	type req struct {
+		A int `httprequest:"a,form"`
+	}
+	err := httprequest.PrewarmType((*req)(nil))
+	c.Assert(err, gc.IsNil)
+	c.Assert(httprequest.TypeCacheLen(), gc.Equals, 1)
+
+	// Using the type does not add it to the cache again.
+	var r req
+	err = httprequest.Unmarshal(httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{"a": {"1"}},
+		},
+	}, &r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.A, gc.Equals, 1)
+	c.Assert(httprequest.TypeCacheLen(), gc.Equals, 1)
+
+	err = httprequest.PrewarmType(req{})
+	c.Assert(err, gc.ErrorMatches, `bad type httprequest_test.req: type is not pointer to struct`)
+	c.Assert(errgo.Cause(err), gc.Equals, httprequest.ErrBadUnmarshalType)
+	c.Assert(httprequest.TypeCacheLen(), gc.Equals, 1)
+}
+
 // TODO non-pointer struct
 
 type notTextUnmarshaler string
@@ -472,6 +1779,79 @@ type StructTextUnmarshaler struct {
 	Foo string `httprequest:"foo,path"`
 }
 
+// colour is a string type that may only hold
+// one of a fixed set of values.
+type colour string
+
+func (colour) AllowedValues() []string {
+	return []string{"red", "green", "blue"}
+}
+
+func newColour(c colour) *colour {
+	return &c
+}
+
+func newUint8(i uint8) *uint8 {
+	return &i
+}
+
+// groupedInt is an integer type whose form values
+// may hold spaces between groups of digits, which
+// fmt.Sscan would not accept.
+type groupedInt int
+
+func (n *groupedInt) ParseFormValue(s string) error {
+	i, err := strconv.Atoi(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		return err
+	}
+	*n = groupedInt(i)
+	return nil
+}
+
+func newGroupedInt(n groupedInt) *groupedInt {
+	return &n
+}
+
+// parsedText implements both FormValueParser and
+// TextUnmarshaler so that we can check that
+// ParseFormValue takes precedence.
+type parsedText string
+
+func (t *parsedText) ParseFormValue(s string) error {
+	*t = parsedText("parsed: " + s)
+	return nil
+}
+
+func (t *parsedText) UnmarshalText(data []byte) error {
+	*t = parsedText("unmarshaled: " + string(data))
+	return nil
+}
+
+// textPairValue is a TextUnmarshaler that
+// rejects empty values.
+type textPairValue struct {
+	A, B string
+}
+
+func (t *textPairValue) UnmarshalText(data []byte) error {
+	parts := strings.SplitN(string(data), "-", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid text pair %q", data)
+	}
+	t.A, t.B = parts[0], parts[1]
+	return nil
+}
+
+type CommonParams struct {
+	Limit int    `httprequest:"limit,form"`
+	Token string `httprequest:",header"`
+}
+
+type PtrCommonParams struct {
+	ID string `httprequest:"id,path"`
+}
+
 func (t *StructTextUnmarshaler) UnmarshalText(data []byte) error {
 	t.Foo = string(data)
 	return nil
@@ -481,6 +1861,18 @@ func newInt(i int) *int {
 	return &i
 }
 
+func newInt32(i int32) *int32 {
+	return &i
+}
+
+func newDuration(d time.Duration) *time.Duration {
+	return &d
+}
+
+func newUint64(n uint64) *uint64 {
+	return &n
+}
+
 func newString(s string) *string {
 	return &s
 }
@@ -498,3 +1890,9 @@ func (r errorReader) Close() error {
 func body(s string) io.ReadCloser {
 	return ioutil.NopCloser(strings.NewReader(s))
 }
+
+type namedBool bool
+
+func newBool(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// yamlMediaTypes holds the media types accepted for
+// a YAML request body.
+var yamlMediaTypes = []string{
+	"application/yaml",
+	"application/x-yaml",
+	"text/yaml",
+	"text/x-yaml",
+}
+
+// unmarshalYAMLBody returns an unmarshaler that unmarshals the http
+// request body as YAML. If contentType is non-empty, it is accepted as
+// the request content type as well as the YAML media types. If
+// optional is true, the field is left untouched when the body is
+// empty, as for unmarshalOptionalBody.
+func unmarshalYAMLBody(contentType string, optional bool) unmarshaler {
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		if p.Request.Body == nil && optional {
+			return nil
+		}
+		var data []byte
+		if p.Request.Body != nil {
+			var err error
+			data, err = ioutil.ReadAll(p.Request.Body)
+			if err != nil {
+				return errgo.Notef(err, "cannot read request body")
+			}
+		}
+		if len(data) == 0 && optional {
+			return nil
+		}
+		if !isYAMLBodyMediaType(p.Request.Header, contentType) {
+			mediaType, _, _ := mime.ParseMediaType(p.Request.Header.Get("Content-Type"))
+			req := *p.Request
+			req.Body = ioutil.NopCloser(bytes.NewReader(data))
+			return newDecodeRequestError(&req, nil, errgo.Newf("unexpected content type %q; want application/yaml", mediaType))
+		}
+		if err := yaml.Unmarshal(data, makeResult(v).Addr().Interface()); err != nil {
+			return errgo.Notef(err, "cannot unmarshal request body")
+		}
+		return nil
+	}
+}
+
+// isYAMLBodyMediaType reports whether the content type of the given
+// header is acceptable for a YAML request body. If contentType is
+// non-empty, that media type is accepted in addition to the YAML media
+// types.
+func isYAMLBodyMediaType(h http.Header, contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if contentType != "" && mediaType == contentType {
+		return true
+	}
+	for _, t := range yamlMediaTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalYAMLBody returns a marshaler that marshals the specified
+// value as YAML into the body of the http request, with the given
+// content type. If contentType is empty, application/yaml is used.
+func marshalYAMLBody(contentType string) marshaler {
+	if contentType == "" {
+		contentType = "application/yaml"
+	}
+	return func(v reflect.Value, p *Params) error {
+		data, err := yaml.Marshal(v.Addr().Interface())
+		if err != nil {
+			return errgo.Notef(err, "cannot marshal request body")
+		}
+		p.Request.Body = BytesReaderCloser{bytes.NewReader(data)}
+		p.Request.ContentLength = int64(len(data))
+		p.Request.Header.Set("Content-Type", contentType)
+		return nil
+	}
+}